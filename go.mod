@@ -4,6 +4,11 @@ go 1.13
 
 require (
 	github.com/fatih/structs v1.1.0
+	github.com/go-sql-driver/mysql v1.4.1
 	github.com/ks3sdklib/aws-sdk-go v0.0.0-20191128113133-b330986da295
+	github.com/spf13/viper v1.6.1
 	github.com/streadway/amqp v0.0.0-20200108173154-1c71cc93ed71
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	go.uber.org/zap v1.13.0
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -0,0 +1,163 @@
+package mq
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"sync"
+)
+
+const (
+	CONTENT_TYPE_JSON    = "application/json"
+	CONTENT_TYPE_BYTES   = "application/octet-stream" // 原始字节 兼容protobuf等实现Marshal/Unmarshal的类型
+	CONTENT_TYPE_MSGPACK = "application/msgpack"
+	CONTENT_TYPE_TEXT    = "text/plain" // Publish使用的类型 按JSON解析
+)
+
+// Codec 消息体编解码
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	BytesCodec   Codec = bytesCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		CONTENT_TYPE_JSON:    JSONCodec,
+		CONTENT_TYPE_BYTES:   BytesCodec,
+		CONTENT_TYPE_MSGPACK: MsgpackCodec,
+		CONTENT_TYPE_TEXT:    JSONCodec,
+		"":                   JSONCodec,
+	}
+)
+
+/**
+ * RegisterCodec 注册编解码 接收消息时按content-type选择 同类型重复注册时覆盖
+ * @params codec
+ */
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	codecs[codec.ContentType()] = codec
+	codecsMu.Unlock()
+}
+
+// CodecFor 按content-type获取已注册的编解码
+func CodecFor(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	codec, ok := codecs[contentType]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, errors.New("未注册的content-type:" + contentType)
+	}
+	return codec, nil
+}
+
+/**
+ * NewCodec 使用编解码函数构造Codec
+ * @params contentType
+ * @params marshal 编码函数
+ * @params unmarshal 解码函数
+ * @return Codec
+ */
+func NewCodec(contentType string, marshal func(interface{}) ([]byte, error), unmarshal func([]byte, interface{}) error) Codec {
+	return funcCodec{contentType: contentType, marshal: marshal, unmarshal: unmarshal}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return CONTENT_TYPE_JSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec 结构体未设置msgpack标签时使用json标签 与JSONCodec的字段名一致
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return CONTENT_TYPE_MSGPACK
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(v)
+}
+
+// bytesCodec 支持[]byte encoding.BinaryMarshaler 以及protobuf生成的Marshal/Unmarshal方法
+type bytesCodec struct{}
+
+func (bytesCodec) ContentType() string {
+	return CONTENT_TYPE_BYTES
+}
+
+func (bytesCodec) Marshal(v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case *[]byte:
+		return *value, nil
+	case interface{ Marshal() ([]byte, error) }:
+		return value.Marshal()
+	case encoding.BinaryMarshaler:
+		return value.MarshalBinary()
+	}
+	return nil, errors.New("不支持字节编码的类型")
+}
+
+func (bytesCodec) Unmarshal(data []byte, v interface{}) error {
+	switch value := v.(type) {
+	case *[]byte:
+		*value = append((*value)[:0], data...)
+		return nil
+	case interface{ Unmarshal([]byte) error }:
+		return value.Unmarshal(data)
+	case encoding.BinaryUnmarshaler:
+		return value.UnmarshalBinary(data)
+	}
+	return errors.New("不支持字节解码的类型")
+}
+
+type funcCodec struct {
+	contentType string
+	marshal     func(interface{}) ([]byte, error)
+	unmarshal   func([]byte, interface{}) error
+}
+
+func (codec funcCodec) ContentType() string {
+	return codec.contentType
+}
+
+func (codec funcCodec) Marshal(v interface{}) ([]byte, error) {
+	return codec.marshal(v)
+}
+
+func (codec funcCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.unmarshal(data, v)
+}
@@ -0,0 +1,343 @@
+package mq
+
+import (
+	"bytes"
+	"errors"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CONFIRM_BUFFER = 256
+)
+
+var (
+	ErrConfirmNack    = errors.New("rabbitmq拒绝了消息")
+	ErrConfirmReturn  = errors.New("消息无法路由被退回")
+	ErrConfirmTimeout = errors.New("等待rabbitmq确认超时")
+	ErrConfirmClosed  = errors.New("等待确认时连接已关闭")
+	ErrConfirmPending = errors.New("消息尚未确认")
+)
+
+// Confirmation 单条消息的确认结果
+type Confirmation struct {
+	Tag    uint64       // 投递序号
+	Return *amqp.Return // 消息被退回时的详情
+	done   chan struct{}
+	err    error
+
+	// 用于匹配退回的消息 确认后清空
+	exchange  string
+	key       string
+	messageId string
+	body      []byte
+}
+
+// Done 确认完成时关闭
+func (c *Confirmation) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 确认结果 nil代表rabbitmq已确认 Done关闭前返回ErrConfirmPending
+func (c *Confirmation) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return ErrConfirmPending
+	}
+}
+
+/**
+ * Wait 等待确认结果
+ * @params timeout 超时时间 小于等于0时一直等待
+ * @return nil 已确认 ErrConfirmNack ErrConfirmReturn ErrConfirmTimeout ErrConfirmClosed
+ */
+func (c *Confirmation) Wait(timeout time.Duration) error {
+	if timeout <= 0 {
+		<-c.done
+		return c.err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return c.err
+	case <-timer.C:
+		return ErrConfirmTimeout
+	}
+}
+
+func (c *Confirmation) resolve(err error) {
+	c.err = err
+	close(c.done)
+}
+
+// confirmer 维护确认模式下未确认的消息
+// seq保证发布顺序与投递序号一致 发布期间持有 mu只保护pending 不跨越发布持有
+// 通道在NotifyPublish缓冲满时会阻塞发布 listen处理确认只需mu 不会与阻塞的发布互相等待
+type confirmer struct {
+	seq     sync.Mutex
+	mu      sync.Mutex
+	tag     uint64 // 最后一次发布的投递序号 从1开始
+	pending map[uint64]*Confirmation
+}
+
+/**
+ * EnableConfirm 开启发布确认模式 开启后Publish使用mandatory发布
+ * 无法路由的消息会被退回 可通过PublishConfirm PublishAsync获取确认结果
+ */
+func (p *ProducerMQ) EnableConfirm() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.confirms != nil {
+		return nil
+	}
+	if !p.connected {
+		return ErrDisconnected
+	}
+	c, err := newConfirmer(p.channel)
+	if err != nil {
+		return err
+	}
+	p.confirms = c
+	return nil
+}
+
+// newConfirmer 在通道上开启确认模式并监听确认和退回
+func newConfirmer(channel *amqp.Channel) (*confirmer, error) {
+	err := channel.Confirm(false)
+	if err != nil {
+		return nil, errors.New("开启确认模式失败:" + err.Error())
+	}
+	c := &confirmer{pending: map[uint64]*Confirmation{}}
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, DEFAULT_CONFIRM_BUFFER))
+	returns := channel.NotifyReturn(make(chan amqp.Return, DEFAULT_CONFIRM_BUFFER))
+	go c.listen(confirms, returns)
+	return c, nil
+}
+
+/**
+ * PublishConfirm 推送消息并等待rabbitmq确认
+ * @params msg 消息内容
+ * @params timeout 等待确认的超时时间 小于等于0时一直等待
+ */
+func (p *ProducerMQ) PublishConfirm(msg string, timeout time.Duration) error {
+	confirmation, err := p.PublishAsync(msg)
+	if err != nil {
+		return err
+	}
+	return confirmation.Wait(timeout)
+}
+
+/**
+ * PublishAsync 推送消息不等待确认 通过返回的Confirmation获取结果
+ * 未开启确认模式时自动开启
+ * @params msg 消息内容
+ * @return Confirmation err 发布失败时返回err
+ */
+func (p *ProducerMQ) PublishAsync(msg string) (*Confirmation, error) {
+	queue, exchange, key, err := p.target()
+	if err != nil {
+		return nil, err
+	}
+	err = p.EnableConfirm()
+	if err != nil {
+		return nil, err
+	}
+	confirmation, err := p.publish(exchange, key, amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(msg),
+	})
+	if err != nil {
+		return nil, err
+	}
+	printLog(0, msg, queue)
+	return confirmation, nil
+}
+
+/**
+ * PublishBatch 批量推送消息 全部发布后统一等待确认
+ * @params msgs 消息内容
+ * @return 每条消息的Confirmation 发布失败时返回已发布部分和err
+ */
+func (p *ProducerMQ) PublishBatch(msgs []string) ([]*Confirmation, error) {
+	confirmations := make([]*Confirmation, 0, len(msgs))
+	for _, msg := range msgs {
+		confirmation, err := p.PublishAsync(msg)
+		if err != nil {
+			return confirmations, err
+		}
+		confirmations = append(confirmations, confirmation)
+	}
+	return confirmations, nil
+}
+
+/**
+ * WaitConfirms 等待一批消息的确认结果
+ * @params confirmations PublishBatch的返回
+ * @params timeout 整批的超时时间
+ * @return 与confirmations一一对应的结果
+ */
+func WaitConfirms(confirmations []*Confirmation, timeout time.Duration) []error {
+	deadline := time.Now().Add(timeout)
+	errs := make([]error, len(confirmations))
+	for i, confirmation := range confirmations {
+		remain := deadline.Sub(time.Now())
+		if timeout > 0 && remain <= 0 {
+			remain = time.Nanosecond
+		}
+		errs[i] = confirmation.Wait(remain)
+	}
+	return errs
+}
+
+// publish 发布消息 开启确认模式时以mandatory发布并登记投递序号 未开启时返回nil
+// 断线期间按ReconnectPolicy缓存或返回ErrDisconnected
+func (p *ProducerMQ) publish(exchange, key string, publishing amqp.Publishing) (*Confirmation, error) {
+	return p.send(bufferedPublishing{exchange: exchange, key: key, publishing: publishing})
+}
+
+func (p *ProducerMQ) send(item bufferedPublishing) (*Confirmation, error) {
+	p.mu.Lock()
+	if !p.connected {
+		defer p.mu.Unlock()
+		return p.buffered(item)
+	}
+	channel, c := p.channel, p.confirms
+	p.mu.Unlock()
+	confirmation, err := c.publish(channel, item.exchange, item.key, item.publishing)
+	if err == amqp.ErrClosed {
+		// 连接已断开但尚未收到关闭通知
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.buffered(item)
+	}
+	return confirmation, err
+}
+
+// publisher 发布消息的通道 *amqp.Channel
+type publisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// publish 在通道上发布 c为nil时不使用确认模式
+func (c *confirmer) publish(channel publisher, exchange, key string, publishing amqp.Publishing) (*Confirmation, error) {
+	if c == nil {
+		return nil, channel.Publish(exchange, key, false, false, publishing)
+	}
+	// 投递序号由rabbitmq按发布顺序分配 发布和计数需在seq内
+	c.seq.Lock()
+	defer c.seq.Unlock()
+	confirmation := &Confirmation{
+		done:      make(chan struct{}),
+		exchange:  exchange,
+		key:       key,
+		messageId: publishing.MessageId,
+		body:      publishing.Body,
+	}
+	// 发布前登记 确认可能在Publish返回前到达
+	c.mu.Lock()
+	confirmation.Tag = c.tag + 1
+	c.pending[confirmation.Tag] = confirmation
+	c.mu.Unlock()
+	err := channel.Publish(exchange, key, true, false, publishing)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(c.pending, confirmation.Tag)
+		return nil, err
+	}
+	c.tag = confirmation.Tag
+	return confirmation, nil
+}
+
+// listen 分发确认和退回结果 退回先于确认到达
+func (c *confirmer) listen(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for confirms != nil {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.returned(ret)
+		case confirm, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+			// 确认前先处理已到达的退回消息
+			c.drain(returns)
+			c.confirm(confirm)
+		}
+	}
+	c.closeAll()
+}
+
+func (c *confirmer) drain(returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			c.returned(ret)
+		default:
+			return
+		}
+	}
+}
+
+// returned 退回的消息归属于交换机 路由键 MessageId和内容都相同且投递序号最小的未确认消息
+// 内容相同的消息路由结果相同 归属哪一条不影响确认结果
+func (c *confirmer) returned(ret amqp.Return) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var matched *Confirmation
+	for tag, confirmation := range c.pending {
+		if confirmation.Return != nil || (matched != nil && matched.Tag < tag) {
+			continue
+		}
+		if confirmation.exchange == ret.Exchange && confirmation.key == ret.RoutingKey &&
+			confirmation.messageId == ret.MessageId && bytes.Equal(confirmation.body, ret.Body) {
+			matched = confirmation
+		}
+	}
+	if matched != nil {
+		r := ret
+		matched.Return = &r
+	}
+}
+
+func (c *confirmer) confirm(confirm amqp.Confirmation) {
+	c.mu.Lock()
+	confirmation, ok := c.pending[confirm.DeliveryTag]
+	delete(c.pending, confirm.DeliveryTag)
+	if ok {
+		confirmation.body = nil
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	switch {
+	case !confirm.Ack:
+		confirmation.resolve(ErrConfirmNack)
+	case confirmation.Return != nil:
+		confirmation.resolve(ErrConfirmReturn)
+	default:
+		confirmation.resolve(nil)
+	}
+}
+
+// closeAll 连接关闭时未确认的消息全部失败
+func (c *confirmer) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tag, confirmation := range c.pending {
+		confirmation.resolve(ErrConfirmClosed)
+		delete(c.pending, tag)
+	}
+}
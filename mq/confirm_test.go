@@ -0,0 +1,107 @@
+package mq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestConfirmer(t *testing.T) {
+	c := &confirmer{pending: map[uint64]*Confirmation{}}
+	confirmations := make([]*Confirmation, 4)
+	for i := range confirmations {
+		confirmations[i] = &Confirmation{Tag: uint64(i + 1), done: make(chan struct{}), exchange: "order", key: "paid", body: []byte{byte('1' + i)}}
+		c.pending[uint64(i+1)] = confirmations[i]
+	}
+	confirms := make(chan amqp.Confirmation, 4)
+	returns := make(chan amqp.Return, 4)
+	go c.listen(confirms, returns)
+
+	returns <- amqp.Return{ReplyText: "NO_ROUTE", Exchange: "order", RoutingKey: "paid", Body: []byte("2")}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: false}
+	close(confirms)
+
+	errs := WaitConfirms(confirmations, time.Second)
+	expects := []error{nil, ErrConfirmReturn, ErrConfirmNack, ErrConfirmClosed}
+	for i, err := range errs {
+		if err != expects[i] {
+			t.Fatalf("第%d条确认结果错误: %v", i+1, err)
+		}
+	}
+	if confirmations[1].Return == nil || confirmations[1].Return.ReplyText != "NO_ROUTE" {
+		t.Fatal("退回详情缺失")
+	}
+}
+
+// confirmingChannel 模拟确认模式的通道 发布时同步投递确认 确认未被取走时发布阻塞
+type confirmingChannel struct {
+	tag      uint64
+	fail     bool
+	confirms chan amqp.Confirmation
+}
+
+func (f *confirmingChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if f.fail {
+		return amqp.ErrClosed
+	}
+	if msg.Headers != nil {
+		return errors.New("不应修改消息头")
+	}
+	f.tag++
+	f.confirms <- amqp.Confirmation{DeliveryTag: f.tag, Ack: true}
+	return nil
+}
+
+func TestConfirmerPublish(t *testing.T) {
+	c := &confirmer{pending: map[uint64]*Confirmation{}}
+	channel := &confirmingChannel{confirms: make(chan amqp.Confirmation)}
+	go c.listen(channel.confirms, nil)
+
+	// 确认在Publish返回前到达 发布不能持有listen需要的锁
+	done := make(chan []*Confirmation)
+	go func() {
+		var confirmations []*Confirmation
+		for _, body := range []string{"1", "2", "3"} {
+			confirmation, err := c.publish(channel, "order", "paid", amqp.Publishing{Body: []byte(body)})
+			if err != nil {
+				t.Error(err)
+			}
+			confirmations = append(confirmations, confirmation)
+		}
+		done <- confirmations
+	}()
+	var confirmations []*Confirmation
+	select {
+	case confirmations = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("确认阻塞时发布死锁")
+	}
+	for i, confirmation := range confirmations {
+		if confirmation.Tag != uint64(i+1) || confirmation.Wait(time.Second) != nil {
+			t.Fatalf("第%d条消息确认错误: %d %v", i+1, confirmation.Tag, confirmation.Err())
+		}
+	}
+
+	// 发布失败不占用投递序号
+	channel.fail = true
+	if _, err := c.publish(channel, "order", "paid", amqp.Publishing{Body: []byte("4")}); err != amqp.ErrClosed {
+		t.Fatalf("发布失败应返回err: %v", err)
+	}
+	if c.tag != 3 || len(c.pending) != 0 {
+		t.Fatalf("发布失败后投递序号错误: %d %d", c.tag, len(c.pending))
+	}
+
+	// 重连后新通道的投递序号从1开始
+	renewed := &confirmer{pending: map[uint64]*Confirmation{}}
+	channel = &confirmingChannel{confirms: make(chan amqp.Confirmation, 1)}
+	confirmation, err := renewed.publish(channel, "order", "paid", amqp.Publishing{Body: []byte("5")})
+	if err != nil || confirmation.Tag != 1 {
+		t.Fatalf("新通道投递序号应从1开始: %v %v", confirmation, err)
+	}
+	if confirmation.Err() != ErrConfirmPending {
+		t.Fatalf("确认前Err应返回ErrConfirmPending: %v", confirmation.Err())
+	}
+}
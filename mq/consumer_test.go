@@ -0,0 +1,195 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsumerStop(t *testing.T) {
+	// 连接不上时不panic 启动后持续重连直到Stop
+	mq := NewConsumerMQ("guest", "guest", "127.0.0.1", "1", "/")
+	mq.SetReconnect(ReconnectPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	health := mq.Health()
+	if health.Status != STATUS_CONNECTING || health.Connected || health.LastError == "" {
+		t.Fatalf("连接失败后的状态错误: %+v", health)
+	}
+	done := make(chan struct{})
+	go func() {
+		mq.Start()
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	mq.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop后Start未返回")
+	}
+	if mq.Status() != STATUS_STOPPED {
+		t.Fatalf("Stop后状态错误: %d", mq.Status())
+	}
+}
+
+func TestConsumerRun(t *testing.T) {
+	mq := NewConsumerMQ("guest", "guest", "127.0.0.1", "1", "/")
+	mq.SetReconnect(ReconnectPolicy{MinBackoff: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- mq.Run(ctx)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("未连接时取消应直接返回: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("取消后Run未返回")
+	}
+	if mq.Status() != STATUS_STOPPED {
+		t.Fatalf("Run返回后状态错误: %d", mq.Status())
+	}
+}
+
+func TestReceiverOptions(t *testing.T) {
+	mq := &ConsumerMQ{}
+	mq.RegisterReceiverWithOptions("order", 1, Queue1, ReceiverOptions{})
+	mq.RegisterReceiverWithOptions("order", 1, Queue1, ReceiverOptions{Concurrency: 4, Prefetch: 2})
+	mq.RegisterReceiverWithOptions("order", 1, Queue1, ReceiverOptions{Concurrency: 4, Prefetch: 10})
+	expected := [][2]int{{1, 1}, {4, 4}, {4, 10}}
+	for i, receiver := range mq.receivers {
+		if receiver.options.Concurrency != expected[i][0] || receiver.options.Prefetch != expected[i][1] {
+			t.Errorf("第%d个接收者的并发配置错误: %+v", i, receiver.options)
+		}
+	}
+}
+
+func TestServeConcurrency(t *testing.T) {
+	const workers = 4
+	var running, peak int32
+	release := make(chan struct{})
+	receiver := Receiver{queueName: "order", logStat: 1, options: ReceiverOptions{Concurrency: workers, MaxAttempts: 1}}
+	receiver.receiveFunc = func(body []byte) int {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return RECEIVE_ACK
+	}
+	msgs := make(chan amqp.Delivery, workers*2)
+	acks := make([]*fakeAcknowledger, workers*2)
+	for i := range acks {
+		acks[i] = &fakeAcknowledger{}
+		msgs <- amqp.Delivery{Acknowledger: acks[i]}
+	}
+	close(msgs)
+	done := make(chan struct{})
+	go func() {
+		serve(nil, receiver, msgs)
+		close(done)
+	}()
+	// 所有worker都阻塞在处理函数中 说明共同消费同一个投递通道
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&running) < workers && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("投递通道关闭后serve未返回")
+	}
+	if peak != workers {
+		t.Fatalf("并发处理数错误: %d", peak)
+	}
+	for i, ack := range acks {
+		if !ack.acked {
+			t.Errorf("第%d条消息未确认", i)
+		}
+	}
+}
+
+// fakeConnection 第一次获取通道失败 之后获取的通道一直可用直到连接关闭
+type fakeConnection struct {
+	mu     sync.Mutex
+	calls  int
+	closed chan struct{}
+}
+
+func (f *fakeConnection) Channel() (*amqp.Channel, error) {
+	f.mu.Lock()
+	f.calls++
+	first := f.calls == 1
+	f.mu.Unlock()
+	if first {
+		return nil, errors.New("channel exception")
+	}
+	<-f.closed
+	return nil, amqp.ErrClosed
+}
+
+func (f *fakeConnection) IsClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestListenIsolation(t *testing.T) {
+	mq := &ConsumerMQ{stop: make(chan struct{}), channels: map[string]*amqp.Channel{}}
+	mq.SetReconnect(ReconnectPolicy{MinBackoff: 10 * time.Millisecond})
+	mq.RegisterReceiver("order", 1, Queue1)
+	mq.RegisterReceiver("refund", 1, Queue1)
+	conn := &fakeConnection{closed: make(chan struct{})}
+	for i, receiver := range mq.receivers {
+		mq.wg.Add(1)
+		go mq.listen(conn, consumerTag(i, receiver), receiver)
+	}
+	done := make(chan struct{})
+	go func() {
+		mq.wg.Wait()
+		close(done)
+	}()
+	// 一个接收者获取通道失败后单独重新订阅 另一个接收者不受影响
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		conn.mu.Lock()
+		calls := conn.calls
+		conn.mu.Unlock()
+		if calls >= 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("单个接收者的通道异常不应导致接收者退出")
+	default:
+	}
+	conn.mu.Lock()
+	calls := conn.calls
+	conn.mu.Unlock()
+	if calls != 3 {
+		t.Fatalf("应只重新订阅失败的接收者: %d", calls)
+	}
+	close(conn.closed)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("连接关闭后接收者未退出")
+	}
+}
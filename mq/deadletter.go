@@ -0,0 +1,277 @@
+package mq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	DEFAULT_DEAD_LETTER_EXCHANGE = "dlx"      // 默认死信交换机
+	PARKING_QUEUE_SUFFIX         = ".parking" // 停车场队列后缀 存放无法处理的消息等待人工处理
+)
+
+// DeadMessage 停车场队列中的消息
+type DeadMessage struct {
+	Body        []byte
+	Headers     amqp.Table
+	OriginQueue string    // 原始队列
+	Reason      string    // 转入死信的原因
+	Attempts    int       // 已处理次数
+	Timestamp   time.Time // 原消息的发布时间
+}
+
+// ParkingLot 停车场队列管理 查看 重放和清空死信
+type ParkingLot struct {
+	open        func() (parkingChannel, error) // 打开临时通道
+	originQueue string
+	queue       string
+}
+
+/**
+ * DeadLetterArgs 构造队列的死信参数 用于QueueDeclare的args
+ * @params exchange 死信交换机
+ * @params routingKey 死信路由键 为空时保留消息原路由键
+ * @return amqp.Table
+ */
+func DeadLetterArgs(exchange, routingKey string) amqp.Table {
+	args := amqp.Table{"x-dead-letter-exchange": exchange}
+	if routingKey != "" {
+		args["x-dead-letter-routing-key"] = routingKey
+	}
+	return args
+}
+
+/**
+ * DeclareParkingLot 声明死信交换机和队列对应的停车场队列 停车场队列以原队列名为路由键绑定到死信交换机
+ * @params channel
+ * @params queue 原队列名
+ * @params exchange 死信交换机 为空时使用DEFAULT_DEAD_LETTER_EXCHANGE
+ */
+func DeclareParkingLot(channel *amqp.Channel, queue, exchange string) error {
+	if exchange == "" {
+		exchange = DEFAULT_DEAD_LETTER_EXCHANGE
+	}
+	err := channel.ExchangeDeclare(exchange, "direct", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	_, err = channel.QueueDeclare(queue+PARKING_QUEUE_SUFFIX, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	return channel.QueueBind(queue+PARKING_QUEUE_SUFFIX, queue, exchange, false, nil)
+}
+
+/**
+ * DeclareWithDeadLetter 同Declare 队列额外设置死信参数并声明停车场队列
+ * 已存在且参数不同的队列会声明失败 需先删除队列或使用ReceiverOptions.ParkingLot由消费者主动转发
+ * 同一队列不能再使用Declare声明
+ * @params queue 队列名
+ * @params exchange 交换机
+ * @params key 路由键
+ * @params deadLetterExchange 死信交换机 为空时使用DEFAULT_DEAD_LETTER_EXCHANGE
+ */
+func (p *ProducerMQ) DeclareWithDeadLetter(queue, exchange, key, deadLetterExchange string) error {
+	if deadLetterExchange == "" {
+		deadLetterExchange = DEFAULT_DEAD_LETTER_EXCHANGE
+	}
+	err := p.declareTopology("parking:"+queue+":"+deadLetterExchange, func(channel *amqp.Channel) error {
+		return DeclareParkingLot(channel, queue, deadLetterExchange)
+	})
+	if err != nil && err != ErrDisconnected {
+		return err
+	}
+	return p.declareQueue(queue, exchange, key, DeadLetterArgs(deadLetterExchange, queue))
+}
+
+// parkingChannel 停车场队列操作使用的通道 *amqp.Channel
+type parkingChannel interface {
+	QueueInspect(name string) (amqp.Queue, error)
+	QueuePurge(name string, noWait bool) (int, error)
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// ParkingLot 获取生产者连接上的停车场队列管理 每次操作使用当前连接上的临时通道 断线期间返回ErrDisconnected
+func (p *ProducerMQ) ParkingLot(queue string) *ParkingLot {
+	return newParkingLot(func() *amqp.Connection {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.conn
+	}, queue)
+}
+
+// ParkingLot 获取消费者连接上的停车场队列管理 每次操作使用当前连接上的临时通道 断线期间返回ErrDisconnected
+func (mq *ConsumerMQ) ParkingLot(queue string) *ParkingLot {
+	return newParkingLot(func() *amqp.Connection {
+		mq.mu.Lock()
+		defer mq.mu.Unlock()
+		return mq.conn
+	}, queue)
+}
+
+func newParkingLot(conn func() *amqp.Connection, queue string) *ParkingLot {
+	return &ParkingLot{
+		open: func() (parkingChannel, error) {
+			current := conn()
+			if current == nil || current.IsClosed() {
+				return nil, ErrDisconnected
+			}
+			channel, err := current.Channel()
+			if err != nil {
+				return nil, err
+			}
+			return channel, nil
+		},
+		originQueue: queue,
+		queue:       queue + PARKING_QUEUE_SUFFIX,
+	}
+}
+
+// with 在临时通道上执行fn 队列不存在等错误只会关闭该通道 不影响生产者和消费者的通道
+func (lot *ParkingLot) with(fn func(channel parkingChannel) error) error {
+	channel, err := lot.open()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	return fn(channel)
+}
+
+// Count 停车场队列中的消息数
+func (lot *ParkingLot) Count() (int, error) {
+	count := 0
+	err := lot.with(func(channel parkingChannel) error {
+		queue, err := channel.QueueInspect(lot.queue)
+		count = queue.Messages
+		return err
+	})
+	return count, err
+}
+
+/**
+ * Peek 查看停车场队列中的前n条消息 查看后消息放回队列
+ * @params n 最多查看的条数
+ * @return []DeadMessage err
+ */
+func (lot *ParkingLot) Peek(n int) ([]DeadMessage, error) {
+	if n <= 0 {
+		return nil, errors.New("n需大于0")
+	}
+	messages := []DeadMessage{}
+	err := lot.with(func(channel parkingChannel) error {
+		deliveries, err := lot.get(channel, n)
+		for _, msg := range deliveries {
+			messages = append(messages, lot.deadMessage(msg))
+		}
+		// 全部取出后再放回 避免放回的消息被再次取到
+		for _, msg := range deliveries {
+			msg.Nack(false, true)
+		}
+		return err
+	})
+	return messages, err
+}
+
+/**
+ * Replay 将停车场队列中的前n条消息重新发布到原队列 重置处理次数
+ * @params n 重放条数 小于等于0时重放当前全部消息
+ * @return 实际重放的条数 err
+ */
+func (lot *ParkingLot) Replay(n int) (int, error) {
+	replayed := 0
+	err := lot.with(func(channel parkingChannel) error {
+		if n <= 0 {
+			queue, err := channel.QueueInspect(lot.queue)
+			if err != nil {
+				return err
+			}
+			n = queue.Messages
+		}
+		for replayed < n {
+			msg, ok, err := channel.Get(lot.queue, false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			publishing := republishing(msg)
+			delete(publishing.Headers, HEADER_ATTEMPTS)
+			delete(publishing.Headers, HEADER_ORIGIN_QUEUE)
+			delete(publishing.Headers, HEADER_DEATH_REASON)
+			delete(publishing.Headers, "x-death")
+			err = channel.Publish("", lot.deadMessage(msg).OriginQueue, false, false, publishing)
+			if err != nil {
+				msg.Nack(false, true)
+				return err
+			}
+			err = msg.Ack(false)
+			if err != nil {
+				return err
+			}
+			replayed++
+		}
+		return nil
+	})
+	return replayed, err
+}
+
+// Purge 清空停车场队列 返回删除的消息数
+func (lot *ParkingLot) Purge() (int, error) {
+	count := 0
+	err := lot.with(func(channel parkingChannel) error {
+		var err error
+		count, err = channel.QueuePurge(lot.queue, false)
+		return err
+	})
+	return count, err
+}
+
+// get 不确认地取出前n条消息
+func (lot *ParkingLot) get(channel parkingChannel, n int) ([]amqp.Delivery, error) {
+	deliveries := make([]amqp.Delivery, 0, n)
+	for len(deliveries) < n {
+		msg, ok, err := channel.Get(lot.queue, false)
+		if err != nil {
+			return deliveries, err
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, msg)
+	}
+	return deliveries, nil
+}
+
+// deadMessage 解析死信的来源 兼容消费者主动转发和rabbitmq的x-death
+func (lot *ParkingLot) deadMessage(msg amqp.Delivery) DeadMessage {
+	message := DeadMessage{
+		Body:        msg.Body,
+		Headers:     msg.Headers,
+		OriginQueue: lot.originQueue,
+		Attempts:    Attempts(msg),
+		Timestamp:   msg.Timestamp,
+	}
+	if reason, ok := msg.Headers[HEADER_DEATH_REASON].(string); ok {
+		message.Reason = reason
+	}
+	if origin, ok := msg.Headers[HEADER_ORIGIN_QUEUE].(string); ok && origin != "" {
+		message.OriginQueue = origin
+		return message
+	}
+	// rabbitmq死信时在x-death中记录原队列和原因 第一条为最近一次
+	if deaths, ok := msg.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			if queue, ok := death["queue"].(string); ok && queue != "" {
+				message.OriginQueue = queue
+			}
+			if reason, ok := death["reason"].(string); ok && message.Reason == "" {
+				message.Reason = reason
+			}
+		}
+	}
+	return message
+}
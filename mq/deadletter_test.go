@@ -0,0 +1,171 @@
+package mq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestDeadMessage(t *testing.T) {
+	lot := newParkingLot(nil, "order")
+	message := lot.deadMessage(amqp.Delivery{Headers: amqp.Table{
+		HEADER_ORIGIN_QUEUE: "order.paid",
+		HEADER_DEATH_REASON: "rejected",
+		HEADER_ATTEMPTS:     int32(2),
+	}})
+	if message.OriginQueue != "order.paid" || message.Reason != "rejected" || message.Attempts != 2 {
+		t.Fatalf("消费者转发的死信解析错误: %+v", message)
+	}
+	message = lot.deadMessage(amqp.Delivery{Headers: amqp.Table{
+		"x-death": []interface{}{amqp.Table{"queue": "order.refund", "reason": "expired"}},
+	}})
+	if message.OriginQueue != "order.refund" || message.Reason != "expired" {
+		t.Fatalf("x-death解析错误: %+v", message)
+	}
+	message = lot.deadMessage(amqp.Delivery{})
+	if message.OriginQueue != "order" {
+		t.Fatalf("默认原队列错误: %s", message.OriginQueue)
+	}
+}
+
+// fakeParkingChannel 内存中的停车场队列 取出未确认的消息在Nack或通道关闭时放回
+type fakeParkingChannel struct {
+	messages   []amqp.Delivery
+	unacked    map[uint64]amqp.Delivery
+	tag        uint64
+	published  []amqp.Publishing
+	keys       []string
+	publishErr error
+	closed     bool
+}
+
+func (f *fakeParkingChannel) QueueInspect(name string) (amqp.Queue, error) {
+	return amqp.Queue{Name: name, Messages: len(f.messages)}, nil
+}
+
+func (f *fakeParkingChannel) QueuePurge(name string, noWait bool) (int, error) {
+	count := len(f.messages)
+	f.messages = nil
+	return count, nil
+}
+
+func (f *fakeParkingChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	if len(f.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	msg := f.messages[0]
+	f.messages = f.messages[1:]
+	f.tag++
+	msg.DeliveryTag, msg.Acknowledger = f.tag, f
+	f.unacked[f.tag] = msg
+	return msg, true, nil
+}
+
+func (f *fakeParkingChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if f.publishErr != nil {
+		return f.publishErr
+	}
+	f.published = append(f.published, msg)
+	f.keys = append(f.keys, key)
+	return nil
+}
+
+func (f *fakeParkingChannel) Close() error {
+	f.closed = true
+	for tag := range f.unacked {
+		f.Nack(tag, false, true)
+	}
+	return nil
+}
+
+func (f *fakeParkingChannel) Ack(tag uint64, multiple bool) error {
+	delete(f.unacked, tag)
+	return nil
+}
+
+func (f *fakeParkingChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	msg, ok := f.unacked[tag]
+	delete(f.unacked, tag)
+	if ok && requeue {
+		f.messages = append(f.messages, msg)
+	}
+	return nil
+}
+
+func (f *fakeParkingChannel) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+func newFakeParkingLot(messages ...amqp.Delivery) (*ParkingLot, *fakeParkingChannel) {
+	channel := &fakeParkingChannel{messages: messages, unacked: map[uint64]amqp.Delivery{}}
+	lot := newParkingLot(nil, "order")
+	lot.open = func() (parkingChannel, error) {
+		channel.closed = false
+		return channel, nil
+	}
+	return lot, channel
+}
+
+func TestParkingLotDisconnected(t *testing.T) {
+	lots := []*ParkingLot{(&ProducerMQ{}).ParkingLot("order"), (&ConsumerMQ{}).ParkingLot("order")}
+	for _, lot := range lots {
+		if _, err := lot.Count(); err != ErrDisconnected {
+			t.Fatalf("断线时Count应返回ErrDisconnected: %v", err)
+		}
+		if _, err := lot.Replay(0); err != ErrDisconnected {
+			t.Fatalf("断线时Replay应返回ErrDisconnected: %v", err)
+		}
+		if _, err := lot.Purge(); err != ErrDisconnected {
+			t.Fatalf("断线时Purge应返回ErrDisconnected: %v", err)
+		}
+	}
+}
+
+func TestParkingLotReplay(t *testing.T) {
+	lot, channel := newFakeParkingLot(
+		amqp.Delivery{Body: []byte("1"), Headers: amqp.Table{HEADER_ORIGIN_QUEUE: "order.paid", HEADER_DEATH_REASON: "rejected", HEADER_ATTEMPTS: int32(3), "trace": "t1"}},
+		amqp.Delivery{Body: []byte("2"), Headers: amqp.Table{"x-death": []interface{}{amqp.Table{"queue": "order.refund", "reason": "expired"}}}},
+		amqp.Delivery{Body: []byte("3")},
+	)
+	messages, err := lot.Peek(2)
+	if err != nil || len(messages) != 2 || messages[0].OriginQueue != "order.paid" || len(channel.messages) != 3 {
+		t.Fatalf("Peek后消息应放回队列: %+v %v", messages, err)
+	}
+	if count, err := lot.Count(); err != nil || count != 3 {
+		t.Fatalf("Count错误: %d %v", count, err)
+	}
+
+	// 重放时发布失败 消息放回停车场队列
+	channel.publishErr = errors.New("publish failed")
+	if replayed, err := lot.Replay(1); err == nil || replayed != 0 || len(channel.messages) != 3 || !channel.closed {
+		t.Fatalf("发布失败应放回消息并关闭临时通道: %d %v", replayed, err)
+	}
+
+	channel.publishErr = nil
+	replayed, err := lot.Replay(0)
+	if err != nil || replayed != 3 || len(channel.messages) != 0 || len(channel.unacked) != 0 {
+		t.Fatalf("重放全部消息失败: %d %v", replayed, err)
+	}
+	expects := map[string]string{"1": "order.paid", "2": "order.refund", "3": "order"}
+	for i, publishing := range channel.published {
+		if channel.keys[i] != expects[string(publishing.Body)] {
+			t.Errorf("消息%s重放到了%s", publishing.Body, channel.keys[i])
+		}
+		for _, header := range []string{HEADER_ATTEMPTS, HEADER_ORIGIN_QUEUE, HEADER_DEATH_REASON, "x-death"} {
+			if _, ok := publishing.Headers[header]; ok {
+				t.Errorf("消息%s未清理消息头%s", publishing.Body, header)
+			}
+		}
+	}
+	if channel.published[0].Headers["trace"] != "t1" {
+		t.Error("重放应保留业务消息头")
+	}
+}
+
+func TestParkingLotPurge(t *testing.T) {
+	lot, channel := newFakeParkingLot(amqp.Delivery{Body: []byte("1")}, amqp.Delivery{Body: []byte("2")})
+	count, err := lot.Purge()
+	if err != nil || count != 2 || len(channel.messages) != 0 || !channel.closed {
+		t.Fatalf("Purge错误: %d %v", count, err)
+	}
+}
@@ -0,0 +1,181 @@
+package mq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"strconv"
+	"time"
+)
+
+// DELAY_LEVELS 未开启插件时支持的延迟等级 每个等级一个等待队列 延迟时间向上取整到最近的等级
+var DELAY_LEVELS = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute, 5 * time.Minute,
+	6 * time.Minute, 7 * time.Minute, 8 * time.Minute, 9 * time.Minute, 10 * time.Minute,
+	20 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour,
+}
+
+var ErrDelayTooLong = errors.New("延迟时间超过最大延迟等级 请开启延迟插件")
+
+const (
+	DELAY_QUEUE_SUFFIX      = ".delay"            // 延迟等待队列后缀 按延迟等级的毫秒数分桶 如order.delay.5000
+	DELAY_QUEUE_IDLE        = 10 * time.Minute    // 等待队列中最后一条消息过期后保留的时间 之后由rabbitmq自动删除
+	DELAYED_EXCHANGE_SUFFIX = ".delayed"          // 延迟插件交换机后缀
+	DELAYED_EXCHANGE_TYPE   = "x-delayed-message" // rabbitmq_delayed_message_exchange插件的交换机类型
+	HEADER_DELAY            = "x-delay"           // 延迟插件使用的延迟毫秒数
+)
+
+/**
+ * UseDelayPlugin 使用rabbitmq_delayed_message_exchange插件实现延迟消息 需在首次延迟发布前调用
+ * 默认每个延迟等级使用一个设置了x-message-ttl的等待队列 同一队列中的消息过期时间相同 不会互相阻塞
+ * 延迟时间会向上取整到DELAY_LEVELS 需要精确延迟或超过最大等级时需开启插件
+ */
+func (p *ProducerMQ) UseDelayPlugin() {
+	p.mu.Lock()
+	p.delayPlugin = true
+	p.delayDeclare = 0
+	p.mu.Unlock()
+}
+
+/**
+ * PublishAfter 延迟推送消息 需先执行Declare
+ * 未开启插件时实际延迟为不小于delay的最近一个DELAY_LEVELS 超过最大等级时返回ErrDelayTooLong
+ * @params msg 消息内容
+ * @params delay 延迟时间 小于等于0时立即推送
+ */
+func (p *ProducerMQ) PublishAfter(msg string, delay time.Duration) error {
+	p.mu.Lock()
+	declared, queue := p.declare == 1, p.queue
+	p.mu.Unlock()
+	if !declared {
+		return errors.New("Declare未执行")
+	}
+	ms := int64(delay / time.Millisecond)
+	if ms <= 0 {
+		return p.Publish(msg)
+	}
+	exchange, key, err := p.declareDelay(ms)
+	if err == ErrDelayTooLong {
+		return err
+	}
+	if err != nil {
+		return errors.New("声明延迟队列失败:" + err.Error())
+	}
+	publishing := amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(msg),
+	}
+	if exchange != "" {
+		publishing.Headers = amqp.Table{HEADER_DELAY: ms}
+	}
+	// 等待队列没有消费者 消息过期后由死信转发到业务交换机
+	_, err = p.publish(exchange, key, publishing)
+	if err != nil {
+		return err
+	}
+	printLog(0, msg, queue)
+	return nil
+}
+
+/**
+ * PublishAt 在指定时间推送消息 需先执行Declare 未开启插件时推送时间按DELAY_LEVELS向后取整
+ * @params msg 消息内容
+ * @params at 推送时间 早于当前时间时立即推送
+ */
+func (p *ProducerMQ) PublishAt(msg string, at time.Time) error {
+	return p.PublishAfter(msg, at.Sub(time.Now()))
+}
+
+/**
+ * declareDelay 声明延迟插件交换机或对应延迟等级的等待队列
+ * 插件交换机断线重连后自动重新声明 等待队列在发布前按需声明 空闲DELAY_QUEUE_IDLE后自动删除
+ * @params ms 延迟毫秒数
+ * @return 发布使用的交换机和路由键 err
+ */
+func (p *ProducerMQ) declareDelay(ms int64) (string, string, error) {
+	p.mu.Lock()
+	declared, plugin, conn := p.delayDeclare == 1, p.delayPlugin, p.conn
+	queue, exchange, key := p.queue, p.exchange, p.key
+	p.mu.Unlock()
+	if plugin {
+		if declared {
+			return exchange + DELAYED_EXCHANGE_SUFFIX, key, nil
+		}
+		err := p.declareTopology("delayed:"+queue+":"+exchange+":"+key, func(channel *amqp.Channel) error {
+			err := channel.ExchangeDeclare(exchange+DELAYED_EXCHANGE_SUFFIX, DELAYED_EXCHANGE_TYPE, true, false, false, false, amqp.Table{
+				"x-delayed-type": "direct",
+			})
+			if err != nil {
+				return err
+			}
+			return channel.QueueBind(queue, key, exchange+DELAYED_EXCHANGE_SUFFIX, false, nil)
+		})
+		if err != nil {
+			return "", "", err
+		}
+		p.mu.Lock()
+		p.delayDeclare = 1
+		p.mu.Unlock()
+		return exchange + DELAYED_EXCHANGE_SUFFIX, key, nil
+	}
+	level, err := delayLevel(ms)
+	if err != nil {
+		return "", "", err
+	}
+	delayQueue := delayQueueName(queue, level)
+	// 重新声明会重置x-expires的计时 间隔不超过DELAY_QUEUE_IDLE的一半 保证队列在最后一条消息过期前不会被删除
+	p.mu.Lock()
+	lastDeclared, ok := p.delayQueues[delayQueue]
+	p.mu.Unlock()
+	if ok && time.Since(lastDeclared) < DELAY_QUEUE_IDLE/2 {
+		return "", delayQueue, nil
+	}
+	// 参数与已存在的队列不一致时rabbitmq会关闭声明所在的通道 使用临时通道 不影响发布
+	err = withChannel(conn, func(channel *amqp.Channel) error {
+		_, err := channel.QueueDeclare(delayQueue, true, false, false, false, delayQueueArgs(exchange, key, level))
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	p.markDelayQueue(delayQueue)
+	return "", delayQueue, nil
+}
+
+// markDelayQueue 记录等待队列的声明时间 超过DELAY_QUEUE_IDLE一半的记录下次发布前会重新声明 直接清理
+func (p *ProducerMQ) markDelayQueue(delayQueue string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.delayQueues == nil {
+		p.delayQueues = map[string]time.Time{}
+	}
+	for name, at := range p.delayQueues {
+		if time.Since(at) >= DELAY_QUEUE_IDLE/2 {
+			delete(p.delayQueues, name)
+		}
+	}
+	p.delayQueues[delayQueue] = time.Now()
+}
+
+// delayLevel 不小于ms的最近一个延迟等级的毫秒数
+func delayLevel(ms int64) (int64, error) {
+	for _, level := range DELAY_LEVELS {
+		if int64(level/time.Millisecond) >= ms {
+			return int64(level / time.Millisecond), nil
+		}
+	}
+	return 0, ErrDelayTooLong
+}
+
+// delayQueueName 延迟时间对应的等待队列名
+func delayQueueName(queue string, ms int64) string {
+	return queue + DELAY_QUEUE_SUFFIX + "." + strconv.FormatInt(ms, 10)
+}
+
+// delayQueueArgs 等待队列参数 消息统一在ms毫秒后过期并转发到业务交换机
+func delayQueueArgs(exchange, key string, ms int64) amqp.Table {
+	args := DeadLetterArgs(exchange, key)
+	args["x-message-ttl"] = ms
+	args["x-expires"] = ms + int64(DELAY_QUEUE_IDLE/time.Millisecond)
+	return args
+}
@@ -0,0 +1,131 @@
+package mq
+
+import (
+	"testing"
+	"time"
+)
+
+// newBufferedProducer 已执行Declare但处于断线状态的生产者 发布的消息进入缓存便于检查
+func newBufferedProducer() *ProducerMQ {
+	p := &ProducerMQ{declare: 1, queue: "order", exchange: "order.exchange", key: "paid"}
+	p.SetReconnect(ReconnectPolicy{Publish: PUBLISH_BUFFER})
+	return p
+}
+
+func TestDelayQueueArgs(t *testing.T) {
+	if name := delayQueueName("order", 5000); name != "order.delay.5000" {
+		t.Errorf("等待队列名错误: %s", name)
+	}
+	args := delayQueueArgs("order.exchange", "paid", 5000)
+	if args["x-message-ttl"] != int64(5000) || args["x-expires"] != int64(5000)+int64(DELAY_QUEUE_IDLE/time.Millisecond) {
+		t.Errorf("过期参数错误: %v", args)
+	}
+	if args["x-dead-letter-exchange"] != "order.exchange" || args["x-dead-letter-routing-key"] != "paid" {
+		t.Errorf("死信参数错误: %v", args)
+	}
+}
+
+func TestDelayLevel(t *testing.T) {
+	cases := []struct {
+		ms    int64
+		level int64
+		err   error
+	}{
+		{ms: 1, level: 1000},
+		{ms: 1000, level: 1000},
+		{ms: 1500, level: 5000},
+		{ms: 61000, level: 120000},
+		{ms: 7200000, level: 7200000},
+		{ms: 7200001, err: ErrDelayTooLong},
+	}
+	for _, c := range cases {
+		level, err := delayLevel(c.ms)
+		if level != c.level || err != c.err {
+			t.Errorf("%dms的延迟等级错误: %d %v", c.ms, level, err)
+		}
+	}
+}
+
+func TestDeclareDelay(t *testing.T) {
+	p := newBufferedProducer()
+	if _, _, err := p.declareDelay(5000); err != ErrDisconnected {
+		t.Fatalf("未声明的等待队列断线时应返回ErrDisconnected: %v", err)
+	}
+	// 最近声明过的等待队列不再重复声明
+	p.delayQueues = map[string]time.Time{"order.delay.5000": time.Now()}
+	exchange, key, err := p.declareDelay(5000)
+	if err != nil || exchange != "" || key != "order.delay.5000" {
+		t.Fatalf("应直接使用已声明的等待队列: %s %s %v", exchange, key, err)
+	}
+	// 不在等级上的延迟使用向上取整后的等待队列
+	p.delayQueues["order.delay.10000"] = time.Now()
+	if _, key, err = p.declareDelay(7000); err != nil || key != "order.delay.10000" {
+		t.Fatalf("延迟应向上取整到等级: %s %v", key, err)
+	}
+	if _, _, err = p.declareDelay(3 * 3600 * 1000); err != ErrDelayTooLong {
+		t.Fatalf("超过最大等级应返回ErrDelayTooLong: %v", err)
+	}
+	p.delayQueues["order.delay.5000"] = time.Now().Add(-DELAY_QUEUE_IDLE)
+	if _, _, err = p.declareDelay(5000); err != ErrDisconnected {
+		t.Fatalf("接近过期的等待队列需重新声明: %v", err)
+	}
+	p.markDelayQueue("order.delay.1000")
+	if _, ok := p.delayQueues["order.delay.5000"]; ok || len(p.delayQueues) != 2 {
+		t.Fatalf("应清理需要重新声明的记录: %v", p.delayQueues)
+	}
+
+	p.UseDelayPlugin()
+	if _, _, err = p.declareDelay(5000); err != ErrDisconnected {
+		t.Fatalf("插件交换机未声明时断线应返回ErrDisconnected: %v", err)
+	}
+	p.delayDeclare = 1
+	exchange, key, err = p.declareDelay(5000)
+	if err != nil || exchange != "order.exchange"+DELAYED_EXCHANGE_SUFFIX || key != "paid" {
+		t.Fatalf("插件模式应发布到延迟交换机: %s %s %v", exchange, key, err)
+	}
+}
+
+func TestPublishAfter(t *testing.T) {
+	p := &ProducerMQ{}
+	if err := p.PublishAfter("1", time.Second); err == nil {
+		t.Fatal("未Declare时应返回err")
+	}
+
+	p = newBufferedProducer()
+	p.delayQueues = map[string]time.Time{"order.delay.5000": time.Now(), "order.delay.1000": time.Now()}
+	if err := p.PublishAfter("1", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishAfter("2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 已过去的时间立即推送
+	if err := p.PublishAt("3", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishAt("4", time.Now().Add(3*time.Hour)); err != ErrDelayTooLong {
+		t.Fatalf("未开启插件时超过最大等级应返回ErrDelayTooLong: %v", err)
+	}
+	expects := []struct{ exchange, key string }{{"", "order.delay.5000"}, {"", "order.delay.1000"}, {"order.exchange", "paid"}}
+	if len(p.buffer) != len(expects) {
+		t.Fatalf("缓存消息数错误: %d", len(p.buffer))
+	}
+	for i, expect := range expects {
+		item := p.buffer[i]
+		if item.exchange != expect.exchange || item.key != expect.key || item.publishing.Expiration != "" {
+			t.Errorf("第%d条消息路由错误: %s %s %q", i+1, item.exchange, item.key, item.publishing.Expiration)
+		}
+	}
+
+	p = newBufferedProducer()
+	p.UseDelayPlugin()
+	p.delayDeclare = 1
+	if err := p.PublishAt("1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	item := p.buffer[0]
+	delay, _ := item.publishing.Headers[HEADER_DELAY].(int64)
+	if item.exchange != "order.exchange"+DELAYED_EXCHANGE_SUFFIX || delay <= 59000 || delay > 60000 {
+		t.Errorf("插件模式消息错误: %s %v", item.exchange, item.publishing.Headers)
+	}
+}
@@ -0,0 +1,293 @@
+package mq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 追踪字段的消息头 替代从JSON消息体中解析
+const (
+	HEADER_QID   = "x-qid"
+	HEADER_UID   = "x-uid"
+	HEADER_CSUID = "x-csuid"
+	HEADER_ROLE  = "x-role"
+	HEADER_PTID  = "x-ptid"
+	HEADER_ACC   = "x-acc"
+)
+
+// Trace 日志追踪字段
+type Trace struct {
+	Qid   string `json:"qid"`
+	Uid   string `json:"uid"`
+	Csuid string `json:"csuid"`
+	Role  string `json:"role"`
+	Ptid  string `json:"ptid"`
+	Acc   string `json:"acc"`
+}
+
+// Envelope 消息信封 元数据使用AMQP属性和消息头 消息体只包含编码后的Payload
+type Envelope struct {
+	Id          string    // 消息id 对应message-id
+	Type        string    // 消息类型 对应type 用于RegisterType注册的类型
+	Trace       Trace     // 追踪字段 对应x-qid等消息头
+	Timestamp   time.Time // 发布时间
+	ContentType string    // 编码类型
+	Payload     []byte    // 编码后的消息体
+}
+
+var (
+	typesMu sync.RWMutex
+	types   = map[string]reflect.Type{}
+)
+
+var envelopeType = reflect.TypeOf((*Envelope)(nil))
+
+/**
+ * NewEnvelope 编码payload并构造信封
+ * @params msgType 消息类型
+ * @params trace 追踪字段
+ * @params payload 消息内容
+ * @params codec 编码 为nil时使用JSONCodec
+ * @return Envelope err
+ */
+func NewEnvelope(msgType string, trace Trace, payload interface{}, codec Codec) (*Envelope, error) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	body, err := codec.Marshal(payload)
+	if err != nil {
+		return nil, errors.New("消息编码失败:" + err.Error())
+	}
+	return &Envelope{
+		Id:          newMessageId(),
+		Type:        msgType,
+		Trace:       trace,
+		Timestamp:   time.Now(),
+		ContentType: codec.ContentType(),
+		Payload:     body,
+	}, nil
+}
+
+// EnvelopeFromDelivery 从收到的消息中还原信封
+func EnvelopeFromDelivery(msg amqp.Delivery) *Envelope {
+	return &Envelope{
+		Id:          msg.MessageId,
+		Type:        msg.Type,
+		Trace:       traceFromHeaders(msg.Headers),
+		Timestamp:   msg.Timestamp,
+		ContentType: msg.ContentType,
+		Payload:     msg.Body,
+	}
+}
+
+// Decode 按ContentType解码消息体
+func (envelope *Envelope) Decode(v interface{}) error {
+	codec, err := CodecFor(envelope.ContentType)
+	if err != nil {
+		return err
+	}
+	err = codec.Unmarshal(envelope.Payload, v)
+	if err != nil {
+		return errors.New("消息解码失败:" + err.Error())
+	}
+	return nil
+}
+
+// publishing 转为AMQP消息
+func (envelope *Envelope) publishing() amqp.Publishing {
+	headers := amqp.Table{}
+	for name, value := range map[string]string{
+		HEADER_QID:   envelope.Trace.Qid,
+		HEADER_UID:   envelope.Trace.Uid,
+		HEADER_CSUID: envelope.Trace.Csuid,
+		HEADER_ROLE:  envelope.Trace.Role,
+		HEADER_PTID:  envelope.Trace.Ptid,
+		HEADER_ACC:   envelope.Trace.Acc,
+	} {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	return amqp.Publishing{
+		Headers:     headers,
+		ContentType: envelope.ContentType,
+		MessageId:   envelope.Id,
+		Timestamp:   envelope.Timestamp,
+		Type:        envelope.Type,
+		Body:        envelope.Payload,
+	}
+}
+
+// logBody 日志中的消息内容 非文本编码只记录长度
+func (envelope *Envelope) logBody() string {
+	switch envelope.ContentType {
+	case CONTENT_TYPE_JSON, CONTENT_TYPE_TEXT, "":
+		return string(envelope.Payload)
+	}
+	return fmt.Sprintf("[%s %d bytes]", envelope.ContentType, len(envelope.Payload))
+}
+
+/**
+ * PublishEnvelope 推送信封 需先执行Declare
+ * @params envelope
+ */
+func (p *ProducerMQ) PublishEnvelope(envelope *Envelope) error {
+	queue, exchange, key, err := p.target()
+	if err != nil {
+		return err
+	}
+	_, err = p.publish(exchange, key, envelope.publishing())
+	if err != nil {
+		return err
+	}
+	writeLog(0, envelope.logBody(), queue, envelope.Type, envelope.Trace, 0)
+	return nil
+}
+
+/**
+ * PublishJSON 以JSON编码推送消息 追踪字段放在消息头
+ * @params msgType 消息类型
+ * @params trace 追踪字段
+ * @params payload 消息内容
+ */
+func (p *ProducerMQ) PublishJSON(msgType string, trace Trace, payload interface{}) error {
+	return p.PublishWithCodec(msgType, trace, payload, JSONCodec)
+}
+
+/**
+ * PublishWithCodec 以指定编码推送消息
+ * @params msgType 消息类型
+ * @params trace 追踪字段
+ * @params payload 消息内容
+ * @params codec 编码 接收方需注册相同content-type的Codec
+ */
+func (p *ProducerMQ) PublishWithCodec(msgType string, trace Trace, payload interface{}, codec Codec) error {
+	envelope, err := NewEnvelope(msgType, trace, payload, codec)
+	if err != nil {
+		return err
+	}
+	return p.PublishEnvelope(envelope)
+}
+
+/**
+ * RegisterType 注册消息类型对应的结构 供payload为interface{}的TypedReceiver解码
+ * @params msgType 消息类型
+ * @params prototype 该类型的零值 如OrderPaid{} 或 &OrderPaid{}
+ */
+func RegisterType(msgType string, prototype interface{}) {
+	typesMu.Lock()
+	types[msgType] = reflect.TypeOf(prototype)
+	typesMu.Unlock()
+}
+
+/**
+ * RegisterTypedReceiver 注册按类型解码的接收者
+ * handler支持 func(T) int 和 func(*Envelope, T) int T为结构体或其指针
+ * T为interface{}时按消息type查找RegisterType注册的类型
+ * 解码失败的消息按RECEIVE_REJECT处理
+ * @params queueName 队列名
+ * @params logStat 0 记录日志 1 不记录日志
+ * @params handler 消息处理函数 返回RECEIVE_ACK等处理结果
+ * @params options 重试 死信和并发配置
+ * @return handler签名错误时返回err
+ */
+func (mq *ConsumerMQ) RegisterTypedReceiver(queueName string, logStat int, handler interface{}, options ReceiverOptions) error {
+	fn := reflect.ValueOf(handler)
+	fnType := fn.Type()
+	if fnType.Kind() != reflect.Func || fnType.NumOut() != 1 || fnType.Out(0).Kind() != reflect.Int ||
+		fnType.NumIn() < 1 || fnType.NumIn() > 2 || (fnType.NumIn() == 2 && fnType.In(0) != envelopeType) {
+		return errors.New("handler需为func(T) int或func(*Envelope, T) int")
+	}
+	payloadType := fnType.In(fnType.NumIn() - 1)
+	mq.RegisterReceiverWithOptions(queueName, logStat, nil, options)
+	mq.receivers[len(mq.receivers)-1].deliveryFunc = func(msg amqp.Delivery) int {
+		envelope := EnvelopeFromDelivery(msg)
+		payload, err := decodePayload(envelope, payloadType)
+		if err != nil {
+			log.Printf("队列 %s 消息 %s 解码失败: %s", queueName, envelope.Id, err.Error())
+			return RECEIVE_REJECT
+		}
+		args := []reflect.Value{payload}
+		if fnType.NumIn() == 2 {
+			args = []reflect.Value{reflect.ValueOf(envelope), payload}
+		}
+		return int(fn.Call(args)[0].Int())
+	}
+	return nil
+}
+
+// decodePayload 按参数类型解码 接口类型时使用注册的类型
+func decodePayload(envelope *Envelope, payloadType reflect.Type) (reflect.Value, error) {
+	target := payloadType
+	if payloadType.Kind() == reflect.Interface {
+		typesMu.RLock()
+		registered, ok := types[envelope.Type]
+		typesMu.RUnlock()
+		if !ok {
+			return reflect.Value{}, errors.New("未注册的消息类型:" + envelope.Type)
+		}
+		target = registered
+	}
+	var value reflect.Value
+	if target.Kind() == reflect.Ptr {
+		value = reflect.New(target.Elem())
+		err := envelope.Decode(value.Interface())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	} else {
+		pointer := reflect.New(target)
+		err := envelope.Decode(pointer.Interface())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value = pointer.Elem()
+	}
+	if !value.Type().AssignableTo(payloadType) {
+		return reflect.Value{}, errors.New("注册的类型与handler参数不匹配:" + envelope.Type)
+	}
+	return value, nil
+}
+
+// traceFromHeaders 从消息头读取追踪字段
+func traceFromHeaders(headers amqp.Table) Trace {
+	get := func(name string) string {
+		value, _ := headers[name].(string)
+		return value
+	}
+	return Trace{
+		Qid:   get(HEADER_QID),
+		Uid:   get(HEADER_UID),
+		Csuid: get(HEADER_CSUID),
+		Role:  get(HEADER_ROLE),
+		Ptid:  get(HEADER_PTID),
+		Acc:   get(HEADER_ACC),
+	}
+}
+
+// printDeliveryLog 打印消费日志 信封消息使用消息头中的追踪字段 其他消息从消息体解析
+func printDeliveryLog(msg amqp.Delivery, queue string) {
+	envelope := EnvelopeFromDelivery(msg)
+	if envelope.Type == "" && envelope.Trace == (Trace{}) {
+		printLog(1, string(msg.Body), queue)
+		return
+	}
+	writeLog(1, envelope.logBody(), queue, envelope.Type, envelope.Trace, 0)
+}
+
+// newMessageId 随机生成32位十六进制消息id
+func newMessageId() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,124 @@
+package mq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+type orderPaid struct {
+	OrderId string `json:"order_id"`
+	Fee     int    `json:"fee"`
+}
+
+// delivery 模拟rabbitmq投递信封
+func delivery(envelope *Envelope) amqp.Delivery {
+	publishing := envelope.publishing()
+	return amqp.Delivery{
+		Headers:     publishing.Headers,
+		ContentType: publishing.ContentType,
+		MessageId:   publishing.MessageId,
+		Timestamp:   publishing.Timestamp,
+		Type:        publishing.Type,
+		Body:        publishing.Body,
+	}
+}
+
+func TestTypedReceiver(t *testing.T) {
+	trace := Trace{Qid: "q1", Uid: "u1"}
+	envelope, err := NewEnvelope("order.paid", trace, orderPaid{OrderId: "T100", Fee: 990}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := delivery(envelope)
+	if msg.Headers[HEADER_QID] != "q1" || msg.ContentType != CONTENT_TYPE_JSON || len(msg.MessageId) != 32 {
+		t.Fatalf("信封属性错误: %+v", msg)
+	}
+
+	mq := &ConsumerMQ{}
+	var received *orderPaid
+	err = mq.RegisterTypedReceiver("order", 1, func(envelope *Envelope, order *orderPaid) int {
+		if envelope.Trace != trace {
+			t.Fatalf("追踪字段错误: %+v", envelope.Trace)
+		}
+		received = order
+		return RECEIVE_ACK
+	}, ReceiverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := receiveDelivery(mq.receivers[0], msg); code != RECEIVE_ACK || received == nil || received.Fee != 990 {
+		t.Fatalf("类型化接收失败: %d %+v", code, received)
+	}
+
+	// interface{}参数按注册的类型解码 未注册时拒绝
+	RegisterType("order.paid", orderPaid{})
+	var value interface{}
+	err = mq.RegisterTypedReceiver("order", 1, func(payload interface{}) int {
+		value = payload
+		return RECEIVE_ACK
+	}, ReceiverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := receiveDelivery(mq.receivers[1], msg); code != RECEIVE_ACK || value.(orderPaid).OrderId != "T100" {
+		t.Fatalf("注册类型解码失败: %d %+v", code, value)
+	}
+	msg.Type = "order.unknown"
+	if code := receiveDelivery(mq.receivers[1], msg); code != RECEIVE_REJECT {
+		t.Fatalf("未注册类型应拒绝: %d", code)
+	}
+
+	if mq.RegisterTypedReceiver("order", 1, func(order orderPaid) {}, ReceiverOptions{}) == nil {
+		t.Fatal("错误的handler签名应返回err")
+	}
+}
+
+func TestBytesCodec(t *testing.T) {
+	envelope, err := NewEnvelope("raw", Trace{}, []byte{0x08, 0x96, 0x01}, BytesCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	err = EnvelopeFromDelivery(delivery(envelope)).Decode(&body)
+	if err != nil || len(body) != 3 || body[1] != 0x96 {
+		t.Fatalf("字节解码错误: %v %v", body, err)
+	}
+}
+
+func TestPublishEnvelope(t *testing.T) {
+	envelope, err := NewEnvelope("order.paid", Trace{}, orderPaid{OrderId: "T100", Fee: 990}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (&ProducerMQ{}).PublishEnvelope(envelope); err == nil {
+		t.Fatal("未Declare时应返回err")
+	}
+	p := newBufferedProducer()
+	if err = p.PublishEnvelope(envelope); err != nil {
+		t.Fatalf("断线缓存失败: %v", err)
+	}
+	if len(p.buffer) != 1 || p.buffer[0].exchange != "order.exchange" || p.buffer[0].key != "paid" || p.buffer[0].publishing.Type != "order.paid" {
+		t.Fatalf("应使用Declare的交换机和路由键: %+v", p.buffer)
+	}
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	envelope, err := NewEnvelope("order.paid", Trace{Qid: "q1"}, orderPaid{OrderId: "T100", Fee: 990}, MsgpackCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := delivery(envelope)
+	if msg.ContentType != CONTENT_TYPE_MSGPACK {
+		t.Fatalf("content-type错误: %s", msg.ContentType)
+	}
+	// 未设置msgpack标签时使用json标签作为字段名
+	var fields map[string]interface{}
+	if err = MsgpackCodec.Unmarshal(msg.Body, &fields); err != nil || fields["order_id"] != "T100" {
+		t.Fatalf("msgpack字段名错误: %v %v", fields, err)
+	}
+	var paid orderPaid
+	err = EnvelopeFromDelivery(msg).Decode(&paid)
+	if err != nil || paid.OrderId != "T100" || paid.Fee != 990 {
+		t.Fatalf("msgpack解码错误: %+v %v", paid, err)
+	}
+}
@@ -15,9 +15,24 @@ import (
 // ReceiveMQ 用于管理和维护rabbitmq的对象
 type ConsumerMQ struct {
 	wg        sync.WaitGroup
-	channel   *amqp.Channel
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel            // 管理用通道 停车场队列等使用
+	channels  map[string]*amqp.Channel // 各接收者的消费通道 以消费者标签为key
 	receivers []Receiver
 
+	status     int             // 连接状态 STATUS_CONNECTING等
+	lastError  string          // 最近一次断线或重连失败的原因
+	reconnects int             // 累计重连成功次数
+	since      time.Time       // 进入当前状态的时间
+	policy     ReconnectPolicy // 断线重连配置 仅使用MinBackoff和MaxBackoff
+	stop       chan struct{}
+	stopOnce   sync.Once
+	started    bool          // Start是否已执行
+	exited     chan struct{} // Start返回时关闭
+	drain      time.Duration // Run收到取消后等待处理中消息的最长时间
+	topology   *Topology     // 每次连接后声明的拓扑
+
 	user   string
 	passwd string
 	host   string
@@ -26,109 +41,215 @@ type ConsumerMQ struct {
 }
 
 type ProducerMQ struct {
+	mu       sync.Mutex
+	conn     *amqp.Connection
 	channel  *amqp.Channel
 	declare  int // 0 未初始化 1 已初始化
 	queue    string
 	exchange string
 	key      string
+
+	delayPlugin  bool                 // 是否使用延迟插件
+	delayDeclare int                  // 0 延迟插件交换机未初始化 1 已初始化
+	delayQueues  map[string]time.Time // 已声明的延迟等待队列及最后一次声明的时间
+
+	confirms *confirmer // 发布确认模式 nil代表未开启
+
+	declareMu    sync.Mutex           // 串行执行声明和重连后的重新声明 声明期间不持有mu
+	connected    bool                 // 是否已连接 断线重连和补发缓存期间为false
+	closed       bool                 // 是否已主动关闭
+	stop         chan struct{}        // Close时关闭 中断重连的等待
+	policy       ReconnectPolicy      // 断线重连配置
+	declarations []declaration        // 重连后需重新执行的声明
+	buffer       []bufferedPublishing // 断线期间缓存的消息
+
+	user   string
+	passwd string
+	host   string
+	port   string
+	vhost  string
 }
 
 // Receiver 观察者模式需要的接口
 type Receiver struct {
-	logStat     int              //0 默认记录日志 1 不记录日志
-	queueName   string           // 获取接收者需要监听的队列
-	receiveFunc func([]byte) int // 处理收到的消息 返回0代表正常
+	logStat      int                     //0 默认记录日志 1 不记录日志
+	queueName    string                  // 获取接收者需要监听的队列
+	receiveFunc  func([]byte) int        // 处理收到的消息 返回RECEIVE_ACK等处理结果
+	deliveryFunc func(amqp.Delivery) int // 需要消息属性时使用 优先于receiveFunc
+	options      ReceiverOptions         // 重试和死信配置
+	legacy       bool                    // RegisterReceiver注册 非0返回值都按RECEIVE_RETRY处理
 }
 
-// New 建立RabbitMQ连接
-func newConn(user string, passwd string, host string, port string, vhost string) *amqp.Channel {
-	rabbitUrl := fmt.Sprintf("amqp://%s:%s@%s:%s/", user, passwd, host, port)
-	conn, err := amqp.DialConfig(rabbitUrl, amqp.Config{
-		Heartbeat: 10 * time.Second,
-		Locale:    "en_US",
-		Vhost:     vhost,
-	})
-	if err != nil {
-		panic(err)
+// New 创建一个新的操作RabbitMQ的对象 连接失败时不会panic 由Start按退避策略重连
+func NewConsumerMQ(user string, passwd string, host string, port string, vhost string) *ConsumerMQ {
+	mq := &ConsumerMQ{
+		user:     user,
+		passwd:   passwd,
+		host:     host,
+		port:     port,
+		vhost:    vhost,
+		stop:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		channels: map[string]*amqp.Channel{},
+		drain:    DEFAULT_DRAIN_TIMEOUT,
 	}
-	channel, err := conn.Channel()
+	mq.SetReconnect(ReconnectPolicy{})
+	mq.setStatus(STATUS_CONNECTING, "")
+	conn, channel, err := dial(user, passwd, host, port, vhost)
 	if err != nil {
-		panic(err)
-	}
-	return channel
-}
-
-// New 创建一个新的操作RabbitMQ的对象
-func NewConsumerMQ(user string, passwd string, host string, port string, vhost string) *ConsumerMQ {
-	channel := newConn(user, passwd, host, port, vhost)
-	return &ConsumerMQ{
-		channel: channel,
-		user:    user,
-		passwd:  passwd,
-		host:    host,
-		port:    port,
-		vhost:   vhost,
+		log.Printf("rabbitmq连接失败 启动后重连: %s", err.Error())
+		mq.setStatus(STATUS_CONNECTING, err.Error())
+		return mq
 	}
+	mq.conn, mq.channel = conn, channel
+	mq.setStatus(STATUS_CONNECTED, "")
+	return mq
 }
 
-// Start 启动Rabbitmq的客户端
+// Start 启动Rabbitmq的客户端 连接断开后按退避策略重连 Stop后返回
 func (mq *ConsumerMQ) Start() {
+	mq.mu.Lock()
+	if mq.started {
+		mq.mu.Unlock()
+		return
+	}
+	mq.started = true
+	mq.mu.Unlock()
+	defer close(mq.exited)
 	for {
-		mq.run()
-		// 一旦连接断开，那么需要隔一段时间去重连
-		time.Sleep(10 * time.Second)
+		conn, channel, ok := mq.connect()
+		if !ok {
+			return
+		}
+		mq.run(conn, channel)
+		if mq.stopped() {
+			return
+		}
 		log.Println("链接中断，正在发起重试")
-		mq.retry()
-		log.Println("中断重试连接成功")
+		mq.setStatus(STATUS_RECONNECTING, "")
+		// 接收者启动即退出时避免立即重连
+		mq.mu.Lock()
+		wait := backoff(mq.policy.MinBackoff)
+		mq.mu.Unlock()
+		select {
+		case <-mq.stop:
+			return
+		case <-time.After(wait):
+		}
 	}
 }
 
-// RegisterReceiver 注册一个用于接收指定队列指定路由的数据接收者
-func (mq *ConsumerMQ) RegisterReceiver(queueName string, logStat int, receiveFunc func([]byte) int) {
-	mq.receivers = append(mq.receivers, Receiver{
-		queueName:   queueName,
-		receiveFunc: receiveFunc,
-		logStat:     logStat,
+// Stop 立即停止消费并关闭连接 Start随后返回 正在处理的消息不再确认 由rabbitmq重新投递 需等待处理完成时使用Shutdown
+func (mq *ConsumerMQ) Stop() {
+	mq.stopOnce.Do(func() {
+		close(mq.stop)
 	})
+	mq.mu.Lock()
+	conn, channel := mq.conn, mq.channel
+	mq.conn, mq.channel = nil, nil
+	mq.mu.Unlock()
+	if channel != nil {
+		channel.Close()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	mq.setStatus(STATUS_STOPPED, "")
 }
 
-// run 开始获取连接并初始化相关操作
-func (mq *ConsumerMQ) run() {
+// RegisterReceiver 注册一个用于接收指定队列指定路由的数据接收者 返回非0时放回队尾重试 最多处理DEFAULT_MAX_ATTEMPTS次 超过后丢弃或转入队列配置的死信
+func (mq *ConsumerMQ) RegisterReceiver(queueName string, logStat int, receiveFunc func([]byte) int) {
+	mq.RegisterReceiverWithOptions(queueName, logStat, receiveFunc, ReceiverOptions{})
+	mq.receivers[len(mq.receivers)-1].legacy = true
+}
 
-	if mq.channel == nil {
-		log.Printf("rabbit刷新连接失败，将要重连: %s\n", mq.host)
-		return
+// run 启动所有接收者 连接或通道关闭后所有接收者退出时返回
+func (mq *ConsumerMQ) run(conn *amqp.Connection, channel *amqp.Channel) {
+	mq.setStatus(STATUS_CONNECTED, "")
+	closed := make(chan struct{})
+	go mq.supervise(conn, closed)
+	err := mq.applyTopology(conn)
+	if err != nil {
+		log.Printf("声明拓扑失败: %s", err.Error())
 	}
 
-	for _, receiver := range mq.receivers {
+	for i, receiver := range mq.receivers {
 		mq.wg.Add(1)
-		go mq.listen(receiver) // 每个接收者单独启动一个goroutine接收消息
+		go mq.listen(conn, consumerTag(i, receiver), receiver) // 每个接收者单独启动一个goroutine和通道接收消息
 	}
 
 	mq.wg.Wait()
+	close(closed)
 
-	log.Println("所有处理queue的任务都意外退出了")
-	// 理论上mq.run()在程序的执行过程中是不会结束的
-	// 一旦结束就说明所有的接收者都退出了，那么意味着程序与rabbitmq的连接断开
-	// 那么则需要重新连接，这里尝试销毁当前连接
-	mq.channel.Close()
+	// 所有接收者都退出说明与rabbitmq的连接断开 销毁当前连接等待重连
+	channel.Close()
+	conn.Close()
 }
 
-// retry 重连
-func (mq *ConsumerMQ) retry() {
-	mq.channel = newConn(mq.user, mq.passwd, mq.host, mq.port, mq.vhost)
+// connection 接收者订阅使用的连接 *amqp.Connection
+type connection interface {
+	Channel() (*amqp.Channel, error)
+	IsClosed() bool
 }
 
-// Listen 监听指定路由发来的消息
-func (mq *ConsumerMQ) listen(receiver Receiver) {
+// Listen 监听指定路由发来的消息 接收者的通道异常关闭时只重新订阅该接收者
+func (mq *ConsumerMQ) listen(conn connection, tag string, receiver Receiver) {
 	defer mq.wg.Done()
+	for {
+		err := mq.consume(conn, tag, receiver)
+		if conn.IsClosed() || mq.stopped() {
+			return
+		}
+		mq.mu.Lock()
+		wait := backoff(mq.policy.MinBackoff)
+		mq.mu.Unlock()
+		if err != nil {
+			log.Printf("队列 %s 消费失败 %s后重新订阅: %s", receiver.queueName, wait.String(), err.Error())
+		}
+		select {
+		case <-mq.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// consume 在独立的通道上订阅队列 按Concurrency启动多个worker处理消息 通道关闭或订阅取消后返回
+func (mq *ConsumerMQ) consume(conn connection, tag string, receiver Receiver) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	mq.mu.Lock()
+	mq.channels[tag] = channel
+	mq.mu.Unlock()
+	defer func() {
+		mq.mu.Lock()
+		delete(mq.channels, tag)
+		mq.mu.Unlock()
+	}()
+
 	// 这里获取每个接收者需要监听的队列和路由
 	queueName := receiver.queueName
-	// 获取消费通道 确保rabbitmq会一个一个发消息
-	mq.channel.Qos(1, 0, true)
-	msgs, err := mq.channel.Consume(
+	err = declareRetryQueue(channel, receiver)
+	if err != nil {
+		return errors.New("声明重试队列失败:" + err.Error())
+	}
+	if receiver.options.ParkingLot {
+		err = DeclareParkingLot(channel, queueName, receiver.options.DeadLetterExchange)
+		if err != nil {
+			return errors.New("声明停车场队列失败:" + err.Error())
+		}
+	}
+	// 限制未确认的消息数 避免消息堆积在单个消费者
+	err = channel.Qos(receiver.options.Prefetch, 0, false)
+	if err != nil {
+		return err
+	}
+	msgs, err := channel.Consume(
 		queueName, // queue
-		"",        // consumer
+		tag,       // consumer
 		false,     // auto-ack
 		false,     // exclusive
 		false,     // no-local
@@ -136,129 +257,179 @@ func (mq *ConsumerMQ) listen(receiver Receiver) {
 		nil,       // args
 	)
 	if nil != err {
-		log.Printf("获取队列 %s 的消费通道失败: %s", queueName, err.Error())
-		return
+		return errors.New("获取消费通道失败:" + err.Error())
 	}
 
-	// 使用callback消费数据
-	for msg := range msgs {
-		// 当接收者消息处理失败的时候，
-		// 比如网络问题导致的数据库连接失败，redis连接失败等等这种
-		// 通过重试可以成功的操作，那么这个时候是需要重试的
-		receiver.receiveFunc(msg.Body)
-		// 确认收到本条消息, multiple必须为false
-		msg.Ack(false)
-		if receiver.logStat == 0 {
-			printLog(1, string(msg.Body), receiver.queueName)
-		}
+	serve(channel, receiver, msgs)
+	return nil
+}
+
+// serve 启动Concurrency个worker共同消费同一个投递通道 投递通道关闭且所有worker处理完后返回
+func serve(channel publisher, receiver Receiver, msgs <-chan amqp.Delivery) {
+	queueName := receiver.queueName
+	var workers sync.WaitGroup
+	for i := 0; i < receiver.options.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			// 使用callback消费数据
+			for msg := range msgs {
+				// 当接收者消息处理失败的时候，
+				// 比如网络问题导致的数据库连接失败，redis连接失败等等这种
+				// 通过重试可以成功的操作，那么这个时候是需要重试的
+				// 确认或重试本条消息, multiple必须为false
+				err := handle(channel, receiver, msg)
+				if err != nil {
+					log.Printf("队列 %s 确认消息失败: %s", queueName, err.Error())
+				}
+				if receiver.logStat == 0 {
+					printDeliveryLog(msg, receiver.queueName)
+				}
+			}
+		}()
 	}
+	workers.Wait()
 }
 
 // NewProductMQ
 func NewProducerMQ(user string, passwd string, host string, port string, vhost string) *ProducerMQ {
-	channel := newConn(user, passwd, host, port, vhost)
-	return &ProducerMQ{
-		channel: channel,
+	p := &ProducerMQ{
+		user:   user,
+		passwd: passwd,
+		host:   host,
+		port:   port,
+		vhost:  vhost,
+	}
+	p.SetReconnect(ReconnectPolicy{})
+	conn, channel, err := dial(user, passwd, host, port, vhost)
+	if err != nil {
+		// 连接失败时不panic 按退避策略后台重连 期间的发布按ReconnectPolicy处理
+		log.Printf("rabbitmq连接失败 后台重连: %s", err.Error())
+		go p.reconnect()
+		return p
 	}
+	p.conn, p.channel, p.connected = conn, channel, true
+	go p.watch(conn, channel)
+	return p
 }
 
-// Declare 初始化队列 交换机 队列
+// Declare 初始化队列 交换机 队列 断线重连后自动重新声明 断线时返回ErrDisconnected 声明已记录 重连后自动执行 无需重试
 func (p *ProducerMQ) Declare(queue, exchange, key string) error {
+	return p.declareQueue(queue, exchange, key, nil)
+}
+
+// declareQueue 声明队列 交换机并绑定 args为队列参数
+func (p *ProducerMQ) declareQueue(queue, exchange, key string, args amqp.Table) error {
+	err := p.declareTopology("queue:"+queue+":"+exchange+":"+key, func(channel *amqp.Channel) error {
+		// 确认队列是否存在 不存在就declare
+		_, err := channel.QueueDeclare(queue, true, false, false, false, args)
+		if err != nil {
+			return err
+		}
+		// 确认exchange是否存在
+		err = channel.ExchangeDeclare(exchange, "direct", true, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		// 绑定exchange key和队列
+		return channel.QueueBind(queue, key, exchange, false, nil)
+	})
+	// 断线时声明已记录 重连后执行 队列信息同样记录 以便断线期间按ReconnectPolicy发布
+	if err != nil && err != ErrDisconnected {
+		return err
+	}
+	p.mu.Lock()
 	p.queue = queue
 	p.exchange = exchange
 	p.key = key
-	// 确认队列是否存在 不存在就declare
-	_, err := p.channel.QueueDeclare(queue, true, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-	// 确认exchange是否存在
-	err = p.channel.ExchangeDeclare(exchange, "direct", true, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-	// 绑定exchange key和队列
-	err = p.channel.QueueBind(queue, key, exchange, false, nil)
-	if err != nil {
-		return err
-	}
+	p.delayDeclare = 0
+	p.delayQueues = nil
 	p.declare = 1
-	return nil
+	p.mu.Unlock()
+	return err
 }
 
 // Publish 消息推送 推完消息后需业务端自己决定关闭的时机
 func (p *ProducerMQ) Publish(msg string) error {
-	if p.declare == 0 {
-		return errors.New("Declare未执行")
+	queue, exchange, key, err := p.target()
+	if err != nil {
+		return err
 	}
-	err := p.channel.Publish(p.exchange, p.key, false, false, amqp.Publishing{
+	_, err = p.publish(exchange, key, amqp.Publishing{
 		ContentType: "text/plain",
 		Body:        []byte(msg),
 	})
 	if err != nil {
 		return err
 	}
-	printLog(0, msg, p.queue)
+	printLog(0, msg, queue)
 	return nil
 }
 
-// Close 关闭连接
+// target 获取Declare声明的队列 交换机和路由键 Declare可能与发布并发执行 需在锁内读取
+func (p *ProducerMQ) target() (queue, exchange, key string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.declare == 0 {
+		return "", "", "", errors.New("Declare未执行")
+	}
+	return p.queue, p.exchange, p.key, nil
+}
+
+// Close 关闭连接 不再重连 断线缓存中未补发的消息丢弃
 func (p *ProducerMQ) Close() {
-	p.channel.Close()
+	p.mu.Lock()
+	if !p.closed {
+		close(p.stopped())
+	}
+	p.closed = true
+	p.connected = false
+	p.buffer = nil
+	conn, channel := p.conn, p.channel
+	p.mu.Unlock()
+	if channel != nil {
+		channel.Close()
+	}
+	if conn != nil {
+		conn.Close()
+	}
 }
 
-// printLog 打印日志
+// printLog 打印日志 从JSON消息体中解析追踪字段
 func printLog(mode int, msg string, queue string) {
-	var (
-		oriIp, destIp string
-		errorId                   int
-	)
+	var errorId int
 	msgMap := make(map[string]interface{})
 	err := json.Unmarshal([]byte(msg), &msgMap)
 	if err != nil {
 		errorId = -1
 	}
+	modeType, _ := msgMap["type"].(string)
+	trace := Trace{}
+	trace.Qid, _ = msgMap["qid"].(string)
+	trace.Csuid, _ = msgMap["csuid"].(string)
+	trace.Uid, _ = msgMap["uid"].(string)
+	trace.Role, _ = msgMap["role"].(string)
+	trace.Ptid, _ = msgMap["ptid"].(string)
+	trace.Acc, _ = msgMap["acc"].(string)
+	writeLog(mode, msg, queue, modeType, trace, errorId)
+}
+
+// writeLog 输出MJ_MD_MQ_LOG日志
+func writeLog(mode int, msg string, queue string, modeType string, trace Trace, errorId int) {
+	var oriIp, destIp string
 	if mode == 0 {
 		oriIp, _ = utils.GetLocalHostIp()
 	} else {
 		destIp, _ = utils.GetLocalHostIp()
 	}
-	qid, ok := msgMap["qid"].(string)
-	if !ok {
-		qid = ""
-	}
-	csuid, ok := msgMap["csuid"].(string)
-	if !ok {
-		csuid = ""
-	}
-	modeType, ok := msgMap["type"].(string)
-	if !ok {
-		modeType = ""
-	}
-	uid, ok := msgMap["uid"].(string)
-	if !ok {
-		uid = ""
-	}
-	role, ok := msgMap["role"].(string)
-	if !ok {
-		role = ""
-	}
-	ptid, ok := msgMap["ptid"].(string)
-	if !ok {
-		ptid = ""
-	}
-	acc, ok := msgMap["acc"].(string)
-	if !ok {
-		acc = ""
-	}
 	mqLog := map[string]interface{}{
-		"qid":      qid,
+		"qid":      trace.Qid,
 		"type":     modeType,
-		"role":     role,
-		"uid":      uid,
-		"csuid":    csuid,
-		"acc":      acc,
-		"ptid":     ptid,
+		"role":     trace.Role,
+		"uid":      trace.Uid,
+		"csuid":    trace.Csuid,
+		"acc":      trace.Acc,
+		"ptid":     trace.Ptid,
 		"queue":    queue,
 		"msg_mode": mode,
 		"t":        time.Now().Format("2006-01-02T15:04:05+08:00"),
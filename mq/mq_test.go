@@ -7,6 +7,7 @@ package mq
 import (
 	"log"
 	"testing"
+	"time"
 )
 
 func TestProducer(t *testing.T) {
@@ -26,12 +27,23 @@ func TestProducer(t *testing.T) {
 
 func TestConsumer(t *testing.T) {
 	mq := NewConsumerMQ("writer", "miaoji1109", "10.10.7.241", "5672", "dev")
+	if mq.Status() != STATUS_CONNECTED {
+		t.Skip("rabbitmq不可用")
+	}
 	//注册消费者1
 	mq.RegisterReceiver("publish_test", 0, Queue1)
 	//注册消费者2
 	mq.RegisterReceiver("publish_test", 0, Queue1)
 
-	mq.Start()
+	// Start连接失败时按退避一直重连 不会返回 消费一段时间后Stop退出
+	done := make(chan struct{})
+	go func() {
+		mq.Start()
+		close(done)
+	}()
+	time.Sleep(time.Second)
+	mq.Stop()
+	<-done
 }
 
 //OnReceiver 消费消息 返回0代表正常（方便以后扩展处理）
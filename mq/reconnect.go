@@ -0,0 +1,457 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	PUBLISH_FAIL   = 0 // 断线期间发布直接返回ErrDisconnected
+	PUBLISH_BUFFER = 1 // 断线期间发布先缓存 重连后按顺序补发 缓存满后返回ErrBufferFull
+)
+
+const (
+	STATUS_CONNECTING   = 0 // 首次连接中
+	STATUS_CONNECTED    = 1 // 已连接
+	STATUS_RECONNECTING = 2 // 断线重连中
+	STATUS_STOPPED      = 3 // 已停止
+)
+
+const (
+	DEFAULT_MIN_BACKOFF    = time.Second
+	DEFAULT_MAX_BACKOFF    = 30 * time.Second
+	DEFAULT_PUBLISH_BUFFER = 1000
+)
+
+var (
+	ErrDisconnected = errors.New("rabbitmq连接已断开")
+	ErrBufferFull   = errors.New("断线缓存已满")
+)
+
+// ReconnectPolicy 断线重连配置 零值代表使用默认值
+type ReconnectPolicy struct {
+	MinBackoff time.Duration // 首次重连间隔 之后每次翻倍 默认DEFAULT_MIN_BACKOFF
+	MaxBackoff time.Duration // 最大重连间隔 默认DEFAULT_MAX_BACKOFF
+	Publish    int           // 断线期间的发布策略 PUBLISH_FAIL PUBLISH_BUFFER
+	BufferSize int           // PUBLISH_BUFFER时最多缓存的消息数 默认DEFAULT_PUBLISH_BUFFER
+}
+
+// Health 消费者连接状态
+type Health struct {
+	Status     int       // STATUS_CONNECTING STATUS_CONNECTED STATUS_RECONNECTING STATUS_STOPPED
+	Connected  bool      // 是否已连接
+	Reconnects int       // 累计重连成功次数
+	LastError  string    // 最近一次断线或重连失败的原因
+	Since      time.Time // 进入当前状态的时间
+}
+
+// declaration 已执行的声明 重连后按顺序重新执行
+type declaration struct {
+	name  string
+	apply func(*amqp.Channel) error
+}
+
+// bufferedPublishing 断线期间缓存的消息
+type bufferedPublishing struct {
+	exchange     string
+	key          string
+	publishing   amqp.Publishing
+	confirmation *Confirmation
+}
+
+// dial 建立RabbitMQ连接和通道
+func dial(user string, passwd string, host string, port string, vhost string) (*amqp.Connection, *amqp.Channel, error) {
+	rabbitUrl := fmt.Sprintf("amqp://%s:%s@%s:%s/", user, passwd, host, port)
+	conn, err := amqp.DialConfig(rabbitUrl, amqp.Config{
+		Heartbeat: 10 * time.Second,
+		Locale:    "en_US",
+		Vhost:     vhost,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, channel, nil
+}
+
+// withChannel 在连接上打开临时通道执行fn 执行后关闭 fn出错导致通道被rabbitmq关闭时不影响其他通道
+func withChannel(conn *amqp.Connection, fn func(*amqp.Channel) error) error {
+	if conn == nil || conn.IsClosed() {
+		return ErrDisconnected
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	return fn(channel)
+}
+
+// normalize 填充重连配置的默认值
+func (policy ReconnectPolicy) normalize() ReconnectPolicy {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		policy.MaxBackoff = DEFAULT_MAX_BACKOFF
+		if policy.MaxBackoff < policy.MinBackoff {
+			policy.MaxBackoff = policy.MinBackoff
+		}
+	}
+	if policy.BufferSize <= 0 {
+		policy.BufferSize = DEFAULT_PUBLISH_BUFFER
+	}
+	return policy
+}
+
+// backoff 加入随机抖动的等待时间 取[backoff/2, backoff) 避免大量客户端同时重连
+func backoff(current time.Duration) time.Duration {
+	half := current / 2
+	if half <= 0 {
+		return current
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// nextBackoff 下一次的退避时间 翻倍且不超过max
+func nextBackoff(current, max time.Duration) time.Duration {
+	current *= 2
+	if current > max {
+		return max
+	}
+	return current
+}
+
+// SetReconnect 设置断线重连和断线期间的发布策略
+func (p *ProducerMQ) SetReconnect(policy ReconnectPolicy) {
+	p.mu.Lock()
+	p.policy = policy.normalize()
+	p.mu.Unlock()
+}
+
+// Connected 当前是否已连接
+func (p *ProducerMQ) Connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+/**
+ * declareTopology 执行声明并记录 同名声明只保留最后一次
+ * 声明期间只持有declareMu 不阻塞发布 重连时restore同样持有declareMu 保证记录的声明不会遗漏
+ * 断线时声明仍会记录 由重连后的restore执行 并返回ErrDisconnected 调用方无需重试
+ */
+func (p *ProducerMQ) declareTopology(name string, apply func(*amqp.Channel) error) error {
+	p.declareMu.Lock()
+	defer p.declareMu.Unlock()
+	p.mu.Lock()
+	channel := p.channel
+	p.mu.Unlock()
+	// restore后到补发完成前connected仍为false 但通道已可用 以通道是否关闭为准
+	err := ErrDisconnected
+	if channel != nil {
+		err = apply(channel)
+		if err == amqp.ErrClosed {
+			err = ErrDisconnected
+		}
+	}
+	if err != nil && err != ErrDisconnected {
+		return err
+	}
+	p.record(name, apply)
+	return err
+}
+
+// record 记录声明 需持有declareMu
+func (p *ProducerMQ) record(name string, apply func(*amqp.Channel) error) {
+	for i, item := range p.declarations {
+		if item.name == name {
+			p.declarations[i].apply = apply
+			return
+		}
+	}
+	p.declarations = append(p.declarations, declaration{name: name, apply: apply})
+}
+
+// watch 监听连接和通道关闭 非主动关闭时重连
+func (p *ProducerMQ) watch(conn *amqp.Connection, channel *amqp.Channel) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+	}
+	p.mu.Lock()
+	if p.closed || p.channel != channel {
+		p.mu.Unlock()
+		return
+	}
+	p.connected = false
+	p.mu.Unlock()
+	if reason != nil {
+		log.Printf("生产者连接断开: %s", reason.Error())
+	}
+	// 仅通道关闭时同样重建连接 保证状态一致
+	conn.Close()
+	p.reconnect()
+}
+
+// reconnect 按指数退避重连 成功后重新声明并补发缓存的消息
+func (p *ProducerMQ) reconnect() {
+	p.mu.Lock()
+	delay, stop := p.policy.MinBackoff, p.stopped()
+	p.mu.Unlock()
+	for {
+		p.mu.Lock()
+		closed, policy := p.closed, p.policy
+		p.mu.Unlock()
+		if closed {
+			return
+		}
+		conn, channel, err := dial(p.user, p.passwd, p.host, p.port, p.vhost)
+		if err == nil {
+			err = p.restore(conn, channel)
+			if err == nil {
+				log.Printf("生产者重连成功")
+				go p.watch(conn, channel)
+				p.flush(channel)
+				return
+			}
+			conn.Close()
+		}
+		wait := backoff(delay)
+		log.Printf("生产者重连失败 %s后重试: %s", wait.String(), err.Error())
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		delay = nextBackoff(delay, policy.MaxBackoff)
+	}
+}
+
+// stopped Close时关闭 需持有p.mu
+func (p *ProducerMQ) stopped() chan struct{} {
+	if p.stop == nil {
+		p.stop = make(chan struct{})
+	}
+	return p.stop
+}
+
+// restore 在新通道上重新执行声明和确认模式 不持有p.mu 连接状态在flush补发完缓存后才切换为已连接
+func (p *ProducerMQ) restore(conn *amqp.Connection, channel *amqp.Channel) error {
+	p.declareMu.Lock()
+	defer p.declareMu.Unlock()
+	for _, item := range p.declarations {
+		err := item.apply(channel)
+		if err != nil {
+			return errors.New("重新声明" + item.name + "失败:" + err.Error())
+		}
+	}
+	p.mu.Lock()
+	confirm := p.confirms != nil
+	p.mu.Unlock()
+	var c *confirmer
+	if confirm {
+		var err error
+		c, err = newConfirmer(channel)
+		if err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrDisconnected
+	}
+	p.conn = conn
+	p.channel = channel
+	p.confirms = c
+	return nil
+}
+
+// buffered 断线期间按策略缓存消息 需持有p.mu
+func (p *ProducerMQ) buffered(item bufferedPublishing) (*Confirmation, error) {
+	if p.closed || p.policy.Publish != PUBLISH_BUFFER {
+		return nil, ErrDisconnected
+	}
+	if len(p.buffer) >= p.policy.BufferSize {
+		return nil, ErrBufferFull
+	}
+	if item.confirmation == nil && p.confirms != nil {
+		item.confirmation = &Confirmation{done: make(chan struct{})}
+	}
+	p.buffer = append(p.buffer, item)
+	return item.confirmation, nil
+}
+
+/**
+ * flush 在新通道上按顺序补发断线期间缓存的消息 确认结果转交给原Confirmation
+ * 补发期间仍处于断线状态 新发布的消息继续进入缓存排在后面 缓存清空后才切换为已连接 保证消息顺序
+ * @params channel restore成功的通道
+ */
+func (p *ProducerMQ) flush(channel publisher) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		items, c := p.buffer, p.confirms
+		p.buffer = nil
+		if len(items) == 0 {
+			p.connected = true
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		for i, item := range items {
+			confirmation, err := c.publish(channel, item.exchange, item.key, item.publishing)
+			if err == amqp.ErrClosed {
+				// 补发时再次断线 未发出的消息连同原Confirmation放回缓存头部 由下一次重连补发
+				p.mu.Lock()
+				p.buffer = append(items[i:len(items):len(items)], p.buffer...)
+				p.mu.Unlock()
+				return
+			}
+			handOff(item, confirmation, err)
+		}
+	}
+}
+
+// handOff 将补发的确认结果转交给缓存时返回的Confirmation
+func handOff(item bufferedPublishing, confirmation *Confirmation, err error) {
+	if item.confirmation == nil {
+		if err != nil {
+			log.Printf("补发缓存消息失败: %s", err.Error())
+		}
+		return
+	}
+	if err != nil {
+		item.confirmation.resolve(err)
+		return
+	}
+	if confirmation == nil {
+		// 缓存时开启了确认模式 补发前确认模式未恢复 无法获取确认结果
+		item.confirmation.resolve(nil)
+		return
+	}
+	go func(origin, current *Confirmation) {
+		<-current.done
+		origin.Tag = current.Tag
+		origin.Return = current.Return
+		origin.resolve(current.err)
+	}(item.confirmation, confirmation)
+}
+
+// SetReconnect 设置消费者断线重连的退避时间
+func (mq *ConsumerMQ) SetReconnect(policy ReconnectPolicy) {
+	mq.mu.Lock()
+	mq.policy = policy.normalize()
+	mq.mu.Unlock()
+}
+
+// Status 当前连接状态 STATUS_CONNECTING等
+func (mq *ConsumerMQ) Status() int {
+	return mq.Health().Status
+}
+
+// Health 当前连接状态详情 可用于健康检查
+func (mq *ConsumerMQ) Health() Health {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	return Health{
+		Status:     mq.status,
+		Connected:  mq.status == STATUS_CONNECTED,
+		Reconnects: mq.reconnects,
+		LastError:  mq.lastError,
+		Since:      mq.since,
+	}
+}
+
+// setStatus 切换连接状态 已停止后不再切换
+func (mq *ConsumerMQ) setStatus(status int, lastError string) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	if mq.status == STATUS_STOPPED {
+		return
+	}
+	if lastError != "" {
+		mq.lastError = lastError
+	}
+	if mq.status != status || mq.since.IsZero() {
+		mq.status = status
+		mq.since = time.Now()
+	}
+}
+
+func (mq *ConsumerMQ) stopped() bool {
+	select {
+	case <-mq.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// connect 获取可用连接 断开时按指数退避加抖动重连 Stop后返回false
+func (mq *ConsumerMQ) connect() (*amqp.Connection, *amqp.Channel, bool) {
+	mq.mu.Lock()
+	conn, channel, delay, maxDelay := mq.conn, mq.channel, mq.policy.MinBackoff, mq.policy.MaxBackoff
+	mq.mu.Unlock()
+	if mq.stopped() {
+		return nil, nil, false
+	}
+	if conn != nil && !conn.IsClosed() {
+		return conn, channel, true
+	}
+	for {
+		conn, channel, err := dial(mq.user, mq.passwd, mq.host, mq.port, mq.vhost)
+		if err == nil {
+			mq.mu.Lock()
+			if mq.stopped() {
+				mq.mu.Unlock()
+				conn.Close()
+				return nil, nil, false
+			}
+			mq.conn, mq.channel = conn, channel
+			if mq.status == STATUS_RECONNECTING {
+				mq.reconnects++
+			}
+			mq.mu.Unlock()
+			log.Println("中断重试连接成功")
+			return conn, channel, true
+		}
+		mq.setStatus(mq.Status(), err.Error())
+		wait := backoff(delay)
+		log.Printf("rabbit连接失败 %s后重连 %s: %s", wait.String(), mq.host, err.Error())
+		select {
+		case <-mq.stop:
+			return nil, nil, false
+		case <-time.After(wait):
+		}
+		delay = nextBackoff(delay, maxDelay)
+	}
+}
+
+// supervise 记录连接关闭的原因 连接关闭后各接收者的通道随之关闭并退出
+func (mq *ConsumerMQ) supervise(conn *amqp.Connection, done <-chan struct{}) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	select {
+	case reason := <-connClosed:
+		if reason != nil {
+			log.Printf("rabbit连接断开 %s: %s", mq.host, reason.Error())
+			mq.setStatus(STATUS_RECONNECTING, reason.Error())
+		}
+	case <-done:
+	}
+}
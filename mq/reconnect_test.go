@@ -0,0 +1,163 @@
+package mq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestPublishWhileDisconnected(t *testing.T) {
+	p := &ProducerMQ{}
+	p.SetReconnect(ReconnectPolicy{})
+	_, err := p.publish("order", "paid", amqp.Publishing{Body: []byte("1")})
+	if err != ErrDisconnected {
+		t.Fatalf("PUBLISH_FAIL应返回ErrDisconnected: %v", err)
+	}
+
+	p.SetReconnect(ReconnectPolicy{Publish: PUBLISH_BUFFER, BufferSize: 1})
+	confirmation, err := p.publish("order", "paid", amqp.Publishing{Body: []byte("1")})
+	if err != nil || confirmation != nil {
+		t.Fatalf("未开启确认模式时缓存应返回nil: %v %v", confirmation, err)
+	}
+	_, err = p.publish("order", "paid", amqp.Publishing{Body: []byte("2")})
+	if err != ErrBufferFull {
+		t.Fatalf("缓存满后应返回ErrBufferFull: %v", err)
+	}
+
+	p.buffer = nil
+	p.confirms = &confirmer{pending: map[uint64]*Confirmation{}}
+	confirmation, err = p.publish("order", "paid", amqp.Publishing{Body: []byte("3")})
+	if err != nil || confirmation == nil || len(p.buffer) != 1 || p.buffer[0].confirmation != confirmation {
+		t.Fatalf("确认模式下缓存应返回Confirmation: %v %v", confirmation, err)
+	}
+}
+
+// fakeChannel 记录发布顺序 closeAfter次发布后返回amqp.ErrClosed
+type fakeChannel struct {
+	bodies     []string
+	closeAfter int
+}
+
+func (f *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if f.closeAfter >= 0 && len(f.bodies) >= f.closeAfter {
+		return amqp.ErrClosed
+	}
+	f.bodies = append(f.bodies, string(msg.Body))
+	return nil
+}
+
+func TestRestoreAndFlush(t *testing.T) {
+	p := &ProducerMQ{}
+	p.SetReconnect(ReconnectPolicy{Publish: PUBLISH_BUFFER})
+	var replayed []string
+	for _, name := range []string{"queue:order", "bind:order"} {
+		name := name
+		p.declarations = append(p.declarations, declaration{name: name, apply: func(*amqp.Channel) error {
+			replayed = append(replayed, name)
+			return nil
+		}})
+	}
+	if err := p.restore(nil, nil); err != nil {
+		t.Fatalf("restore失败: %v", err)
+	}
+	if len(replayed) != 2 || replayed[0] != "queue:order" || replayed[1] != "bind:order" {
+		t.Fatalf("应按顺序重新声明: %v", replayed)
+	}
+	if p.Connected() {
+		t.Fatal("补发缓存前不应切换为已连接")
+	}
+
+	// 缓存时开启确认模式 restore后使用新的confirmer
+	p.confirms = &confirmer{pending: map[uint64]*Confirmation{}}
+	var origins []*Confirmation
+	for _, body := range []string{"1", "2", "3"} {
+		confirmation, err := p.publish("order", "paid", amqp.Publishing{Body: []byte(body)})
+		if err != nil {
+			t.Fatalf("缓存失败: %v", err)
+		}
+		origins = append(origins, confirmation)
+	}
+
+	// 补发第二条时再次断线 未发出的消息放回缓存头部
+	channel := &fakeChannel{closeAfter: 1}
+	p.flush(channel)
+	if p.Connected() || len(p.buffer) != 2 || string(p.buffer[0].publishing.Body) != "2" || p.buffer[0].confirmation != origins[1] {
+		t.Fatalf("再次断线后剩余消息应按顺序留在缓存: %v", p.buffer)
+	}
+	_, err := p.publish("order", "paid", amqp.Publishing{Body: []byte("4")})
+	if err != nil {
+		t.Fatalf("缓存失败: %v", err)
+	}
+
+	channel.closeAfter = -1
+	p.flush(channel)
+	if !p.Connected() || len(p.buffer) != 0 {
+		t.Fatal("缓存清空后应切换为已连接")
+	}
+	if len(channel.bodies) != 4 || channel.bodies[0] != "1" || channel.bodies[1] != "2" || channel.bodies[2] != "3" || channel.bodies[3] != "4" {
+		t.Fatalf("补发顺序错误: %v", channel.bodies)
+	}
+
+	// 新通道的确认结果转交给缓存时返回的Confirmation
+	for tag := uint64(1); tag <= 3; tag++ {
+		p.confirms.confirm(amqp.Confirmation{DeliveryTag: tag, Ack: true})
+	}
+	for i, origin := range origins {
+		if err := origin.Wait(time.Second); err != nil {
+			t.Fatalf("第%d条消息确认失败: %v", i+1, err)
+		}
+		if origin.Tag != uint64(i+1) {
+			t.Errorf("第%d条消息的投递序号错误: %d", i+1, origin.Tag)
+		}
+	}
+}
+
+func TestDeclareWhileDisconnected(t *testing.T) {
+	p := &ProducerMQ{}
+	p.SetReconnect(ReconnectPolicy{Publish: PUBLISH_BUFFER})
+	if err := p.Declare("order", "order.exchange", "paid"); err != ErrDisconnected {
+		t.Fatalf("断线时Declare应返回ErrDisconnected: %v", err)
+	}
+	queue, exchange, key, err := p.target()
+	if err != nil || queue != "order" || exchange != "order.exchange" || key != "paid" {
+		t.Fatalf("断线时应记录Declare的队列: %s %s %s %v", queue, exchange, key, err)
+	}
+	err = p.ApplyTopology(&Topology{
+		Exchanges: []ExchangeOptions{{Name: "event"}},
+		Queues:    []QueueOptions{{Name: "order.event"}},
+		Bindings:  []Binding{{Queue: "order.event", Exchange: "event"}},
+	})
+	if err != ErrDisconnected {
+		t.Fatalf("断线时ApplyTopology应返回ErrDisconnected: %v", err)
+	}
+	// 重连后restore按顺序执行全部记录的声明
+	expects := []string{"queue:order:order.exchange:paid", "exchange:event", "queue:order.event", "bind:order.event:event:"}
+	if len(p.declarations) != len(expects) {
+		t.Fatalf("记录的声明数错误: %d", len(p.declarations))
+	}
+	for i, expect := range expects {
+		if p.declarations[i].name != expect {
+			t.Errorf("第%d个声明错误: %s", i+1, p.declarations[i].name)
+		}
+	}
+	if err = p.Publish("1"); err != nil || len(p.buffer) != 1 {
+		t.Fatalf("断线期间Declare后的发布应进入缓存: %v", err)
+	}
+}
+
+func TestReconnectClose(t *testing.T) {
+	p := &ProducerMQ{host: "127.0.0.1", port: "1"}
+	p.SetReconnect(ReconnectPolicy{MinBackoff: time.Minute})
+	done := make(chan struct{})
+	go func() {
+		p.reconnect()
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close后重连未停止等待")
+	}
+}
@@ -0,0 +1,192 @@
+package mq
+
+import (
+	"github.com/streadway/amqp"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 处理结果 RECEIVE_REQUEUE和RECEIVE_REJECT只对RegisterReceiverWithOptions等新接口注册的接收者生效
+// RegisterReceiver注册的旧接收者返回的任意非0值(包括-2 -3)都按RECEIVE_RETRY计次重试
+const (
+	RECEIVE_ACK     = 0  // 处理成功 确认消息
+	RECEIVE_RETRY   = 1  // 重试 计入处理次数 超过MaxAttempts后转入死信
+	RECEIVE_REQUEUE = -2 // 立即放回原队列 由rabbitmq重新投递 不计重试次数 只用于确定可以很快恢复的场景
+	RECEIVE_REJECT  = -3 // 不再重试 直接转入死信 未配置死信时丢弃
+)
+
+const (
+	HEADER_ATTEMPTS      = "x-attempts"     // 已处理次数
+	HEADER_ORIGIN_QUEUE  = "x-origin-queue" // 消息原始队列 死信重放时使用
+	HEADER_DEATH_REASON  = "x-death-reason" // 转入死信的原因
+	RETRY_QUEUE_SUFFIX   = ".retry"         // 延迟重试队列后缀
+	DEFAULT_MAX_ATTEMPTS = 3
+)
+
+// ReceiverOptions 接收者配置 零值代表使用默认值
+type ReceiverOptions struct {
+	MaxAttempts          int           // 最多处理次数 包含第一次 默认DEFAULT_MAX_ATTEMPTS
+	RetryDelay           time.Duration // 重试间隔 0代表立即放回队尾
+	DeadLetterExchange   string        // 死信交换机 为空时通过Nack交给队列的x-dead-letter-exchange
+	DeadLetterRoutingKey string        // 死信路由键 为空时使用原队列名
+	ParkingLot           bool          // 是否声明停车场队列 DeadLetterExchange为空时使用DEFAULT_DEAD_LETTER_EXCHANGE
+	Concurrency          int           // 并发处理的worker数 默认1
+	Prefetch             int           // 预取的未确认消息数 不小于Concurrency 默认与Concurrency相同
+}
+
+/**
+ * RegisterReceiverWithOptions 注册接收者并指定重试和死信配置
+ * receiveFunc返回RECEIVE_ACK RECEIVE_REQUEUE RECEIVE_RETRY RECEIVE_REJECT 其他非0值按RECEIVE_RETRY处理
+ * @params queueName 队列名
+ * @params logStat 0 记录日志 1 不记录日志
+ * @params receiveFunc 消息处理函数
+ * @params options 重试 死信和并发配置
+ */
+func (mq *ConsumerMQ) RegisterReceiverWithOptions(queueName string, logStat int, receiveFunc func([]byte) int, options ReceiverOptions) {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DEFAULT_MAX_ATTEMPTS
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	if options.Prefetch < options.Concurrency {
+		options.Prefetch = options.Concurrency
+	}
+	if options.ParkingLot && options.DeadLetterExchange == "" {
+		options.DeadLetterExchange = DEFAULT_DEAD_LETTER_EXCHANGE
+	}
+	mq.receivers = append(mq.receivers, Receiver{
+		queueName:   queueName,
+		receiveFunc: receiveFunc,
+		logStat:     logStat,
+		options:     options,
+	})
+}
+
+// declareRetryQueue 声明延迟重试队列 消息过期后通过默认交换机回到原队列
+func declareRetryQueue(channel *amqp.Channel, receiver Receiver) error {
+	if receiver.options.RetryDelay <= 0 {
+		return nil
+	}
+	_, err := channel.QueueDeclare(receiver.queueName+RETRY_QUEUE_SUFFIX, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": receiver.queueName,
+	})
+	return err
+}
+
+// receive 调用处理函数 panic时按RECEIVE_RETRY处理
+func receive(receiver Receiver, body []byte) (code int) {
+	return receiveDelivery(receiver, amqp.Delivery{Body: body})
+}
+
+// receiveDelivery 同receive 设置了deliveryFunc时传入完整消息
+func receiveDelivery(receiver Receiver, msg amqp.Delivery) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("队列 %s 处理消息panic: %v", receiver.queueName, r)
+			code = RECEIVE_RETRY
+		}
+	}()
+	if receiver.deliveryFunc != nil {
+		return receiver.deliveryFunc(msg)
+	}
+	return receiver.receiveFunc(msg.Body)
+}
+
+// handle 根据处理结果确认 重新入队 延迟重试或转入死信
+func handle(channel publisher, receiver Receiver, msg amqp.Delivery) error {
+	code := receiveDelivery(receiver, msg)
+	if receiver.legacy && code != RECEIVE_ACK {
+		code = RECEIVE_RETRY
+	}
+	switch code {
+	case RECEIVE_ACK:
+		return msg.Ack(false)
+	case RECEIVE_REQUEUE:
+		return msg.Nack(false, true)
+	case RECEIVE_REJECT:
+		return deadLetter(channel, receiver, msg, "rejected")
+	}
+	attempts := Attempts(msg) + 1
+	if attempts >= receiver.options.MaxAttempts {
+		return deadLetter(channel, receiver, msg, "max attempts exceeded")
+	}
+	publishing := republishing(msg)
+	publishing.Headers[HEADER_ATTEMPTS] = int32(attempts)
+	routingKey := receiver.queueName
+	if receiver.options.RetryDelay > 0 {
+		routingKey = receiver.queueName + RETRY_QUEUE_SUFFIX
+		publishing.Expiration = strconv.FormatInt(int64(receiver.options.RetryDelay/time.Millisecond), 10)
+	}
+	// 先发布重试消息再确认原消息 发布失败时放回原队列
+	err := channel.Publish("", routingKey, false, false, publishing)
+	if err != nil {
+		log.Printf("队列 %s 发布重试消息失败: %s", receiver.queueName, err.Error())
+		return msg.Nack(false, true)
+	}
+	return msg.Ack(false)
+}
+
+// deadLetter 转入死信 配置了DeadLetterExchange时主动发布 否则Nack交给队列的死信配置
+func deadLetter(channel publisher, receiver Receiver, msg amqp.Delivery, reason string) error {
+	if receiver.options.DeadLetterExchange == "" {
+		return msg.Nack(false, false)
+	}
+	publishing := republishing(msg)
+	publishing.Headers[HEADER_ORIGIN_QUEUE] = receiver.queueName
+	publishing.Headers[HEADER_DEATH_REASON] = reason
+	routingKey := receiver.options.DeadLetterRoutingKey
+	if routingKey == "" {
+		routingKey = receiver.queueName
+	}
+	err := channel.Publish(receiver.options.DeadLetterExchange, routingKey, false, false, publishing)
+	if err != nil {
+		log.Printf("队列 %s 发布死信失败: %s", receiver.queueName, err.Error())
+		return msg.Nack(false, true)
+	}
+	return msg.Ack(false)
+}
+
+// Attempts 获取消息已处理的次数 首次投递为0
+func Attempts(msg amqp.Delivery) int {
+	switch value := msg.Headers[HEADER_ATTEMPTS].(type) {
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case int:
+		return value
+	case int16:
+		return int(value)
+	case int8:
+		return int(value)
+	case uint8:
+		return int(value)
+	}
+	return 0
+}
+
+// republishing 复制原消息的属性和内容用于重新发布
+func republishing(msg amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
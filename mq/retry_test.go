@@ -0,0 +1,99 @@
+package mq
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+// fakeAcknowledger 记录消息的确认结果
+type fakeAcknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.acked = true
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	f.nacked = true
+	f.requeue = requeue
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+func TestHandle(t *testing.T) {
+	cases := []struct {
+		code    int
+		headers amqp.Table
+		acked   bool
+		nacked  bool
+		requeue bool
+	}{
+		{code: RECEIVE_ACK, acked: true},
+		{code: RECEIVE_REQUEUE, nacked: true, requeue: true},
+		{code: RECEIVE_REJECT, nacked: true},
+		// 已处理2次 第3次仍失败时转入死信
+		{code: RECEIVE_RETRY, headers: amqp.Table{HEADER_ATTEMPTS: int32(2)}, nacked: true},
+	}
+	for _, c := range cases {
+		code := c.code
+		receiver := Receiver{queueName: "test", options: ReceiverOptions{MaxAttempts: 3}, receiveFunc: func([]byte) int {
+			return code
+		}}
+		ack := &fakeAcknowledger{}
+		err := handle(nil, receiver, amqp.Delivery{Acknowledger: ack, Headers: c.headers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ack.acked != c.acked || ack.nacked != c.nacked || ack.requeue != c.requeue {
+			t.Errorf("code %d: got %+v", c.code, ack)
+		}
+	}
+}
+
+func TestReceivePanic(t *testing.T) {
+	receiver := Receiver{queueName: "test", receiveFunc: func([]byte) int {
+		panic("db down")
+	}}
+	if code := receive(receiver, nil); code != RECEIVE_RETRY {
+		t.Errorf("panic时应返回RECEIVE_RETRY, got %d", code)
+	}
+}
+
+func TestLegacyReceiverRetry(t *testing.T) {
+	// 旧的接收者返回任意非0值代表处理失败 需要计次重试 不能无限放回队列或直接丢弃
+	for _, code := range []int{1, -1, RECEIVE_REQUEUE, RECEIVE_REJECT, 2} {
+		code := code
+		mq := &ConsumerMQ{}
+		mq.RegisterReceiver("test", 1, func([]byte) int {
+			return code
+		})
+		receiver := mq.receivers[0]
+
+		// 未超过次数时重新发布计次 不直接转入死信
+		ack := &fakeAcknowledger{}
+		channel := &fakeChannel{closeAfter: -1}
+		err := handle(channel, receiver, amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{HEADER_ATTEMPTS: int32(1)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ack.acked || len(channel.bodies) != 1 {
+			t.Errorf("返回%d时应计次重试: %+v", code, ack)
+		}
+
+		ack = &fakeAcknowledger{}
+		err = handle(nil, receiver, amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{HEADER_ATTEMPTS: int32(2)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ack.nacked || ack.requeue {
+			t.Errorf("返回%d超过最大次数后应转入死信: %+v", code, ack)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"time"
+)
+
+// DEFAULT_DRAIN_TIMEOUT 小于kubernetes默认30秒的终止宽限期
+const DEFAULT_DRAIN_TIMEOUT = 25 * time.Second
+
+// SetDrainTimeout 设置Run收到取消后等待处理中消息的最长时间
+func (mq *ConsumerMQ) SetDrainTimeout(timeout time.Duration) {
+	mq.mu.Lock()
+	mq.drain = timeout
+	mq.mu.Unlock()
+}
+
+/**
+ * Run 启动消费 ctx取消后优雅停止 返回前连接已关闭
+ * 取消时先停止接收新消息 再等待处理中的消息确认 最长等待SetDrainTimeout设置的时间
+ * @params ctx 通常为收到SIGTERM时取消的ctx
+ * @return 等待超时时返回context.DeadlineExceeded
+ */
+func (mq *ConsumerMQ) Run(ctx context.Context) error {
+	go mq.Start()
+	select {
+	case <-mq.exited:
+		return nil
+	case <-ctx.Done():
+	}
+	mq.mu.Lock()
+	drain := mq.drain
+	mq.mu.Unlock()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	return mq.Shutdown(shutdownCtx)
+}
+
+/**
+ * Shutdown 优雅停止消费
+ * 通过basic.cancel停止投递 已预取未处理的消息在连接关闭后由rabbitmq重新投递
+ * 等待处理中的消息按处理结果确认后关闭连接 ctx结束时强制关闭 未确认的消息由rabbitmq重新投递
+ * @params ctx 等待的最长时间
+ * @return ctx结束时返回ctx.Err()
+ */
+func (mq *ConsumerMQ) Shutdown(ctx context.Context) error {
+	mq.stopOnce.Do(func() {
+		close(mq.stop)
+	})
+	mq.mu.Lock()
+	started := mq.started
+	channels := make(map[string]*amqp.Channel, len(mq.channels))
+	for tag, channel := range mq.channels {
+		channels[tag] = channel
+	}
+	mq.mu.Unlock()
+	if !started {
+		mq.Stop()
+		return nil
+	}
+	for tag, channel := range channels {
+		err := channel.Cancel(tag, false)
+		if err != nil {
+			log.Printf("取消消费者 %s 失败: %s", tag, err.Error())
+		}
+	}
+	// 所有接收者处理完当前消息后退出 Start关闭连接后返回
+	select {
+	case <-mq.exited:
+		mq.Stop()
+		return nil
+	case <-ctx.Done():
+		log.Printf("等待处理中的消息超时 强制关闭连接: %s", ctx.Err().Error())
+		mq.Stop()
+		return ctx.Err()
+	}
+}
+
+// consumerTag 接收者的消费者标签 同一队列可注册多个接收者
+func consumerTag(i int, receiver Receiver) string {
+	return fmt.Sprintf("%s.%d", receiver.queueName, i)
+}
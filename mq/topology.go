@@ -0,0 +1,202 @@
+package mq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	EXCHANGE_DIRECT  = "direct"  // 按路由键完全匹配
+	EXCHANGE_TOPIC   = "topic"   // 按路由键通配符匹配 *匹配一个单词 #匹配零个或多个单词
+	EXCHANGE_FANOUT  = "fanout"  // 广播到所有绑定的队列 忽略路由键
+	EXCHANGE_HEADERS = "headers" // 按消息头匹配 绑定参数x-match为all或any
+)
+
+// ExchangeOptions 交换机声明参数 默认持久化
+type ExchangeOptions struct {
+	Name       string     `json:"name" mapstructure:"name"`
+	Kind       string     `json:"kind" mapstructure:"kind"`               // EXCHANGE_DIRECT等 默认EXCHANGE_DIRECT
+	Transient  bool       `json:"transient" mapstructure:"transient"`     // 非持久化 rabbitmq重启后丢失
+	AutoDelete bool       `json:"auto_delete" mapstructure:"auto_delete"` // 所有绑定解除后自动删除
+	Internal   bool       `json:"internal" mapstructure:"internal"`       // 只能由其他交换机转发 不能直接发布
+	Args       amqp.Table `json:"args" mapstructure:"args"`
+}
+
+// QueueOptions 队列声明参数 默认持久化 零值的参数不设置
+type QueueOptions struct {
+	Name                 string        `json:"name" mapstructure:"name"`
+	Transient            bool          `json:"transient" mapstructure:"transient"`               // 非持久化 rabbitmq重启后丢失
+	Exclusive            bool          `json:"exclusive" mapstructure:"exclusive"`               // 仅当前连接可用 连接关闭后删除
+	AutoDelete           bool          `json:"auto_delete" mapstructure:"auto_delete"`           // 最后一个消费者取消后删除
+	TTL                  time.Duration `json:"ttl" mapstructure:"ttl"`                           // 消息过期时间 x-message-ttl
+	MaxLength            int           `json:"max_length" mapstructure:"max_length"`             // 最大消息数 x-max-length
+	MaxLengthBytes       int           `json:"max_length_bytes" mapstructure:"max_length_bytes"` // 最大消息总字节数 x-max-length-bytes
+	Overflow             string        `json:"overflow" mapstructure:"overflow"`                 // 超出长度时的处理 drop-head reject-publish
+	Quorum               bool          `json:"quorum" mapstructure:"quorum"`                     // 仲裁队列 x-queue-type=quorum 必须持久化且非独占
+	Lazy                 bool          `json:"lazy" mapstructure:"lazy"`                         // 惰性队列 消息尽量存盘 x-queue-mode=lazy
+	DeadLetterExchange   string        `json:"dead_letter_exchange" mapstructure:"dead_letter_exchange"`
+	DeadLetterRoutingKey string        `json:"dead_letter_routing_key" mapstructure:"dead_letter_routing_key"`
+	Args                 amqp.Table    `json:"args" mapstructure:"args"` // 其他参数 与上面的字段冲突时以上面的字段为准
+}
+
+// Binding 队列与交换机的绑定 headers交换机通过Args指定x-match和匹配的消息头
+type Binding struct {
+	Queue    string     `json:"queue" mapstructure:"queue"`
+	Exchange string     `json:"exchange" mapstructure:"exchange"`
+	Key      string     `json:"key" mapstructure:"key"`
+	Args     amqp.Table `json:"args" mapstructure:"args"`
+}
+
+// Arguments 构造QueueDeclare的args
+func (options QueueOptions) Arguments() amqp.Table {
+	args := amqp.Table{}
+	for key, value := range options.Args {
+		args[key] = value
+	}
+	if options.TTL > 0 {
+		args["x-message-ttl"] = int64(options.TTL / time.Millisecond)
+	}
+	if options.MaxLength > 0 {
+		args["x-max-length"] = int64(options.MaxLength)
+	}
+	if options.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = int64(options.MaxLengthBytes)
+	}
+	if options.Overflow != "" {
+		args["x-overflow"] = options.Overflow
+	}
+	if options.Quorum {
+		args["x-queue-type"] = "quorum"
+	}
+	if options.Lazy {
+		args["x-queue-mode"] = "lazy"
+	}
+	if options.DeadLetterExchange != "" {
+		for key, value := range DeadLetterArgs(options.DeadLetterExchange, options.DeadLetterRoutingKey) {
+			args[key] = value
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// validate 检查参数组合
+func (options QueueOptions) validate() error {
+	if options.Name == "" {
+		return errors.New("队列名不能为空")
+	}
+	if options.Quorum && (options.Transient || options.Exclusive || options.AutoDelete) {
+		return errors.New("仲裁队列必须持久化且不能独占或自动删除:" + options.Name)
+	}
+	return nil
+}
+
+func (options ExchangeOptions) kind() string {
+	if options.Kind == "" {
+		return EXCHANGE_DIRECT
+	}
+	return options.Kind
+}
+
+// declareExchange 在通道上声明交换机
+func declareExchange(channel *amqp.Channel, options ExchangeOptions) error {
+	return channel.ExchangeDeclare(options.Name, options.kind(), !options.Transient, options.AutoDelete, options.Internal, false, options.Args)
+}
+
+// declareQueue 在通道上声明队列
+func declareQueue(channel *amqp.Channel, options QueueOptions) error {
+	_, err := channel.QueueDeclare(options.Name, !options.Transient, options.AutoDelete, options.Exclusive, false, options.Arguments())
+	return err
+}
+
+// bind 在通道上绑定队列
+func bind(channel *amqp.Channel, binding Binding) error {
+	return channel.QueueBind(binding.Queue, binding.Key, binding.Exchange, false, binding.Args)
+}
+
+/**
+ * DeclareExchange 声明交换机 断线重连后自动重新声明
+ * @params options 交换机参数
+ */
+func (p *ProducerMQ) DeclareExchange(options ExchangeOptions) error {
+	if options.Name == "" {
+		return errors.New("交换机名不能为空")
+	}
+	return p.declareTopology("exchange:"+options.Name, func(channel *amqp.Channel) error {
+		return declareExchange(channel, options)
+	})
+}
+
+/**
+ * DeclareQueue 声明队列 断线重连后自动重新声明
+ * 已存在的队列参数不同时声明失败
+ * @params options 队列参数
+ */
+func (p *ProducerMQ) DeclareQueue(options QueueOptions) error {
+	err := options.validate()
+	if err != nil {
+		return err
+	}
+	return p.declareTopology("queue:"+options.Name, func(channel *amqp.Channel) error {
+		return declareQueue(channel, options)
+	})
+}
+
+/**
+ * Bind 绑定队列和交换机 同一队列可多次绑定不同的交换机和路由键
+ * @params bindings 绑定关系
+ */
+func (p *ProducerMQ) Bind(bindings ...Binding) error {
+	var disconnected error
+	for _, binding := range bindings {
+		binding := binding
+		err := p.declareTopology("bind:"+binding.Queue+":"+binding.Exchange+":"+binding.Key, func(channel *amqp.Channel) error {
+			return bind(channel, binding)
+		})
+		if err == ErrDisconnected {
+			// 断线时继续记录其余绑定 重连后一起执行
+			disconnected = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return disconnected
+}
+
+/**
+ * PublishTo 推送消息到指定交换机和路由键 不依赖Declare
+ * @params exchange 交换机 为空时使用默认交换机 路由键即队列名
+ * @params key 路由键 fanout交换机忽略
+ * @params headers 消息头 headers交换机据此路由 可为nil
+ * @params msg 消息内容
+ */
+func (p *ProducerMQ) PublishTo(exchange, key string, headers amqp.Table, msg string) error {
+	_, err := p.publish(exchange, key, amqp.Publishing{
+		Headers:     headers,
+		ContentType: "text/plain",
+		Body:        []byte(msg),
+	})
+	if err != nil {
+		return err
+	}
+	printLog(0, msg, exchange+":"+key)
+	return nil
+}
+
+/**
+ * PublishWithKey 使用Declare的交换机和指定路由键推送消息 用于topic交换机
+ * @params key 路由键
+ * @params msg 消息内容
+ */
+func (p *ProducerMQ) PublishWithKey(key, msg string) error {
+	_, exchange, _, err := p.target()
+	if err != nil {
+		return err
+	}
+	return p.PublishTo(exchange, key, nil, msg)
+}
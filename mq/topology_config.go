@@ -0,0 +1,375 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// Topology 声明式的交换机 队列和绑定 启动时幂等地声明
+type Topology struct {
+	Exchanges []ExchangeOptions `json:"exchanges" mapstructure:"exchanges"`
+	Queues    []QueueOptions    `json:"queues" mapstructure:"queues"`
+	Bindings  []Binding         `json:"bindings" mapstructure:"bindings"`
+}
+
+// Mismatch Verify发现的与rabbitmq现状不一致的声明
+type Mismatch struct {
+	Kind   string // exchange queue
+	Name   string
+	Reason string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s %s: %s", m.Kind, m.Name, m.Reason)
+}
+
+/**
+ * LoadTopology 从yaml或json文件加载拓扑 按扩展名识别格式
+ * 时长字段支持"30s" "2h"等写法 args中的参数名保留大小写
+ * @params file 配置文件路径
+ * @return Topology err
+ */
+func LoadTopology(file string) (*Topology, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, errors.New("读取拓扑配置失败:" + err.Error())
+	}
+	return TopologyFromViper(v, "")
+}
+
+/**
+ * TopologyFromViper 从已加载的viper配置中读取拓扑 用于与服务配置放在同一文件
+ * viper会将嵌套配置项名转为小写 仅因列表中的项不做转换才保留了args的大小写 配置来自yaml或json文件时args从文件中重新读取 不依赖该行为(如headers交换机匹配的消息头)
+ * @params v viper实例 为nil时使用全局viper
+ * @params key 拓扑所在的配置项 为空时读取整个配置
+ * @return Topology err
+ */
+func TopologyFromViper(v *viper.Viper, key string) (*Topology, error) {
+	if v == nil {
+		v = viper.GetViper()
+	}
+	topology := new(Topology)
+	var err error
+	if key == "" {
+		err = v.Unmarshal(topology)
+	} else {
+		err = v.UnmarshalKey(key, topology)
+	}
+	if err != nil {
+		return nil, errors.New("解析拓扑配置失败:" + err.Error())
+	}
+	topology.normalize()
+	if file := v.ConfigFileUsed(); file != "" {
+		err = topology.readArgs(file, key)
+		if err != nil {
+			return nil, errors.New("解析拓扑参数失败:" + err.Error())
+		}
+	}
+	return topology, topology.validate()
+}
+
+// readArgs 直接解析配置文件中的args 替换viper转为小写的参数名 非yaml json文件不做处理
+func (t *Topology) readArgs(file, key string) error {
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &raw)
+		if err != nil {
+			return err
+		}
+	case ".yaml", ".yml":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		err = yaml.Unmarshal(data, &raw)
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	node, _ := normalizeValue(raw).(amqp.Table)
+	if key != "" {
+		for _, part := range strings.Split(key, ".") {
+			node, _ = lookup(node, part).(amqp.Table)
+		}
+	}
+	for i, args := range rawArgs(lookup(node, "exchanges")) {
+		if i < len(t.Exchanges) && args != nil {
+			t.Exchanges[i].Args = args
+		}
+	}
+	for i, args := range rawArgs(lookup(node, "queues")) {
+		if i < len(t.Queues) && args != nil {
+			t.Queues[i].Args = args
+		}
+	}
+	for i, args := range rawArgs(lookup(node, "bindings")) {
+		if i < len(t.Bindings) && args != nil {
+			t.Bindings[i].Args = args
+		}
+	}
+	return nil
+}
+
+// lookup 与viper一致 配置项名不区分大小写
+func lookup(table amqp.Table, name string) interface{} {
+	for key, value := range table {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// rawArgs 列表中每一项的args 没有args的项为nil
+func rawArgs(list interface{}) []amqp.Table {
+	items, _ := list.([]interface{})
+	result := make([]amqp.Table, len(items))
+	for i, item := range items {
+		table, _ := item.(amqp.Table)
+		result[i], _ = lookup(table, "args").(amqp.Table)
+	}
+	return result
+}
+
+// validate 检查名称和参数组合
+func (t *Topology) validate() error {
+	for _, exchange := range t.Exchanges {
+		if exchange.Name == "" {
+			return errors.New("交换机名不能为空")
+		}
+	}
+	for _, queue := range t.Queues {
+		err := queue.validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, binding := range t.Bindings {
+		if binding.Queue == "" {
+			return errors.New("绑定的队列名不能为空")
+		}
+	}
+	return nil
+}
+
+// normalize json中的整数会解析为float64 转为int64以满足rabbitmq对参数类型的要求
+func (t *Topology) normalize() {
+	for i := range t.Exchanges {
+		t.Exchanges[i].Args = normalizeTable(t.Exchanges[i].Args)
+	}
+	for i := range t.Queues {
+		t.Queues[i].Args = normalizeTable(t.Queues[i].Args)
+	}
+	for i := range t.Bindings {
+		t.Bindings[i].Args = normalizeTable(t.Bindings[i].Args)
+	}
+}
+
+func normalizeTable(table amqp.Table) amqp.Table {
+	for key, value := range table {
+		table[key] = normalizeValue(value)
+	}
+	return table
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			return int64(v)
+		}
+	case map[string]interface{}:
+		return normalizeTable(amqp.Table(v))
+	case map[interface{}]interface{}:
+		table := amqp.Table{}
+		for key, item := range v {
+			table[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return table
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeValue(item)
+		}
+	}
+	return value
+}
+
+/**
+ * Apply 按交换机 队列 绑定的顺序声明 已存在且参数相同的不做改动
+ * @params channel 声明失败时rabbitmq会关闭该通道
+ */
+func (t *Topology) Apply(channel *amqp.Channel) error {
+	for _, exchange := range t.Exchanges {
+		err := declareExchange(channel, exchange)
+		if err != nil {
+			return errors.New("声明交换机" + exchange.Name + "失败:" + err.Error())
+		}
+	}
+	for _, queue := range t.Queues {
+		err := declareQueue(channel, queue)
+		if err != nil {
+			return errors.New("声明队列" + queue.Name + "失败:" + err.Error())
+		}
+	}
+	for _, binding := range t.Bindings {
+		err := bind(channel, binding)
+		if err != nil {
+			return errors.New("绑定队列" + binding.Queue + "失败:" + err.Error())
+		}
+	}
+	return nil
+}
+
+/**
+ * Verify 对比拓扑与rabbitmq的现状 不创建缺失的交换机和队列
+ * 先被动声明判断是否存在 存在时按期望参数声明 参数不一致时rabbitmq返回inequivalent arg
+ * AMQP无法查询绑定关系 绑定不在检查范围内
+ * @params conn 每项检查使用独立的通道
+ * @return 不一致的项 err 连接异常时返回
+ */
+func (t *Topology) Verify(conn *amqp.Connection) ([]Mismatch, error) {
+	mismatches := []Mismatch{}
+	for _, exchange := range t.Exchanges {
+		exchange := exchange
+		reason, err := verify(conn, func(channel *amqp.Channel) error {
+			return channel.ExchangeDeclarePassive(exchange.Name, exchange.kind(), !exchange.Transient, exchange.AutoDelete, exchange.Internal, false, nil)
+		}, func(channel *amqp.Channel) error {
+			return declareExchange(channel, exchange)
+		})
+		if err != nil {
+			return mismatches, err
+		}
+		if reason != "" {
+			mismatches = append(mismatches, Mismatch{Kind: "exchange", Name: exchange.Name, Reason: reason})
+		}
+	}
+	for _, queue := range t.Queues {
+		queue := queue
+		reason, err := verify(conn, func(channel *amqp.Channel) error {
+			_, err := channel.QueueDeclarePassive(queue.Name, !queue.Transient, queue.AutoDelete, queue.Exclusive, false, nil)
+			return err
+		}, func(channel *amqp.Channel) error {
+			return declareQueue(channel, queue)
+		})
+		if err != nil {
+			return mismatches, err
+		}
+		if reason != "" {
+			mismatches = append(mismatches, Mismatch{Kind: "queue", Name: queue.Name, Reason: reason})
+		}
+	}
+	return mismatches, nil
+}
+
+// verify 被动声明不存在时返回"不存在" 存在时按期望参数声明 返回rabbitmq的不一致原因
+func verify(conn *amqp.Connection, passive, declare func(*amqp.Channel) error) (string, error) {
+	for i, step := range []func(*amqp.Channel) error{passive, declare} {
+		channel, err := conn.Channel()
+		if err != nil {
+			return "", err
+		}
+		err = step(channel)
+		channel.Close()
+		amqpErr, ok := err.(*amqp.Error)
+		switch {
+		case err == nil:
+			continue
+		case !ok:
+			return "", err
+		case i == 0 && amqpErr.Code == amqp.NotFound:
+			return "不存在", nil
+		default:
+			return amqpErr.Reason, nil
+		}
+	}
+	return "", nil
+}
+
+// ApplyTopology 生产者声明拓扑 断线重连后自动重新声明 断线时返回ErrDisconnected 声明已记录 重连后自动执行
+func (p *ProducerMQ) ApplyTopology(topology *Topology) error {
+	// 断线时继续记录其余声明 重连后一起执行
+	var disconnected error
+	for _, exchange := range topology.Exchanges {
+		err := p.DeclareExchange(exchange)
+		if err == ErrDisconnected {
+			disconnected = err
+		} else if err != nil {
+			return err
+		}
+	}
+	for _, queue := range topology.Queues {
+		err := p.DeclareQueue(queue)
+		if err == ErrDisconnected {
+			disconnected = err
+		} else if err != nil {
+			return err
+		}
+	}
+	err := p.Bind(topology.Bindings...)
+	if err != nil {
+		return err
+	}
+	return disconnected
+}
+
+// VerifyTopology 对比拓扑与rabbitmq的现状
+func (p *ProducerMQ) VerifyTopology(topology *Topology) ([]Mismatch, error) {
+	p.mu.Lock()
+	conn, connected := p.conn, p.connected
+	p.mu.Unlock()
+	if !connected {
+		return nil, ErrDisconnected
+	}
+	return topology.Verify(conn)
+}
+
+// SetTopology 消费者每次连接成功后 启动接收者前声明拓扑 声明失败时记录日志
+func (mq *ConsumerMQ) SetTopology(topology *Topology) {
+	mq.mu.Lock()
+	mq.topology = topology
+	mq.mu.Unlock()
+}
+
+// VerifyTopology 对比拓扑与rabbitmq的现状
+func (mq *ConsumerMQ) VerifyTopology(topology *Topology) ([]Mismatch, error) {
+	mq.mu.Lock()
+	conn := mq.conn
+	mq.mu.Unlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrDisconnected
+	}
+	return topology.Verify(conn)
+}
+
+// applyTopology 在独立通道上声明消费者的拓扑 失败不影响管理通道
+func (mq *ConsumerMQ) applyTopology(conn *amqp.Connection) error {
+	mq.mu.Lock()
+	topology := mq.topology
+	mq.mu.Unlock()
+	if topology == nil {
+		return nil
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	return topology.Apply(channel)
+}
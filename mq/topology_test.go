@@ -0,0 +1,152 @@
+package mq
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueueArguments(t *testing.T) {
+	options := QueueOptions{
+		Name:               "order.close",
+		TTL:                2 * time.Hour,
+		MaxLength:          1000,
+		Quorum:             true,
+		DeadLetterExchange: "dlx",
+	}
+	args := options.Arguments()
+	if args["x-message-ttl"] != int64(7200000) || args["x-max-length"] != int64(1000) ||
+		args["x-queue-type"] != "quorum" || args["x-dead-letter-exchange"] != "dlx" {
+		t.Fatalf("队列参数错误: %v", args)
+	}
+	if _, ok := args["x-dead-letter-routing-key"]; ok {
+		t.Fatal("未设置死信路由键时不应保留")
+	}
+	if (QueueOptions{Name: "tmp"}).Arguments() != nil {
+		t.Fatal("无参数时应为nil")
+	}
+	options.Exclusive = true
+	if options.validate() == nil {
+		t.Fatal("独占的仲裁队列应校验失败")
+	}
+}
+
+func TestLoadTopology(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"topology.yaml": `
+exchanges:
+  - name: order
+    kind: topic
+queues:
+  - name: order.close
+    ttl: 2h
+    dead_letter_exchange: dlx
+    args:
+      x-max-priority: 10
+bindings:
+  - queue: order.close
+    exchange: order
+    key: order.*.close
+`,
+		"topology.json": `{
+  "exchanges": [{"name": "order", "kind": "topic"}],
+  "queues": [{"name": "order.close", "ttl": "2h", "dead_letter_exchange": "dlx", "args": {"x-max-priority": 10}}],
+  "bindings": [{"queue": "order.close", "exchange": "order", "key": "order.*.close"}]
+}`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		err = ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		topology, err := LoadTopology(file)
+		if err != nil {
+			t.Fatalf("%s 加载失败: %v", name, err)
+		}
+		if len(topology.Exchanges) != 1 || topology.Exchanges[0].Kind != EXCHANGE_TOPIC {
+			t.Fatalf("%s 交换机解析错误: %+v", name, topology.Exchanges)
+		}
+		args := topology.Queues[0].Arguments()
+		if args["x-message-ttl"] != int64(7200000) || args["x-dead-letter-exchange"] != "dlx" {
+			t.Fatalf("%s 队列参数解析错误: %v", name, args)
+		}
+		switch args["x-max-priority"].(type) {
+		case int, int64:
+		default:
+			t.Fatalf("%s 整数参数类型错误: %T", name, args["x-max-priority"])
+		}
+		if topology.Bindings[0].Key != "order.*.close" {
+			t.Fatalf("%s 绑定解析错误: %+v", name, topology.Bindings)
+		}
+	}
+}
+
+func TestTopologyArgsCase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"service.yaml": `
+mq:
+  topology:
+    exchanges:
+      - name: event
+        kind: headers
+    bindings:
+      - queue: order.event
+        exchange: event
+        args:
+          x-match: all
+          Event-Type: OrderPaid
+`,
+		"service.json": `{"mq": {"topology": {
+  "exchanges": [{"name": "event", "kind": "headers"}],
+  "bindings": [{"queue": "order.event", "exchange": "event", "args": {"x-match": "all", "Event-Type": "OrderPaid"}}]
+}}}`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		err = ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := viper.New()
+		v.SetConfigFile(file)
+		err = v.ReadInConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		topology, err := TopologyFromViper(v, "mq.topology")
+		if err != nil {
+			t.Fatalf("%s 加载失败: %v", name, err)
+		}
+		args := topology.Bindings[0].Args
+		if args["Event-Type"] != "OrderPaid" || args["x-match"] != "all" || args["event-type"] != nil {
+			t.Fatalf("%s 参数名应保留大小写: %v", name, args)
+		}
+	}
+}
+
+func TestPublishWithKey(t *testing.T) {
+	if err := (&ProducerMQ{}).PublishWithKey("paid", "1"); err == nil {
+		t.Fatal("未Declare时应返回err")
+	}
+	p := newBufferedProducer()
+	if err := p.PublishWithKey("refund", "1"); err != nil {
+		t.Fatalf("断线缓存失败: %v", err)
+	}
+	if len(p.buffer) != 1 || p.buffer[0].exchange != "order.exchange" || p.buffer[0].key != "refund" {
+		t.Fatalf("应使用Declare的交换机和指定路由键: %+v", p.buffer)
+	}
+}
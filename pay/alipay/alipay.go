@@ -0,0 +1,287 @@
+// alipay 支付宝开放平台支付接口 签名方式为RSA2
+package alipay
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/mjd-pub/common_golang/pay"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GATEWAY         = "https://openapi.alipay.com/gateway.do"    // 正式环境网关
+	SANDBOX_GATEWAY = "https://openapi.alipaydev.com/gateway.do" // 沙箱环境网关
+)
+
+const (
+	TRADE_PAGE_PAY = "alipay.trade.page.pay" // 电脑网站支付
+	TRADE_WAP_PAY  = "alipay.trade.wap.pay"  // 手机网站支付
+	TRADE_APP_PAY  = "alipay.trade.app.pay"  // app支付
+	TRADE_QUERY    = "alipay.trade.query"    // 交易查询
+	TRADE_CLOSE    = "alipay.trade.close"    // 交易关闭
+	TRADE_REFUND   = "alipay.trade.refund"   // 交易退款
+)
+
+// Alipay 支付宝客户端
+type Alipay struct {
+	appid      string
+	gateway    string
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+	client     *http.Client
+}
+
+// ErrorResponse 支付宝公共返回参数
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Msg     string `json:"msg"`
+	SubCode string `json:"sub_code"`
+	SubMsg  string `json:"sub_msg"`
+}
+
+/**
+ * NewAlipay 支付宝客户端初始化
+ * @params appid 支付宝应用appid
+ * @params privateKey 应用私钥 支持PKCS1和PKCS8 可不带PEM头
+ * @params publicKey 支付宝公钥 可不带PEM头
+ * @params sandbox 是否使用沙箱环境
+ */
+func NewAlipay(appid, privateKey, publicKey string, sandbox bool) (*Alipay, error) {
+	priKey, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.New("应用私钥错误:" + err.Error())
+	}
+	pubKey, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, errors.New("支付宝公钥错误:" + err.Error())
+	}
+	gateway := GATEWAY
+	if sandbox {
+		gateway = SANDBOX_GATEWAY
+	}
+	return &Alipay{
+		appid:      appid,
+		gateway:    gateway,
+		privateKey: priKey,
+		publicKey:  pubKey,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+/**
+ * publicParams 构造带签名的请求参数
+ * @params method 接口名称
+ * @params bizContent 业务参数
+ * @params notifyUrl 异步通知地址
+ * @params returnUrl 同步跳转地址
+ */
+func (alipay *Alipay) publicParams(method string, bizContent interface{}, notifyUrl, returnUrl string) (url.Values, error) {
+	content, err := json.Marshal(bizContent)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("app_id", alipay.appid)
+	params.Set("method", method)
+	params.Set("format", "JSON")
+	params.Set("charset", "utf-8")
+	params.Set("sign_type", "RSA2")
+	params.Set("timestamp", time.Now().Format("2006-01-02 15:04:05"))
+	params.Set("version", "1.0")
+	params.Set("biz_content", string(content))
+	if notifyUrl != "" {
+		params.Set("notify_url", notifyUrl)
+	}
+	if returnUrl != "" {
+		params.Set("return_url", returnUrl)
+	}
+	sign, err := alipay.signData(params)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("sign", sign)
+	return params, nil
+}
+
+/**
+ * request 请求支付宝网关并验签
+ * @params ctx 请求上下文 取消或超时后立即中断请求
+ * @params method 接口名称
+ * @params bizContent 业务参数
+ * @params response 返回结构体指针 对应xxx_response节点
+ */
+func (alipay *Alipay) request(ctx context.Context, method string, bizContent interface{}, response interface{}) error {
+	params, err := alipay.publicParams(method, bizContent, "", "")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", alipay.gateway, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := alipay.client.Do(req)
+	if err != nil {
+		return errors.New("请求异常:" + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
+	}
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// 保留原始json用于验签
+	var body map[string]json.RawMessage
+	err = json.Unmarshal(respData, &body)
+	if err != nil {
+		return err
+	}
+	content, ok := body[strings.Replace(method, ".", "_", -1)+"_response"]
+	if !ok {
+		return errors.New("返回数据异常:" + string(respData))
+	}
+	var sign string
+	if rawSign, ok := body["sign"]; ok {
+		json.Unmarshal(rawSign, &sign)
+	}
+	errResp := new(ErrorResponse)
+	err = json.Unmarshal(content, errResp)
+	if err != nil {
+		return err
+	}
+	// 网关级别错误(如appid错误)时支付宝不返回签名
+	if sign == "" && errResp.Code != "10000" {
+		return errors.New("业务失败:" + errResp.Code + " " + errResp.Msg + " " + errResp.SubCode + " " + errResp.SubMsg)
+	}
+	err = alipay.verify(content, sign)
+	if err != nil {
+		return err
+	}
+	if errResp.Code != "10000" {
+		return errors.New("业务失败:" + errResp.Code + " " + errResp.Msg + " " + errResp.SubCode + " " + errResp.SubMsg)
+	}
+	return json.Unmarshal(content, response)
+}
+
+// signData 对参数进行RSA2签名
+func (alipay *Alipay) signData(params url.Values) (string, error) {
+	hashed := sha256.Sum256([]byte(signContent(params)))
+	sign, err := rsa.SignPKCS1v15(rand.Reader, alipay.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", errors.New("签名错误:" + err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(sign), nil
+}
+
+// verify 使用支付宝公钥验签
+func (alipay *Alipay) verify(content []byte, sign string) error {
+	signData, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return errors.New("验签失败:" + err.Error())
+	}
+	hashed := sha256.Sum256(content)
+	err = rsa.VerifyPKCS1v15(alipay.publicKey, crypto.SHA256, hashed[:], signData)
+	if err != nil {
+		return errors.New("验签失败:签名不一致")
+	}
+	return nil
+}
+
+// signContent 参数按key排序后拼接为待签名字符串 跳过sign和空值
+func signContent(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key == "sign" || params.Get(key) == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buff := make([]string, 0, len(keys))
+	for _, key := range keys {
+		buff = append(buff, key+"="+params.Get(key))
+	}
+	return strings.Join(buff, "&")
+}
+
+// parsePrivateKey 解析应用私钥
+func parsePrivateKey(key string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(formatKey(key, "RSA PRIVATE KEY")))
+	if block == nil {
+		return nil, errors.New("私钥格式错误")
+	}
+	if priKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return priKey, nil
+	}
+	priKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := priKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("私钥不是RSA私钥")
+	}
+	return rsaKey, nil
+}
+
+// parsePublicKey 解析支付宝公钥
+func parsePublicKey(key string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(formatKey(key, "PUBLIC KEY")))
+	if block == nil {
+		return nil, errors.New("公钥格式错误")
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := pubKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("公钥不是RSA公钥")
+	}
+	return rsaKey, nil
+}
+
+// formatKey 支付宝后台导出的密钥不带PEM头 补齐后再解析
+func formatKey(key string, keyType string) string {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "-----BEGIN") {
+		return key
+	}
+	buff := "-----BEGIN " + keyType + "-----\n"
+	for len(key) > 64 {
+		buff += key[:64] + "\n"
+		key = key[64:]
+	}
+	buff += key + "\n-----END " + keyType + "-----\n"
+	return buff
+}
+
+// formatAmount 金额由分转换为支付宝要求的元
+func formatAmount(amount int) string {
+	return pay.Money(amount).String()
+}
+
+// parseAmount 金额由元转换为分
+func parseAmount(amount string) int {
+	money, err := pay.ParseYuan(amount)
+	if err != nil {
+		return 0
+	}
+	return money.Fen()
+}
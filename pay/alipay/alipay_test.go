@@ -0,0 +1,55 @@
+package alipay
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestSignAndVerifyNotify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	// 与支付宝后台导出的格式一致 不带PEM头
+	alipay, err := NewAlipay("2016000000000000",
+		base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key)),
+		base64.StdEncoding.EncodeToString(pubKey), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Set("app_id", "2016000000000000")
+	form.Set("out_trade_no", "20191010001")
+	form.Set("trade_status", "TRADE_SUCCESS")
+	form.Set("total_amount", "12.30")
+	sign, err := alipay.signData(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("sign", sign)
+	form.Set("sign_type", "RSA2")
+	if err = alipay.VerifyNotify(form); err != nil {
+		t.Error(err)
+	}
+	form.Set("total_amount", "1230.00")
+	if err = alipay.VerifyNotify(form); err == nil {
+		t.Error("篡改金额后验签应失败")
+	}
+}
+
+func TestAmount(t *testing.T) {
+	cases := map[int]string{1: "0.01", 10: "0.10", 1230: "12.30", 100000: "1000.00"}
+	for fen, yuan := range cases {
+		if formatAmount(fen) != yuan {
+			t.Errorf("formatAmount(%d) = %s, want %s", fen, formatAmount(fen), yuan)
+		}
+		if parseAmount(yuan) != fen {
+			t.Errorf("parseAmount(%s) = %d, want %d", yuan, parseAmount(yuan), fen)
+		}
+	}
+}
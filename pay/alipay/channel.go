@@ -0,0 +1,184 @@
+package alipay
+
+import (
+	"context"
+	"github.com/mjd-pub/common_golang/pay"
+	"net/http"
+)
+
+const (
+	CHANNEL_PAGE = "alipay_page" // 电脑网站支付
+	CHANNEL_WAP  = "alipay_wap"  // 手机网站支付
+	CHANNEL_APP  = "alipay_app"  // app支付
+)
+
+// channel 基于Alipay实现pay.Channel
+type channel struct {
+	name   string
+	alipay *Alipay
+}
+
+var _ pay.ContextChannel = (*channel)(nil)
+
+func init() {
+	pay.Register(CHANNEL_PAGE, newChannel(CHANNEL_PAGE))
+	pay.Register(CHANNEL_WAP, newChannel(CHANNEL_WAP))
+	pay.Register(CHANNEL_APP, newChannel(CHANNEL_APP))
+}
+
+func newChannel(name string) pay.Factory {
+	return func(config pay.Config) (pay.Channel, error) {
+		alipay, err := NewAlipay(config.Appid, config.PrivateKey, config.PublicKey, config.Sandbox)
+		if err != nil {
+			return nil, err
+		}
+		return &channel{
+			name:   name,
+			alipay: alipay,
+		}, nil
+	}
+}
+
+// Name 渠道名称
+func (c *channel) Name() string {
+	return c.name
+}
+
+// CreateOrder 下单 网页和手机网站返回跳转地址 app返回orderString
+func (c *channel) CreateOrder(order pay.Order) (*pay.PrepayResult, error) {
+	return c.CreateOrderContext(context.Background(), order)
+}
+
+// CreateOrderContext 下单 支付宝下单只在本地签名 不请求网关 ctx仅用于实现pay.ContextChannel
+func (c *channel) CreateOrderContext(ctx context.Context, order pay.Order) (*pay.PrepayResult, error) {
+	request := TradePayRequest{
+		OutTradeNo:  order.OutTradeNo,
+		TotalAmount: formatAmount(order.Amount),
+		Subject:     order.Subject,
+		Body:        order.Body,
+	}
+	result := &pay.PrepayResult{
+		Channel:    c.name,
+		OutTradeNo: order.OutTradeNo,
+	}
+	var err error
+	switch c.name {
+	case CHANNEL_PAGE:
+		result.PayUrl, err = c.alipay.PagePay(request, order.NotifyUrl, order.ReturnUrl)
+	case CHANNEL_WAP:
+		result.PayUrl, err = c.alipay.WapPay(request, order.NotifyUrl, order.ReturnUrl)
+	default:
+		var orderString string
+		orderString, err = c.alipay.AppPay(request, order.NotifyUrl)
+		result.Params = map[string]string{
+			"orderString": orderString,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Query 交易查询
+func (c *channel) Query(outTradeNo string) (*pay.QueryResult, error) {
+	return c.QueryContext(context.Background(), outTradeNo)
+}
+
+// QueryContext 交易查询 支持ctx取消和超时
+func (c *channel) QueryContext(ctx context.Context, outTradeNo string) (*pay.QueryResult, error) {
+	resp, err := c.alipay.QueryContext(ctx, TradeQueryRequest{OutTradeNo: outTradeNo})
+	if err != nil {
+		return nil, err
+	}
+	return &pay.QueryResult{
+		Status:     tradeStatus(resp.TradeStatus),
+		OutTradeNo: resp.OutTradeNo,
+		TradeNo:    resp.TradeNo,
+		Amount:     parseAmount(resp.TotalAmount),
+		PaidAt:     resp.SendPayDate,
+		StatusDesc: resp.TradeStatus,
+	}, nil
+}
+
+// Close 关闭交易
+func (c *channel) Close(outTradeNo string) error {
+	return c.CloseContext(context.Background(), outTradeNo)
+}
+
+// CloseContext 关闭交易 支持ctx取消和超时
+func (c *channel) CloseContext(ctx context.Context, outTradeNo string) error {
+	_, err := c.alipay.CloseContext(ctx, TradeQueryRequest{OutTradeNo: outTradeNo})
+	return err
+}
+
+// Refund 退款 支付宝同步返回退款结果
+func (c *channel) Refund(request pay.RefundRequest) (*pay.RefundResult, error) {
+	return c.RefundContext(context.Background(), request)
+}
+
+// RefundContext 退款 支持ctx取消和超时
+func (c *channel) RefundContext(ctx context.Context, request pay.RefundRequest) (*pay.RefundResult, error) {
+	resp, err := c.alipay.RefundContext(ctx, TradeRefundRequest{
+		OutTradeNo:   request.OutTradeNo,
+		TradeNo:      request.TradeNo,
+		RefundAmount: formatAmount(request.RefundAmount),
+		RefundReason: request.Reason,
+		OutRequestNo: request.OutRefundNo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status := pay.REFUND_SUCCESS
+	if resp.FundChange != "Y" {
+		// 重复请求时fund_change为N 退款结果需通过退款查询确认
+		status = pay.REFUND_PROCESS
+	}
+	// 支付宝退款没有独立的退款单号 以out_request_no标识本次退款
+	return &pay.RefundResult{
+		Status:       status,
+		OutRefundNo:  request.OutRefundNo,
+		RefundId:     request.OutRefundNo,
+		RefundAmount: request.RefundAmount,
+	}, nil
+}
+
+// ParseNotify 解析支付结果通知
+func (c *channel) ParseNotify(request *http.Request) (*pay.Notification, error) {
+	notification, err := c.alipay.ParseNotify(request)
+	if err != nil {
+		return nil, err
+	}
+	return &pay.Notification{
+		Status:     tradeStatus(notification.TradeStatus),
+		OutTradeNo: notification.OutTradeNo,
+		TradeNo:    notification.TradeNo,
+		Amount:     parseAmount(notification.TotalAmount),
+		Buyer:      notification.BuyerId,
+		PaidAt:     notification.GmtPayment,
+		Raw:        notification,
+	}, nil
+}
+
+// ReplyNotify 支付宝要求处理成功后返回纯文本success
+func (c *channel) ReplyNotify(w http.ResponseWriter, success bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if success {
+		w.Write([]byte("success"))
+		return
+	}
+	w.Write([]byte("fail"))
+}
+
+// tradeStatus 支付宝trade_status转换为pay交易状态
+func tradeStatus(status string) int {
+	switch status {
+	case "WAIT_BUYER_PAY":
+		return pay.TRADE_WAITING
+	case "TRADE_SUCCESS", "TRADE_FINISHED":
+		return pay.TRADE_SUCCESS
+	case "TRADE_CLOSED":
+		return pay.TRADE_CLOSED
+	}
+	return pay.TRADE_UNKNOWN
+}
@@ -0,0 +1,219 @@
+package alipay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// TradePayRequest 网页/手机网站/app支付业务参数
+type TradePayRequest struct {
+	OutTradeNo     string `json:"out_trade_no"`
+	ProductCode    string `json:"product_code"`
+	TotalAmount    string `json:"total_amount"`
+	Subject        string `json:"subject"`
+	Body           string `json:"body,omitempty"`
+	TimeoutExpress string `json:"timeout_express,omitempty"`
+	QuitUrl        string `json:"quit_url,omitempty"`
+}
+
+// TradeQueryRequest 交易查询/关闭业务参数
+type TradeQueryRequest struct {
+	OutTradeNo string `json:"out_trade_no,omitempty"`
+	TradeNo    string `json:"trade_no,omitempty"`
+}
+
+// TradeQueryResponse 交易查询返回
+type TradeQueryResponse struct {
+	ErrorResponse
+	TradeNo      string `json:"trade_no"`
+	OutTradeNo   string `json:"out_trade_no"`
+	BuyerLogonId string `json:"buyer_logon_id"`
+	BuyerUserId  string `json:"buyer_user_id"`
+	TradeStatus  string `json:"trade_status"`
+	TotalAmount  string `json:"total_amount"`
+	SendPayDate  string `json:"send_pay_date"`
+}
+
+// TradeCloseResponse 交易关闭返回
+type TradeCloseResponse struct {
+	ErrorResponse
+	TradeNo    string `json:"trade_no"`
+	OutTradeNo string `json:"out_trade_no"`
+}
+
+// TradeRefundRequest 退款业务参数
+type TradeRefundRequest struct {
+	OutTradeNo   string `json:"out_trade_no,omitempty"`
+	TradeNo      string `json:"trade_no,omitempty"`
+	RefundAmount string `json:"refund_amount"`
+	RefundReason string `json:"refund_reason,omitempty"`
+	OutRequestNo string `json:"out_request_no,omitempty"`
+}
+
+// TradeRefundResponse 退款返回
+type TradeRefundResponse struct {
+	ErrorResponse
+	TradeNo      string `json:"trade_no"`
+	OutTradeNo   string `json:"out_trade_no"`
+	BuyerUserId  string `json:"buyer_user_id"`
+	FundChange   string `json:"fund_change"`
+	RefundFee    string `json:"refund_fee"`
+	GmtRefundPay string `json:"gmt_refund_pay"`
+}
+
+// TradeNotification 支付结果异步通知
+type TradeNotification struct {
+	NotifyTime  string `json:"notify_time"`
+	NotifyType  string `json:"notify_type"`
+	NotifyId    string `json:"notify_id"`
+	AppId       string `json:"app_id"`
+	TradeNo     string `json:"trade_no"`
+	OutTradeNo  string `json:"out_trade_no"`
+	BuyerId     string `json:"buyer_id"`
+	TradeStatus string `json:"trade_status"`
+	TotalAmount string `json:"total_amount"`
+	GmtPayment  string `json:"gmt_payment"`
+}
+
+/**
+ * PagePay 电脑网站支付
+ * @params request 业务参数 ProductCode为空时使用FAST_INSTANT_TRADE_PAY
+ * @return payUrl 跳转支付宝收银台的地址
+ */
+func (alipay *Alipay) PagePay(request TradePayRequest, notifyUrl, returnUrl string) (payUrl string, err error) {
+	if request.ProductCode == "" {
+		request.ProductCode = "FAST_INSTANT_TRADE_PAY"
+	}
+	params, err := alipay.publicParams(TRADE_PAGE_PAY, request, notifyUrl, returnUrl)
+	if err != nil {
+		return "", err
+	}
+	return alipay.gateway + "?" + params.Encode(), nil
+}
+
+/**
+ * WapPay 手机网站支付
+ * @params request 业务参数 ProductCode为空时使用QUICK_WAP_WAY
+ * @return payUrl 跳转支付宝收银台的地址
+ */
+func (alipay *Alipay) WapPay(request TradePayRequest, notifyUrl, returnUrl string) (payUrl string, err error) {
+	if request.ProductCode == "" {
+		request.ProductCode = "QUICK_WAP_WAY"
+	}
+	params, err := alipay.publicParams(TRADE_WAP_PAY, request, notifyUrl, returnUrl)
+	if err != nil {
+		return "", err
+	}
+	return alipay.gateway + "?" + params.Encode(), nil
+}
+
+/**
+ * AppPay app支付
+ * @params request 业务参数 ProductCode为空时使用QUICK_MSECURITY_PAY
+ * @return orderString 客户端SDK调起支付使用的订单串
+ */
+func (alipay *Alipay) AppPay(request TradePayRequest, notifyUrl string) (orderString string, err error) {
+	if request.ProductCode == "" {
+		request.ProductCode = "QUICK_MSECURITY_PAY"
+	}
+	params, err := alipay.publicParams(TRADE_APP_PAY, request, notifyUrl, "")
+	if err != nil {
+		return "", err
+	}
+	return params.Encode(), nil
+}
+
+// Query 交易查询
+func (alipay *Alipay) Query(request TradeQueryRequest) (queryResponse *TradeQueryResponse, err error) {
+	return alipay.QueryContext(context.Background(), request)
+}
+
+// QueryContext 交易查询 支持ctx取消和超时
+func (alipay *Alipay) QueryContext(ctx context.Context, request TradeQueryRequest) (queryResponse *TradeQueryResponse, err error) {
+	queryResponse = new(TradeQueryResponse)
+	err = alipay.request(ctx, TRADE_QUERY, request, queryResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Close 关闭未支付的交易
+func (alipay *Alipay) Close(request TradeQueryRequest) (closeResponse *TradeCloseResponse, err error) {
+	return alipay.CloseContext(context.Background(), request)
+}
+
+// CloseContext 关闭未支付的交易 支持ctx取消和超时
+func (alipay *Alipay) CloseContext(ctx context.Context, request TradeQueryRequest) (closeResponse *TradeCloseResponse, err error) {
+	closeResponse = new(TradeCloseResponse)
+	err = alipay.request(ctx, TRADE_CLOSE, request, closeResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Refund 退款 支付宝退款为同步返回
+func (alipay *Alipay) Refund(request TradeRefundRequest) (refundResponse *TradeRefundResponse, err error) {
+	return alipay.RefundContext(context.Background(), request)
+}
+
+// RefundContext 退款 支持ctx取消和超时
+func (alipay *Alipay) RefundContext(ctx context.Context, request TradeRefundRequest) (refundResponse *TradeRefundResponse, err error) {
+	refundResponse = new(TradeRefundResponse)
+	err = alipay.request(ctx, TRADE_REFUND, request, refundResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+/**
+ * ParseNotify 解析并验签支付结果异步通知
+ * @params request 支付宝POST过来的form请求
+ * @return TradeNotification err
+ */
+func (alipay *Alipay) ParseNotify(request *http.Request) (notification *TradeNotification, err error) {
+	err = request.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	err = alipay.VerifyNotify(request.PostForm)
+	if err != nil {
+		return nil, err
+	}
+	form := request.PostForm
+	if form.Get("app_id") != alipay.appid {
+		return nil, errors.New("验签失败:app_id不一致")
+	}
+	return &TradeNotification{
+		NotifyTime:  form.Get("notify_time"),
+		NotifyType:  form.Get("notify_type"),
+		NotifyId:    form.Get("notify_id"),
+		AppId:       form.Get("app_id"),
+		TradeNo:     form.Get("trade_no"),
+		OutTradeNo:  form.Get("out_trade_no"),
+		BuyerId:     form.Get("buyer_id"),
+		TradeStatus: form.Get("trade_status"),
+		TotalAmount: form.Get("total_amount"),
+		GmtPayment:  form.Get("gmt_payment"),
+	}, nil
+}
+
+// VerifyNotify 异步通知验签 sign和sign_type不参与签名
+func (alipay *Alipay) VerifyNotify(form url.Values) error {
+	sign := form.Get("sign")
+	if sign == "" {
+		return errors.New("验签失败:缺少签名")
+	}
+	params := url.Values{}
+	for key, value := range form {
+		if key == "sign" || key == "sign_type" {
+			continue
+		}
+		params[key] = value
+	}
+	return alipay.verify([]byte(signContent(params)), sign)
+}
@@ -0,0 +1,68 @@
+package pay
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Money 金额 单位为分 避免使用浮点数计算金额
+type Money int64
+
+// Fen 金额(分) 用于赋值给int类型的金额字段
+func (m Money) Fen() int {
+	return int(m)
+}
+
+// Yuan 金额(元) 仅用于展示 不要参与计算
+func (m Money) Yuan() float64 {
+	return float64(m) / 100
+}
+
+// String 格式化为两位小数的元 如1234分为"12.34"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	fen := strconv.FormatInt(int64(m%100), 10)
+	if len(fen) == 1 {
+		fen = "0" + fen
+	}
+	return sign + strconv.FormatInt(int64(m/100), 10) + "." + fen
+}
+
+/**
+ * ParseYuan 解析以元为单位的金额字符串 最多两位小数
+ * @params yuan 如"12.34" "-0.5" "100"
+ * @return Money err
+ */
+func ParseYuan(yuan string) (Money, error) {
+	value := strings.TrimSpace(yuan)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+	parts := strings.SplitN(value, ".", 2)
+	if parts[0] == "" {
+		return 0, errors.New("金额格式错误:" + yuan)
+	}
+	integer, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, errors.New("金额格式错误:" + yuan)
+	}
+	var decimal int64
+	if len(parts) == 2 {
+		if len(parts[1]) == 0 || len(parts[1]) > 2 {
+			return 0, errors.New("金额格式错误:" + yuan)
+		}
+		decimal, err = strconv.ParseInt((parts[1] + "0")[:2], 10, 64)
+		if err != nil {
+			return 0, errors.New("金额格式错误:" + yuan)
+		}
+	}
+	money := Money(integer*100 + decimal)
+	if negative {
+		money = -money
+	}
+	return money, nil
+}
@@ -0,0 +1,21 @@
+package pay
+
+import (
+	"testing"
+)
+
+func TestMoney(t *testing.T) {
+	for yuan, fen := range map[string]Money{"0.01": 1, "0.10": 10, "12.34": 1234, "100.00": 10000, "-0.50": -50} {
+		if fen.String() != yuan {
+			t.Errorf("Money(%d).String() = %s, want %s", fen, fen.String(), yuan)
+		}
+		if got, err := ParseYuan(yuan); err != nil || got != fen {
+			t.Errorf("ParseYuan(%s) = %d, %v", yuan, got, err)
+		}
+	}
+	for _, yuan := range []string{"", "abc", "1.234", "1.", "-"} {
+		if _, err := ParseYuan(yuan); err == nil {
+			t.Errorf("ParseYuan(%q) 应返回错误", yuan)
+		}
+	}
+}
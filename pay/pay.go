@@ -0,0 +1,165 @@
+// pay 支付渠道抽象 业务方通过配置选择渠道 不直接依赖具体的支付实现
+package pay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// 交易状态
+const (
+	TRADE_UNKNOWN = 0 // 未知 需稍后再查
+	TRADE_WAITING = 1 // 待支付
+	TRADE_SUCCESS = 2 // 支付成功
+	TRADE_CLOSED  = 3 // 已关闭
+	TRADE_REFUND  = 4 // 转入退款
+	TRADE_FAIL    = 5 // 支付失败
+)
+
+// 退款状态
+const (
+	REFUND_PROCESS = 11
+	REFUND_SUCCESS = 12
+	REFUND_FAIL    = 13
+)
+
+// Channel 支付渠道
+type Channel interface {
+	// Name 渠道名称 与Config.Channel一致
+	Name() string
+	// CreateOrder 下单 返回前端调起支付需要的参数或跳转地址
+	CreateOrder(order Order) (*PrepayResult, error)
+	// Query 根据商户订单号查询交易
+	Query(outTradeNo string) (*QueryResult, error)
+	// Close 关闭未支付的交易
+	Close(outTradeNo string) error
+	// Refund 申请退款
+	Refund(request RefundRequest) (*RefundResult, error)
+	// ParseNotify 解析并验签支付结果异步通知
+	ParseNotify(request *http.Request) (*Notification, error)
+	// ReplyNotify 按渠道要求的格式回复异步通知
+	ReplyNotify(w http.ResponseWriter, success bool)
+}
+
+// ContextChannel 支持ctx取消和超时的支付渠道 内置的微信和支付宝渠道均已实现
+// 使用时通过类型断言获取 如 channel.(pay.ContextChannel)
+type ContextChannel interface {
+	Channel
+	CreateOrderContext(ctx context.Context, order Order) (*PrepayResult, error)
+	QueryContext(ctx context.Context, outTradeNo string) (*QueryResult, error)
+	CloseContext(ctx context.Context, outTradeNo string) error
+	RefundContext(ctx context.Context, request RefundRequest) (*RefundResult, error)
+}
+
+// Config 渠道配置 可直接从json配置文件加载
+type Config struct {
+	Channel       string `json:"channel"`        // 渠道名称 如wechat_applet wechat_h5 alipay_page alipay_wap alipay_app
+	Appid         string `json:"appid"`          // 微信appid或支付宝应用appid
+	MchId         string `json:"mch_id"`         // 微信商户号
+	Key           string `json:"key"`            // 微信支付密钥
+	ApiclientKey  string `json:"apiclient_key"`  // 微信商户证书私钥
+	ApiclientCert string `json:"apiclient_cert"` // 微信商户证书
+	PrivateKey    string `json:"private_key"`    // 支付宝应用私钥
+	PublicKey     string `json:"public_key"`     // 支付宝公钥
+	Sandbox       bool   `json:"sandbox"`        // 是否使用沙箱环境
+}
+
+// Order 下单参数
+type Order struct {
+	OutTradeNo string // 商户订单号
+	Subject    string // 订单标题
+	Body       string // 订单描述
+	Amount     int    // 金额(单位:分)
+	ClientIp   string // 用户ip
+	NotifyUrl  string // 异步通知地址
+	ReturnUrl  string // 支付完成后的跳转地址
+	Openid     string // 微信JSAPI支付时的用户openid
+}
+
+// PrepayResult 下单返回
+type PrepayResult struct {
+	Channel    string            // 渠道名称
+	OutTradeNo string            // 商户订单号
+	PrepayId   string            // 预支付交易会话标识(微信)
+	PayUrl     string            // 跳转支付地址(微信h5 支付宝网页/手机网站)
+	Params     map[string]string // 前端调起支付的参数(微信小程序 支付宝app)
+}
+
+// QueryResult 交易查询返回
+type QueryResult struct {
+	Status     int    // 交易状态
+	OutTradeNo string // 商户订单号
+	TradeNo    string // 渠道交易号
+	Amount     int    // 订单金额(单位:分)
+	PaidAt     string // 支付完成时间
+	StatusDesc string // 渠道原始状态
+}
+
+// RefundRequest 退款参数
+type RefundRequest struct {
+	OutTradeNo   string // 商户订单号
+	TradeNo      string // 渠道交易号 与OutTradeNo二选一
+	OutRefundNo  string // 商户退款单号
+	TotalAmount  int    // 订单金额(单位:分)
+	RefundAmount int    // 退款金额(单位:分)
+	Reason       string // 退款原因
+	NotifyUrl    string // 退款结果通知地址
+}
+
+// RefundResult 退款返回
+type RefundResult struct {
+	Status       int    // 退款状态
+	OutRefundNo  string // 商户退款单号
+	RefundId     string // 渠道退款单号
+	RefundAmount int    // 退款金额(单位:分)
+}
+
+// Notification 支付结果通知
+type Notification struct {
+	Status     int         // 交易状态
+	OutTradeNo string      // 商户订单号
+	TradeNo    string      // 渠道交易号
+	Amount     int         // 订单金额(单位:分)
+	Buyer      string      // 付款用户 微信openid或支付宝buyer_id
+	PaidAt     string      // 支付完成时间
+	Raw        interface{} // 渠道原始通知结构
+}
+
+// Factory 根据配置创建渠道
+type Factory func(config Config) (Channel, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register 注册渠道 由各渠道包在init中调用
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("pay: Register factory is nil")
+	}
+	if _, ok := factories[name]; ok {
+		panic("pay: Register called twice for channel " + name)
+	}
+	factories[name] = factory
+}
+
+/**
+ * New 根据配置创建支付渠道 使用前需引入对应的渠道包
+ * 如 import _ "github.com/mjd-pub/common_golang/pay/alipay"
+ * @params config 渠道配置
+ * @return Channel err
+ */
+func New(config Config) (Channel, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[config.Channel]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, errors.New("未注册的支付渠道:" + config.Channel)
+	}
+	return factory(config)
+}
@@ -0,0 +1,166 @@
+package wechat
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+const JSCODE2SESSION = "https://api.weixin.qq.com/sns/jscode2session" // 小程序登录凭证校验
+
+// AppletAuth 小程序登录 通过wx.login的code换取openid和unionid
+type AppletAuth struct {
+	*credential
+}
+
+// AppletSession jscode2session返回
+type AppletSession struct {
+	ApiError
+	Openid     string `json:"openid"`
+	SessionKey string `json:"session_key"`
+	Unionid    string `json:"unionid"` // 小程序绑定到开放平台且用户已关注同主体公众号等情况下才返回
+}
+
+// AppletWatermark 加密数据水印 用于校验数据归属的小程序
+type AppletWatermark struct {
+	Appid     string `json:"appid"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AppletUserInfo wx.getUserInfo的加密用户信息
+type AppletUserInfo struct {
+	OpenId    string          `json:"openId"`
+	UnionId   string          `json:"unionId"`
+	NickName  string          `json:"nickName"`
+	Gender    int             `json:"gender"`
+	City      string          `json:"city"`
+	Province  string          `json:"province"`
+	Country   string          `json:"country"`
+	AvatarUrl string          `json:"avatarUrl"`
+	Watermark AppletWatermark `json:"watermark"`
+}
+
+// AppletPhoneNumber getPhoneNumber的加密手机号
+type AppletPhoneNumber struct {
+	PhoneNumber     string          `json:"phoneNumber"`
+	PurePhoneNumber string          `json:"purePhoneNumber"`
+	CountryCode     string          `json:"countryCode"`
+	Watermark       AppletWatermark `json:"watermark"`
+}
+
+/**
+ * NewAppletAuthClient 构造小程序登录客户端
+ * @params appid 小程序appid
+ * @params secret 小程序appsecret
+ * @return AppletAuth
+ */
+func NewAppletAuthClient(appid, secret string) *AppletAuth {
+	return &AppletAuth{
+		credential: newCredential(appid, secret),
+	}
+}
+
+/**
+ * Code2Session 登录凭证校验
+ * @params code wx.login获取的code 只能使用一次
+ * @return AppletSession err 微信返回错误时err为*ApiError
+ */
+func (auth *AppletAuth) Code2Session(code string) (*AppletSession, error) {
+	return auth.Code2SessionContext(context.Background(), code)
+}
+
+// Code2SessionContext 登录凭证校验 支持ctx取消和超时
+func (auth *AppletAuth) Code2SessionContext(ctx context.Context, code string) (*AppletSession, error) {
+	if code == "" {
+		return nil, errors.New("code不能为空")
+	}
+	params := url.Values{}
+	params.Set("appid", auth.appid)
+	params.Set("secret", auth.secret)
+	params.Set("js_code", code)
+	params.Set("grant_type", "authorization_code")
+	session := new(AppletSession)
+	err := getJson(ctx, JSCODE2SESSION, params, session)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+/**
+ * DecryptData 使用session_key解密小程序加密数据并校验水印
+ * @params sessionKey Code2Session返回的session_key
+ * @params encryptedData 加密数据
+ * @params iv 初始向量
+ * @params v 解密后的json解码目标 如*AppletUserInfo *AppletPhoneNumber
+ */
+func (auth *AppletAuth) DecryptData(sessionKey, encryptedData, iv string, v interface{}) error {
+	plaintext, err := decryptAppletData(sessionKey, encryptedData, iv)
+	if err != nil {
+		return err
+	}
+	watermark := struct {
+		Watermark AppletWatermark `json:"watermark"`
+	}{}
+	err = json.Unmarshal(plaintext, &watermark)
+	if err != nil {
+		return errors.New("解密数据格式错误:" + err.Error())
+	}
+	if watermark.Watermark.Appid != auth.appid {
+		return errors.New("解密数据appid不匹配:" + watermark.Watermark.Appid)
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+/**
+ * DecryptUserInfo 解密用户信息 code2session未返回unionid时可从这里获取
+ * @return AppletUserInfo err
+ */
+func (auth *AppletAuth) DecryptUserInfo(sessionKey, encryptedData, iv string) (*AppletUserInfo, error) {
+	userInfo := new(AppletUserInfo)
+	err := auth.DecryptData(sessionKey, encryptedData, iv, userInfo)
+	if err != nil {
+		return nil, err
+	}
+	return userInfo, nil
+}
+
+// decryptAppletData AES-128-CBC PKCS#7 解密 key和iv均为base64
+func decryptAppletData(sessionKey, encryptedData, iv string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(sessionKey)
+	if err != nil {
+		return nil, errors.New("session_key格式错误:" + err.Error())
+	}
+	ivData, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil {
+		return nil, errors.New("iv格式错误:" + err.Error())
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil {
+		return nil, errors.New("encryptedData格式错误:" + err.Error())
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("session_key长度错误:" + err.Error())
+	}
+	if len(ivData) != block.BlockSize() {
+		return nil, errors.New("iv长度错误")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("encryptedData长度错误")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, ivData).CryptBlocks(plaintext, ciphertext)
+	// 校验PKCS#7填充 session_key错误时通常在这里失败
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > block.BlockSize() ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("解密失败:session_key已过期或数据被篡改")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,39 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecryptUserInfo(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	plaintext := []byte(`{"openId":"oGZUI0egBJY1zhBYw2KhdUfwVJJE","unionId":"ocMvos6NjeKLIBqg5Mr9QjxrP1FA","watermark":{"appid":"wx4f4bc4dec97d474b","timestamp":1477314187}}`)
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, _ := aes.NewCipher(key)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	sessionKey := base64.StdEncoding.EncodeToString(key)
+	encryptedData := base64.StdEncoding.EncodeToString(ciphertext)
+	encodedIv := base64.StdEncoding.EncodeToString(iv)
+
+	userInfo, err := NewAppletAuthClient("wx4f4bc4dec97d474b", "secret").DecryptUserInfo(sessionKey, encryptedData, encodedIv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userInfo.UnionId != "ocMvos6NjeKLIBqg5Mr9QjxrP1FA" || userInfo.OpenId != "oGZUI0egBJY1zhBYw2KhdUfwVJJE" {
+		t.Errorf("解密结果异常: %+v", userInfo)
+	}
+	if _, err = NewAppletAuthClient("wx0000000000000000", "secret").DecryptUserInfo(sessionKey, encryptedData, encodedIv); err == nil {
+		t.Error("appid不匹配时应返回错误")
+	}
+	wrongKey := base64.StdEncoding.EncodeToString([]byte("abcdef0123456789"))
+	if _, err = NewAppletAuthClient("wx4f4bc4dec97d474b", "secret").DecryptUserInfo(wrongKey, encryptedData, encodedIv); err == nil {
+		t.Error("session_key错误时应返回错误")
+	}
+}
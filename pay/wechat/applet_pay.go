@@ -1,11 +1,9 @@
 package wechat
 
 import (
-	"encoding/xml"
-	"errors"
+	"context"
 	"fmt"
 	"github.com/mjd-pub/common_golang/utils"
-	"io/ioutil"
 	"reflect"
 	"strconv"
 	"time"
@@ -64,6 +62,8 @@ type AppletPayQueryRequests struct {
 }
 
 // AppletPayQueryRespones 小程序查询订单请求返回参数
+//
+// Deprecated: OppenId TotalFree FreeType等字段拼写错误 为兼容保留 请使用QueryOrder返回的OrderQueryResult
 type AppletPayQueryRespones struct {
 	ReturnCode          string `json:"return_code,omitempty" xml:"return_code,omitempty" structs:"return_code"`
 	ReturnMsg           string `json:"return_msg,omitempty" xml:"return_msg,omitempty" structs:"return_msg"`
@@ -94,6 +94,47 @@ type AppletPayQueryRespones struct {
 	Attach              string `xml:"attach,omitempty" json:"attach,omitempty" structs:"attach"`
 	TimeEnd             string `xml:"time_end,omitempty" json:"time_end,omitempty" structs:"time_end"`
 	Trade               string `xml:"trade,omitempty" json:"trade,omitempty" structs:"trade"`
+	TradeState          string `xml:"trade_state,omitempty" json:"trade_state,omitempty" structs:"trade_state"`                // 交易状态 区分已关闭和未支付等
+	TradeStateDesc      string `xml:"trade_state_desc,omitempty" json:"trade_state_desc,omitempty" structs:"trade_state_desc"` // 交易状态描述
+}
+
+// AppletPayQueryRespones 转换为旧的查询返回结构 拼写错误的字段按正确的值填充
+func (result *OrderQueryResult) AppletPayQueryRespones() *AppletPayQueryRespones {
+	resp := &AppletPayQueryRespones{
+		ReturnCode:          result.ReturnCode,
+		ReturnMsg:           result.ReturnMsg,
+		Appid:               result.Appid,
+		MchId:               result.MchId,
+		NonceStr:            result.NonceStr,
+		Sign:                result.Sign,
+		ResultCode:          result.ResultCode,
+		ErrCode:             result.ErrCode,
+		ErrCodeDes:          result.ErrCodeDes,
+		DeviceInfo:          result.DeviceInfo,
+		OppenId:             result.Openid,
+		IsSubscribe:         result.IsSubscribe,
+		TradeType:           result.TradeType,
+		BankType:            result.BankType,
+		TotalFree:           result.TotalFee.Fen(),
+		SettlementTotalFree: result.SettlementTotalFee.Fen(),
+		FreeType:            result.FeeType,
+		CashFee:             result.CashFee.Fen(),
+		CashFeeType:         result.CashFeeType,
+		CouponFee:           result.CouponFee.Fen(),
+		CouponCount:         result.CouponCount,
+		CouponType0:         result.CouponType0,
+		CouponId0:           result.CouponId0,
+		CouponFee0:          result.CouponFee0.Fen(),
+		TransactionId:       result.TransactionId,
+		OutTradeNo:          result.OutTradeNo,
+		Attach:              result.Attach,
+		TradeState:          string(result.TradeState),
+		TradeStateDesc:      result.TradeStateDesc,
+	}
+	if !result.TimeEnd.IsZero() {
+		resp.TimeEnd = result.TimeEnd.In(wechatLocation).Format("20060102150405")
+	}
+	return resp
 }
 
 // AppletPayCloseRequests 小程序关闭订单请求参数
@@ -137,6 +178,16 @@ func NewAppletPayClient(appid, mchid, key, apiclientKey, apiclientCert string) *
 	}
 }
 
+// AddObserver 添加接口调用观察者 需在初始化时调用
+func (appletPay *AppletPay) AddObserver(observer Observer) {
+	appletPay.wechatPay.AddObserver(observer)
+}
+
+// SetGuard 开启限流和熔断 需在初始化时调用
+func (appletPay *AppletPay) SetGuard(guard *Guard) {
+	appletPay.wechatPay.SetGuard(guard)
+}
+
 /**
  * NewAppletPayRequest 构造下单请求
  *
@@ -178,22 +229,16 @@ func (appletPay *AppletPay) NewAppletPayRequest(body, detail, orderId, userIp, n
  * @return AppletPayRespones error
  */
 func (appletPay *AppletPay) Pay(request AppletPayRequest) (miniResp *AppletPayRespones, frontRequest *AppletPayFrontRequest, err error) {
+	return appletPay.PayContext(context.Background(), request)
+}
+
+// PayContext 发起支付 支持ctx取消和超时
+func (appletPay *AppletPay) PayContext(ctx context.Context, request AppletPayRequest) (miniResp *AppletPayRespones, frontRequest *AppletPayFrontRequest, err error) {
+	miniResp = new(AppletPayRespones)
 	// 向微信发送请求
-	resp, err := appletPay.wechatPay.Request(UNIFIED_ORDER, request)
-	if err != nil {
-		return nil, nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	//xml解码
-	err = xml.Unmarshal(respData, &miniResp)
+	err = appletPay.wechatPay.requestXml(ctx, UNIFIED_ORDER, request, miniResp)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
 	sign2Data := map[string]interface{}{
 		"appId":     miniResp.Appid,
@@ -226,25 +271,39 @@ func (appletPay *AppletPay) Pay(request AppletPayRequest) (miniResp *AppletPayRe
  * @params request AppletPayQueryRequests
  * @return AppletPayQueryRespones err
  */
-func (AppletPay *AppletPay) Query(request AppletPayQueryRequests) (queryResponse *AppletPayQueryRespones, err error) {
-	// 向微信发送请求
-	resp, err := AppletPay.wechatPay.Request(ORDER_QUERY, request)
-	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	//xml解码
-	err = xml.Unmarshal(respData, queryResponse)
+func (appletPay *AppletPay) Query(request AppletPayQueryRequests) (queryResponse *AppletPayQueryRespones, err error) {
+	return appletPay.QueryContext(context.Background(), request)
+}
+
+// QueryContext 小程序支付查询 支持ctx取消和超时
+func (appletPay *AppletPay) QueryContext(ctx context.Context, request AppletPayQueryRequests) (queryResponse *AppletPayQueryRespones, err error) {
+	result := new(OrderQueryResult)
+	// 向微信发送请求 按新结构解码后转换 保证金额和openid有值
+	err = appletPay.wechatPay.requestXml(ctx, ORDER_QUERY, request, result)
 	if err != nil {
 		return nil, err
 	}
-	return
+	return result.AppletPayQueryRespones(), nil
+}
+
+/**
+ * QueryOrder 查询订单 返回带交易状态的OrderQueryResult
+ *
+ * @params request OrderQueryRequest
+ * @return OrderQueryResult err
+ */
+func (appletPay *AppletPay) QueryOrder(request OrderQueryRequest) (result *OrderQueryResult, err error) {
+	return appletPay.wechatPay.QueryOrderContext(context.Background(), request)
+}
+
+// QueryOrderContext 查询订单 支持ctx取消和超时
+func (appletPay *AppletPay) QueryOrderContext(ctx context.Context, request OrderQueryRequest) (result *OrderQueryResult, err error) {
+	return appletPay.wechatPay.QueryOrderContext(ctx, request)
+}
+
+// NewOrderQueryRequest 构造查询订单请求 transactionId优先
+func (appletPay *AppletPay) NewOrderQueryRequest(transactionId, outTradeNo string) OrderQueryRequest {
+	return appletPay.wechatPay.NewOrderQueryRequest(transactionId, outTradeNo)
 }
 
 /**
@@ -254,20 +313,14 @@ func (AppletPay *AppletPay) Query(request AppletPayQueryRequests) (queryResponse
  * @return AppletPayCloseRespones err
  */
 func (appletPay *AppletPay) Close(request AppletPayCloseRequests) (queryResponse *AppletPayCloseRespones, err error) {
+	return appletPay.CloseContext(context.Background(), request)
+}
+
+// CloseContext 小程序支付关闭 支持ctx取消和超时
+func (appletPay *AppletPay) CloseContext(ctx context.Context, request AppletPayCloseRequests) (queryResponse *AppletPayCloseRespones, err error) {
+	queryResponse = new(AppletPayCloseRespones)
 	// 向微信发送请求
-	resp, err := appletPay.wechatPay.Request(ORDER_QUERY, request)
-	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	//xml解码
-	err = xml.Unmarshal(respData, queryResponse)
+	err = appletPay.wechatPay.requestXml(ctx, CLOSE_ORDER, request, queryResponse)
 	if err != nil {
 		return nil, err
 	}
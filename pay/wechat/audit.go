@@ -0,0 +1,182 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"github.com/mjd-pub/common_golang/logs"
+	"log"
+	"regexp"
+	"time"
+)
+
+// DEFAULT_MASK_FIELDS 默认脱敏字段 签名 用户标识 真实姓名以及银行卡信息
+var DEFAULT_MASK_FIELDS = []string{
+	"sign", "paySign", "openid", "re_openid", "sub_openid", "re_user_name",
+	"enc_bank_no", "enc_true_name", "bank_no", "true_name", "bank_code",
+}
+
+// AuditRecord 一次微信接口调用的审计记录
+type AuditRecord struct {
+	Uri        string        // 请求地址
+	MchId      string        // 商户号
+	Request    string        // 请求xml
+	Response   string        // 返回xml 请求失败时为空
+	HttpCode   int           // http状态码 请求失败时为0
+	StartTime  time.Time     // 请求开始时间
+	Latency    time.Duration // 请求耗时
+	Err        error         // 请求或解码错误
+	ReturnCode string        // 通信标识
+	ResultCode string        // 业务结果
+	ErrCode    string        // 业务错误码
+}
+
+// Observer 微信接口调用观察者 用于审计 监控等
+type Observer interface {
+	Observe(record *AuditRecord)
+}
+
+// ObserverFunc 函数形式的Observer
+type ObserverFunc func(record *AuditRecord)
+
+// Observe 实现Observer
+func (f ObserverFunc) Observe(record *AuditRecord) {
+	f(record)
+}
+
+// MaskFilter xml敏感字段脱敏
+type MaskFilter struct {
+	patterns []*regexp.Regexp
+}
+
+// maskObserver 脱敏后再交给下一个观察者
+type maskObserver struct {
+	filter *MaskFilter
+	next   Observer
+}
+
+// logObserver 通过logs包写审计日志
+type logObserver struct{}
+
+/**
+ * AddObserver 添加接口调用观察者 需在初始化时调用 不支持与请求并发
+ * 观察者收到的是原始xml 需要脱敏时使用NewMaskObserver包装
+ * @params observer
+ */
+func (wechat *wechatPay) AddObserver(observer Observer) {
+	wechat.observers = append(wechat.observers, observer)
+}
+
+// notifyObservers 通知所有观察者
+func (wechat *wechatPay) notifyObservers(record *AuditRecord) {
+	for _, observer := range wechat.observers {
+		observer.Observe(record)
+	}
+}
+
+// parseResult 从返回xml中解析通信标识和业务结果
+func (record *AuditRecord) parseResult() {
+	if record.Response == "" {
+		return
+	}
+	result := struct {
+		ReturnCode string `xml:"return_code"`
+		ResultCode string `xml:"result_code"`
+		ErrCode    string `xml:"err_code"`
+	}{}
+	if xml.Unmarshal([]byte(record.Response), &result) == nil {
+		record.ReturnCode = result.ReturnCode
+		record.ResultCode = result.ResultCode
+		record.ErrCode = result.ErrCode
+	}
+}
+
+// NewMaskFilter 构造脱敏过滤器 fields为空时使用DEFAULT_MASK_FIELDS
+func NewMaskFilter(fields ...string) *MaskFilter {
+	if len(fields) == 0 {
+		fields = DEFAULT_MASK_FIELDS
+	}
+	filter := &MaskFilter{}
+	for _, field := range fields {
+		field = regexp.QuoteMeta(field)
+		filter.patterns = append(filter.patterns,
+			regexp.MustCompile(`(<`+field+`>)(?:<!\[CDATA\[)?(.*?)(?:\]\]>)?(</`+field+`>)`))
+	}
+	return filter
+}
+
+// Mask 对xml中的敏感字段脱敏 保留首尾各3位便于排查 短值全部替换
+func (filter *MaskFilter) Mask(data string) string {
+	for _, pattern := range filter.patterns {
+		data = pattern.ReplaceAllStringFunc(data, func(match string) string {
+			parts := pattern.FindStringSubmatch(match)
+			return parts[1] + maskValue(parts[2]) + parts[3]
+		})
+	}
+	return data
+}
+
+// maskValue 脱敏单个值
+func maskValue(value string) string {
+	runes := []rune(value)
+	if len(runes) == 0 {
+		return value
+	}
+	if len(runes) <= 8 {
+		return "***"
+	}
+	return string(runes[:3]) + "***" + string(runes[len(runes)-3:])
+}
+
+/**
+ * NewMaskObserver 脱敏后再交给next
+ * @params next 下一个观察者
+ * @params filter 脱敏过滤器 为nil时使用默认字段
+ */
+func NewMaskObserver(next Observer, filter *MaskFilter) Observer {
+	if filter == nil {
+		filter = NewMaskFilter()
+	}
+	return &maskObserver{
+		filter: filter,
+		next:   next,
+	}
+}
+
+// Observe 复制一份记录脱敏 不影响其他观察者
+func (m *maskObserver) Observe(record *AuditRecord) {
+	masked := *record
+	masked.Request = m.filter.Mask(record.Request)
+	masked.Response = m.filter.Mask(record.Response)
+	m.next.Observe(&masked)
+}
+
+// NewLogObserver 默认审计实现 使用默认字段脱敏后通过logs.InnerLog写日志
+func NewLogObserver() Observer {
+	return NewMaskObserver(logObserver{}, nil)
+}
+
+// Observe 写审计日志 logs.InnerLog创建日志文件失败时会panic 审计不能影响支付请求 这里recover后通过log输出
+func (logObserver) Observe(record *AuditRecord) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("写微信支付审计日志异常: %v", r)
+		}
+	}()
+	errMsg := ""
+	if record.Err != nil {
+		errMsg = record.Err.Error()
+	}
+	logs.InnerLog("wechat_pay_audit", map[string]interface{}{
+		"key":         "MJ_MD_WECHAT_PAY_LOG",
+		"uri":         record.Uri,
+		"mch_id":      record.MchId,
+		"request":     record.Request,
+		"response":    record.Response,
+		"http_code":   record.HttpCode,
+		"t":           record.StartTime.Format("2006-01-02T15:04:05+08:00"),
+		"latency_ms":  record.Latency.Nanoseconds() / int64(time.Millisecond),
+		"error":       errMsg,
+		"return_code": record.ReturnCode,
+		"result_code": record.ResultCode,
+		"err_code":    record.ErrCode,
+	})
+}
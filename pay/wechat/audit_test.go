@@ -0,0 +1,31 @@
+package wechat
+
+import (
+	"testing"
+)
+
+func TestMaskFilter(t *testing.T) {
+	filter := NewMaskFilter()
+	data := "<xml><appid>wx1234567890</appid><openid><![CDATA[oUpF8uMuAJO_M2pxb1Q9zNjWeS6o]]></openid>" +
+		"<re_user_name>张三</re_user_name><sign>C380BEC2BFD727A4B6845133519F3AD6</sign><amount>100</amount></xml>"
+	want := "<xml><appid>wx1234567890</appid><openid>oUp***S6o</openid>" +
+		"<re_user_name>***</re_user_name><sign>C38***AD6</sign><amount>100</amount></xml>"
+	if got := filter.Mask(data); got != want {
+		t.Errorf("Mask() = %s, want %s", got, want)
+	}
+}
+
+func TestMaskObserver(t *testing.T) {
+	var masked *AuditRecord
+	observer := NewMaskObserver(ObserverFunc(func(record *AuditRecord) {
+		masked = record
+	}), NewMaskFilter("openid"))
+	record := &AuditRecord{Request: "<xml><openid>oUpF8uMuAJO_M2pxb1Q9zNjWeS6o</openid></xml>"}
+	observer.Observe(record)
+	if masked.Request != "<xml><openid>oUp***S6o</openid></xml>" {
+		t.Errorf("脱敏失败: %s", masked.Request)
+	}
+	if record.Request == masked.Request {
+		t.Error("原始记录不应被修改")
+	}
+}
@@ -1,9 +1,11 @@
 package wechat
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
@@ -15,15 +17,31 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+const DEFAULT_TIMEOUT = 30 * time.Second // 请求微信接口的默认超时时间
+
 const (
-	UNIFIED_ORDER     = "https://api.mch.weixin.qq.com/pay/unifiedorder"                      // 统一下单接口地址
-	ORDER_QUERY       = "https://api.mch.weixin.qq.com/pay/orderquery"                        // 查询接口地址
-	REFUND            = "https://api.mch.weixin.qq.com/secapi/pay/refund"                     // 退款接口地址
-	REFEUN_QUERY      = "https://api.mch.weixin.qq.com/pay/refundquery"                       // 退款查询接口地址
-	COMPANY_PAY       = "https://api.mch.weixin.qq.com/mmpaymkttransfers/promotion/transfers" // 企业支付下单
-	COMPANY_PAY_QUERY = "https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo"     // 企业支付查询
+	SIGN_TYPE_MD5         = "MD5"
+	SIGN_TYPE_HMAC_SHA256 = "HMAC-SHA256"
+)
+
+const (
+	UNIFIED_ORDER      = "https://api.mch.weixin.qq.com/pay/unifiedorder"                      // 统一下单接口地址
+	ORDER_QUERY        = "https://api.mch.weixin.qq.com/pay/orderquery"                        // 查询接口地址
+	CLOSE_ORDER        = "https://api.mch.weixin.qq.com/pay/closeorder"                        // 关闭订单接口地址
+	REFUND             = "https://api.mch.weixin.qq.com/secapi/pay/refund"                     // 退款接口地址
+	REFEUN_QUERY       = "https://api.mch.weixin.qq.com/pay/refundquery"                       // 退款查询接口地址
+	COMPANY_PAY        = "https://api.mch.weixin.qq.com/mmpaymkttransfers/promotion/transfers" // 企业支付下单
+	COMPANY_PAY_QUERY  = "https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo"     // 企业支付查询
+	SEND_REDPACK       = "https://api.mch.weixin.qq.com/mmpaymkttransfers/sendredpack"         // 发放普通红包
+	SEND_GROUP_REDPACK = "https://api.mch.weixin.qq.com/mmpaymkttransfers/sendgroupredpack"    // 发放裂变红包
+	REDPACK_QUERY      = "https://api.mch.weixin.qq.com/mmpaymkttransfers/gethbinfo"           // 红包查询
+	SEND_COUPON        = "https://api.mch.weixin.qq.com/mmpaymkttransfers/send_coupon"         // 发放代金券
+	COUPON_STOCK_QUERY = "https://api.mch.weixin.qq.com/mmpaymkttransfers/query_coupon_stock"  // 查询代金券批次
+	COUPON_QUERY       = "https://api.mch.weixin.qq.com/mmpaymkttransfers/querycouponsinfo"    // 查询代金券信息
 )
 
 const (
@@ -32,6 +50,21 @@ const (
 	REFUND_PROCESS = 11
 	REFUND_SUCCESS = 12
 	REFUND_FAIL    = 13
+
+	TRANSFER_PROCESS = 21
+	TRANSFER_SUCCESS = 22
+	TRANSFER_FAIL    = 23
+
+	REDPACK_SENDING   = 31
+	REDPACK_SENT      = 32
+	REDPACK_FAIL      = 33
+	REDPACK_RECEIVED  = 34
+	REDPACK_REFUNDING = 35
+	REDPACK_REFUND    = 36
+
+	DEPOSIT_PROCESS = 41
+	DEPOSIT_FROZEN  = 42
+	DEPOSIT_FAIL    = 43
 )
 
 //　WechatPay 微信支付基础结构体
@@ -41,6 +74,12 @@ type wechatPay struct {
 	appid         string
 	key           string
 	mchid         string
+
+	clientOnce sync.Once
+	client     *http.Client
+	clientErr  error
+	observers  []Observer
+	guard      *Guard
 }
 
 // RefundRequests 微信申请退款请求参数
@@ -89,7 +128,7 @@ type RefundRespones struct {
 }
 
 type RefundQueryRequests struct {
-	AppId         string `json:"app_id" xml:"app_id" structs:"app_id"`
+	AppId         string `json:"appid" xml:"appid" structs:"appid"`
 	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
 	NonceStr      string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
 	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
@@ -222,33 +261,121 @@ func NewWechatPay(appid, mchid, key, apiclientKey, apiclientCert string) *wechat
  * @return resp 标准http请求 err 标准错误输出
  */
 func (wechat *wechatPay) Request(uri string, requestData interface{}) (resp *http.Response, err error) {
-	//2.签名和请求参数
+	return wechat.RequestContext(context.Background(), uri, requestData)
+}
+
+/**
+ * RequestContext 微信标准请求输出 ctx取消或超时后立即中断请求
+ * @params ctx 请求上下文
+ * @params uri 请求uri
+ * @params requestData 请求参数为固定结构体
+ *
+ * @return resp 标准http请求 err 标准错误输出
+ */
+func (wechat *wechatPay) RequestContext(ctx context.Context, uri string, requestData interface{}) (resp *http.Response, err error) {
+	//1.签名和请求参数
 	respXml, err := wechat.dealXmlRequest(requestData)
 	if err != nil {
 		return
 	}
-	//3. 证书和公钥文件
-	cert := wechat.apiclientCert  //证书
-	sslKey := wechat.apiclientKey //公钥
-	//4. 带证书发送http请求
-	pool := x509.NewCertPool()
-	pool.AppendCertsFromPEM([]byte(cert))
-	//5.tls.X509KeyPair 直接读字符串
-	cliCrt, err := tls.X509KeyPair([]byte(cert), []byte(sslKey))
+	return wechat.post(ctx, uri, respXml)
+}
+
+// post 使用带证书的http客户端发送xml
+func (wechat *wechatPay) post(ctx context.Context, uri string, xmlBody string) (resp *http.Response, err error) {
+	client, err := wechat.httpClient()
 	if err != nil {
 		return nil, err
 	}
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			//RootCAs:      pool,	不能添加这一项
-			Certificates: []tls.Certificate{cliCrt},
-			ClientAuth:   tls.RequireAndVerifyClientCert,
-		},
+	request, err := http.NewRequest("POST", uri, strings.NewReader(xmlBody))
+	if err != nil {
+		return nil, err
 	}
-	client := &http.Client{Transport: tr}
+	request.Header.Set("Content-Type", "text/xml; charset=UTF8")
 	//请求微信接口
-	resp, err = client.Post(uri, "text/xml; charset=UTF8", strings.NewReader(respXml))
-	return
+	return client.Do(request.WithContext(ctx))
+}
+
+// httpClient 构造带证书的http客户端 证书只解析一次 连接可复用
+func (wechat *wechatPay) httpClient() (*http.Client, error) {
+	wechat.clientOnce.Do(func() {
+		//1. 证书和公钥文件
+		cert := wechat.apiclientCert  //证书
+		sslKey := wechat.apiclientKey //公钥
+		//2.tls.X509KeyPair 直接读字符串
+		cliCrt, err := tls.X509KeyPair([]byte(cert), []byte(sslKey))
+		if err != nil {
+			wechat.clientErr = err
+			return
+		}
+		tr := &http.Transport{
+			TLSClientConfig: &tls.Config{
+				//RootCAs:      pool,	不能添加这一项
+				Certificates: []tls.Certificate{cliCrt},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			},
+		}
+		// ctx没有设置deadline时的兜底超时
+		wechat.client = &http.Client{Transport: tr, Timeout: DEFAULT_TIMEOUT}
+	})
+	return wechat.client, wechat.clientErr
+}
+
+/**
+ * requestXml 发送带证书的请求并将返回的xml解码到response
+ * @params ctx 请求上下文
+ * @params uri 请求uri
+ * @params requestData 请求参数为固定结构体
+ * @params response 返回结构体指针
+ */
+func (wechat *wechatPay) requestXml(ctx context.Context, uri string, requestData interface{}, response interface{}) (err error) {
+	requestXml, err := wechat.dealXmlRequest(requestData)
+	if err != nil {
+		return err
+	}
+	endpoint := endpointName(uri)
+	if wechat.guard != nil {
+		// 限流或熔断时直接返回 不发出请求
+		err = wechat.guard.Allow(ctx, wechat.mchid, endpoint)
+		if err != nil {
+			return err
+		}
+	}
+	record := &AuditRecord{
+		Uri:       uri,
+		MchId:     wechat.mchid,
+		Request:   requestXml,
+		StartTime: time.Now(),
+	}
+	defer func() {
+		record.Latency = time.Since(record.StartTime)
+		record.Err = err
+		record.parseResult()
+		if wechat.guard != nil {
+			wechat.guard.Report(wechat.mchid, endpoint, record)
+		}
+		wechat.notifyObservers(record)
+	}()
+	resp, err := wechat.post(ctx, uri, requestXml)
+	if err != nil {
+		// ctx取消或超时时返回ctx的错误 调用方可据此区分 也不计入熔断失败
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("请求异常:" + err.Error())
+	}
+	defer resp.Body.Close()
+	record.HttpCode = resp.StatusCode
+	if resp.StatusCode != 200 {
+		return errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
+	}
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	record.Response = string(respData)
+	//xml解码
+	return xml.Unmarshal(respData, response)
 }
 
 func (wechat *wechatPay) NewRefundRequests(outRefundNo, transactionId, businessId, notifyUrl string, totalFee, refundFee int) (request RefundRequests) {
@@ -269,6 +396,17 @@ func (wechat *wechatPay) NewRefundRequests(outRefundNo, transactionId, businessI
 	}
 }
 
+// NewRefundQueryRequests 按商户退款单号构造退款查询请求
+func (wechat *wechatPay) NewRefundQueryRequests(outRefundNo string) (request RefundQueryRequests) {
+	return RefundQueryRequests{
+		AppId:       wechat.appid,
+		MchId:       wechat.mchid,
+		NonceStr:    utils.GetNonceStr(),
+		SignType:    "MD5",
+		OutRefundNo: outRefundNo,
+	}
+}
+
 /**
  * Refund 小程序申请退款
  *
@@ -276,21 +414,14 @@ func (wechat *wechatPay) NewRefundRequests(outRefundNo, transactionId, businessI
  * @return AppletPayRefundRespones err
  */
 func (wechatPay *wechatPay) Refund(request RefundRequests) (queryResponse *RefundRespones, err error) {
+	return wechatPay.RefundContext(context.Background(), request)
+}
+
+// RefundContext 申请退款 支持ctx取消和超时
+func (wechatPay *wechatPay) RefundContext(ctx context.Context, request RefundRequests) (queryResponse *RefundRespones, err error) {
 	queryResponse = new(RefundRespones)
 	// 向微信发送请求
-	resp, err := wechatPay.Request(REFUND, request)
-	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	//xml解码
-	err = xml.Unmarshal(respData, queryResponse)
+	err = wechatPay.requestXml(ctx, REFUND, request, queryResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -304,20 +435,14 @@ func (wechatPay *wechatPay) Refund(request RefundRequests) (queryResponse *Refun
  * @return AppletPayRefundQueryRespones err
  */
 func (wechatPay *wechatPay) RefundQuery(request RefundQueryRequests) (queryResponse *RefundQueryRespones, err error) {
+	return wechatPay.RefundQueryContext(context.Background(), request)
+}
+
+// RefundQueryContext 退款查询 支持ctx取消和超时
+func (wechatPay *wechatPay) RefundQueryContext(ctx context.Context, request RefundQueryRequests) (queryResponse *RefundQueryRespones, err error) {
+	queryResponse = new(RefundQueryRespones)
 	// 向微信发送请求
-	resp, err := wechatPay.Request(REFEUN_QUERY, request)
-	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	//xml解码
-	err = xml.Unmarshal(respData, queryResponse)
+	err = wechatPay.requestXml(ctx, REFEUN_QUERY, request, queryResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -345,8 +470,11 @@ func (wechat *wechatPay) signData(data map[string]interface{}) (sign string, err
 	str := utils.ToUrlParams(data, strs)
 	//1.3 在str后加入KEY
 	str = str + "&key=" + wechat.key
-	//2. 将得到的数据做MD5结算得到signValue
+	//2. 将得到的数据做MD5结算得到signValue 押金等接口要求使用HMAC-SHA256
 	m := md5.New()
+	if signType, _ := data["sign_type"].(string); signType == SIGN_TYPE_HMAC_SHA256 {
+		m = hmac.New(sha256.New, []byte(wechat.key))
+	}
 	_, err = io.WriteString(m, str)
 	if err != nil {
 		return "", errors.New("签名错误:" + err.Error())
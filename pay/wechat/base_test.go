@@ -0,0 +1,43 @@
+package wechat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	wechat := newFakeWechatPay(serverTransport{server: server})
+	request := wechat.NewOrderQueryRequest("", "T1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := wechat.RequestContext(ctx, ORDER_QUERY, request); err == nil {
+		t.Fatal("超时后应返回err")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("超时后未及时中断请求: %s", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := wechat.QueryOrderContext(ctx, request); err == nil {
+		t.Fatal("取消后应返回err")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("取消后未及时中断请求: %s", elapsed)
+	}
+}
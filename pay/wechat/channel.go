@@ -0,0 +1,233 @@
+package wechat
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"github.com/mjd-pub/common_golang/pay"
+	"github.com/mjd-pub/common_golang/utils"
+	"net/http"
+)
+
+const (
+	CHANNEL_APPLET = "wechat_applet" // 小程序/公众号JSAPI支付
+	CHANNEL_H5     = "wechat_h5"     // 微信外h5支付
+)
+
+// channel 基于AppletPay和H5Pay实现pay.Channel
+type channel struct {
+	name      string
+	wechatPay *wechatPay
+}
+
+var _ pay.ContextChannel = (*channel)(nil)
+
+func init() {
+	pay.Register(CHANNEL_APPLET, newChannel(CHANNEL_APPLET))
+	pay.Register(CHANNEL_H5, newChannel(CHANNEL_H5))
+}
+
+func newChannel(name string) pay.Factory {
+	return func(config pay.Config) (pay.Channel, error) {
+		if config.Appid == "" || config.MchId == "" || config.Key == "" {
+			return nil, errors.New("微信支付配置不完整:appid mch_id key不能为空")
+		}
+		return &channel{
+			name:      name,
+			wechatPay: NewWechatPay(config.Appid, config.MchId, config.Key, config.ApiclientKey, config.ApiclientCert),
+		}, nil
+	}
+}
+
+// Name 渠道名称
+func (c *channel) Name() string {
+	return c.name
+}
+
+// CreateOrder 统一下单 小程序返回调起支付参数 h5返回mweb_url
+func (c *channel) CreateOrder(order pay.Order) (*pay.PrepayResult, error) {
+	return c.CreateOrderContext(context.Background(), order)
+}
+
+// CreateOrderContext 统一下单 支持ctx取消和超时
+func (c *channel) CreateOrderContext(ctx context.Context, order pay.Order) (*pay.PrepayResult, error) {
+	result := &pay.PrepayResult{
+		Channel:    c.name,
+		OutTradeNo: order.OutTradeNo,
+	}
+	if c.name == CHANNEL_H5 {
+		h5Pay := &H5Pay{wechatPay: c.wechatPay}
+		request := h5Pay.NewH5PayRequest(order.Subject, order.Body, order.OutTradeNo, order.ClientIp, order.NotifyUrl, order.Openid, 0)
+		request.TotalFee = order.Amount
+		resp, err := h5Pay.PayContext(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		err = resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+		if err != nil {
+			return nil, err
+		}
+		result.PrepayId = resp.PrepayId
+		result.PayUrl = resp.MwebUrl
+		return result, nil
+	}
+	appletPay := &AppletPay{wechatPay: c.wechatPay}
+	request := appletPay.NewAppletPayRequest(order.Subject, order.Body, order.OutTradeNo, order.ClientIp, order.NotifyUrl, order.Openid, 0)
+	request.TotalFee = order.Amount
+	resp, frontRequest, err := appletPay.PayContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	err = resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+	if err != nil {
+		return nil, err
+	}
+	result.PrepayId = resp.PrepayId
+	result.Params = map[string]string{
+		"appId":     frontRequest.Appid,
+		"timeStamp": frontRequest.TimeStamp,
+		"nonceStr":  frontRequest.NonceStr,
+		"package":   frontRequest.Package,
+		"signType":  frontRequest.SignType,
+		"paySign":   frontRequest.PaySign,
+	}
+	return result, nil
+}
+
+// Query 订单查询
+func (c *channel) Query(outTradeNo string) (*pay.QueryResult, error) {
+	return c.QueryContext(context.Background(), outTradeNo)
+}
+
+// QueryContext 订单查询 支持ctx取消和超时
+func (c *channel) QueryContext(ctx context.Context, outTradeNo string) (*pay.QueryResult, error) {
+	resp, err := c.wechatPay.QueryOrderContext(ctx, c.wechatPay.NewOrderQueryRequest("", outTradeNo))
+	if err != nil {
+		return nil, err
+	}
+	err = resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+	if err != nil {
+		return nil, err
+	}
+	result := &pay.QueryResult{
+		Status:     tradeStatus(resp.TradeState),
+		OutTradeNo: resp.OutTradeNo,
+		TradeNo:    resp.TransactionId,
+		Amount:     resp.TotalFee.Fen(),
+		StatusDesc: string(resp.TradeState),
+	}
+	if !resp.TimeEnd.IsZero() {
+		result.PaidAt = resp.TimeEnd.Format("2006-01-02 15:04:05")
+	}
+	return result, nil
+}
+
+// Close 关闭订单
+func (c *channel) Close(outTradeNo string) error {
+	return c.CloseContext(context.Background(), outTradeNo)
+}
+
+// CloseContext 关闭订单 支持ctx取消和超时
+func (c *channel) CloseContext(ctx context.Context, outTradeNo string) error {
+	appletPay := &AppletPay{wechatPay: c.wechatPay}
+	resp, err := appletPay.CloseContext(ctx, AppletPayCloseRequests{
+		Appid:      c.wechatPay.appid,
+		MchId:      c.wechatPay.mchid,
+		OutTradeNo: outTradeNo,
+		NonceStr:   utils.GetNonceStr(),
+		SignType:   "MD5",
+	})
+	if err != nil {
+		return err
+	}
+	return resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+}
+
+// Refund 申请退款 微信退款为异步处理 成功受理后状态为REFUND_PROCESS
+func (c *channel) Refund(request pay.RefundRequest) (*pay.RefundResult, error) {
+	return c.RefundContext(context.Background(), request)
+}
+
+// RefundContext 申请退款 支持ctx取消和超时
+func (c *channel) RefundContext(ctx context.Context, request pay.RefundRequest) (*pay.RefundResult, error) {
+	refundRequest := c.wechatPay.NewRefundRequests(request.OutRefundNo, request.TradeNo, request.OutTradeNo, request.NotifyUrl, request.TotalAmount, request.RefundAmount)
+	refundRequest.RefundDesc = request.Reason
+	resp, err := c.wechatPay.RefundContext(ctx, refundRequest)
+	if err != nil {
+		return nil, err
+	}
+	err = resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+	if err != nil {
+		return nil, err
+	}
+	return &pay.RefundResult{
+		Status:       pay.REFUND_PROCESS,
+		OutRefundNo:  resp.OutRefundNo,
+		RefundId:     resp.RefundId,
+		RefundAmount: resp.RefundFee,
+	}, nil
+}
+
+// ParseNotify 解析支付结果通知
+func (c *channel) ParseNotify(request *http.Request) (*pay.Notification, error) {
+	notifyReq, err := c.wechatPay.ParsePayNotifyRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	status := pay.TRADE_FAIL
+	if notifyReq.ReturnCode == "SUCCESS" && notifyReq.ResultCode == "SUCCESS" {
+		status = pay.TRADE_SUCCESS
+	}
+	return &pay.Notification{
+		Status:     status,
+		OutTradeNo: notifyReq.OutTradeNo,
+		TradeNo:    notifyReq.TransactionId,
+		Amount:     notifyReq.TotalFee,
+		Buyer:      notifyReq.Openid,
+		PaidAt:     notifyReq.TimeEnd,
+		Raw:        notifyReq,
+	}, nil
+}
+
+// ReplyNotify 回复微信通知
+func (c *channel) ReplyNotify(w http.ResponseWriter, success bool) {
+	reply := ServiceNotifyResponse{
+		ReturnCode: "SUCCESS",
+		ReturnMsg:  "OK",
+	}
+	if !success {
+		reply.ReturnCode = "FAIL"
+		reply.ReturnMsg = "FAIL"
+	}
+	data, _ := xml.Marshal(reply)
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write(data)
+}
+
+// resultError 将微信返回的通信和业务错误转换为error
+func resultError(returnCode, returnMsg, resultCode, errCode, errCodeDes string) error {
+	if returnCode != "SUCCESS" {
+		return errors.New("通信失败:" + returnMsg)
+	}
+	if resultCode != "SUCCESS" {
+		return errors.New("业务失败:" + errCode + " " + errCodeDes)
+	}
+	return nil
+}
+
+// tradeStatus 微信trade_state转换为pay交易状态
+func tradeStatus(tradeState TradeState) int {
+	switch tradeState {
+	case TRADE_STATE_SUCCESS:
+		return pay.TRADE_SUCCESS
+	case TRADE_STATE_NOTPAY, TRADE_STATE_USERPAYING:
+		return pay.TRADE_WAITING
+	case TRADE_STATE_CLOSED, TRADE_STATE_REVOKED:
+		return pay.TRADE_CLOSED
+	case TRADE_STATE_REFUND:
+		return pay.TRADE_REFUND
+	case TRADE_STATE_PAYERROR:
+		return pay.TRADE_FAIL
+	}
+	return pay.TRADE_UNKNOWN
+}
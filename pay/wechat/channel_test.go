@@ -0,0 +1,25 @@
+package wechat
+
+import (
+	"github.com/mjd-pub/common_golang/pay"
+	"testing"
+)
+
+func TestChannelQuery(t *testing.T) {
+	transport := &fakeTransport{handle: func(path, body string) string {
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><trade_state>SUCCESS</trade_state>` +
+			`<out_trade_no>T1</out_trade_no><transaction_id>4200000001</transaction_id><total_fee>101</total_fee>` +
+			`<time_end>20141030133525</time_end></xml>`
+	}}
+	c := &channel{name: CHANNEL_APPLET, wechatPay: newFakeWechatPay(transport)}
+	result, err := c.Query("T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != pay.TRADE_SUCCESS || result.Amount != 101 || result.TradeNo != "4200000001" {
+		t.Errorf("查询结果异常: %+v", result)
+	}
+	if result.PaidAt != "2014-10-30 13:35:25" {
+		t.Errorf("支付时间异常: %s", result.PaidAt)
+	}
+}
@@ -1,11 +1,13 @@
 package wechat
 
 import (
-	"encoding/xml"
-	"errors"
+	"context"
 	"github.com/mjd-pub/common_golang/utils"
-	"io/ioutil"
-	"strconv"
+)
+
+const (
+	CHECK_NAME_NO    = "NO_CHECK"    // 不校验真实姓名
+	CHECK_NAME_FORCE = "FORCE_CHECK" // 强校验真实姓名
 )
 
 // CompanyPay 企业支付
@@ -21,8 +23,9 @@ type CompanyPayRequest struct {
 	PartnerTradeNo string `json:"partner_trade_no" xml:"partner_trade_no" structs:"partner_trade_no"`
 	Openid         string `json:"openid" xml:"openid" structs:"openid"`
 	CheckName      string `json:"check_name" xml:"check_name" structs:"check_name"`
+	ReUserName     string `json:"re_user_name" xml:"re_user_name" structs:"re_user_name"`
 	Amount         int    `json:"amount" xml:"amount" structs:"amount"`
-	Desc           string `json:"desc" xml:"amount" structs:"desc"`
+	Desc           string `json:"desc" xml:"desc" structs:"desc"`
 }
 
 // CompanyPayRequest 企业支付查询请求
@@ -80,6 +83,16 @@ func NewCompanyPayClient(appid, mchid, key, apiclientKey, apiclientCert string)
 	return
 }
 
+// AddObserver 添加接口调用观察者 需在初始化时调用
+func (companyPay *CompanyPay) AddObserver(observer Observer) {
+	companyPay.wechatPay.AddObserver(observer)
+}
+
+// SetGuard 开启限流和熔断 需在初始化时调用
+func (companyPay *CompanyPay) SetGuard(guard *Guard) {
+	companyPay.wechatPay.SetGuard(guard)
+}
+
 /**
  * NewCompanyPayRequest 构造下单请求
  * @params companyPay
@@ -94,12 +107,27 @@ func (companyPay *CompanyPay) NewCompanyPayRequest(businessId string, amount int
 		NonceStr:       utils.GetNonceStr(),
 		PartnerTradeNo: businessId,
 		Openid:         openid,
-		CheckName:      "NO_CHECK", // 不校验姓名
+		CheckName:      CHECK_NAME_NO, // 不校验姓名
 		Amount:         amount,
 		Desc:           desc,
 	}
 }
 
+/**
+ * NewCompanyPayForceCheckRequest 构造强校验姓名的下单请求
+ * @params companyPay
+ * @params businessId 业务订单号
+ * @params amount:int 金额(单位:分)
+ * @params reUserName 收款用户真实姓名
+ * @return CompanyPayRequest
+ */
+func (companyPay *CompanyPay) NewCompanyPayForceCheckRequest(businessId string, amount int, openid string, reUserName string, desc string) CompanyPayRequest {
+	request := companyPay.NewCompanyPayRequest(businessId, amount, openid, desc)
+	request.CheckName = CHECK_NAME_FORCE
+	request.ReUserName = reUserName
+	return request
+}
+
 /**
  * NewCompanyPayQueryRequest 构造查询请求
  * @params companyPay
@@ -117,21 +145,14 @@ func (companyPay *CompanyPay) NewCompanyPayQueryRequest(businessId string) Compa
 
 // Pay 发起支付
 func (c *CompanyPay) Pay(request CompanyPayRequest) (queryResponse *CompanyPayResponse, err error) {
-	// 向微信发送请求
-	resp, err := c.wechatPay.Request(COMPANY_PAY, request)
-	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	return c.PayContext(context.Background(), request)
+}
+
+// PayContext 发起支付 支持ctx取消和超时
+func (c *CompanyPay) PayContext(ctx context.Context, request CompanyPayRequest) (queryResponse *CompanyPayResponse, err error) {
 	queryResponse = new(CompanyPayResponse)
-	//xml解码
-	err = xml.Unmarshal(respData, queryResponse)
+	// 向微信发送请求
+	err = c.wechatPay.requestXml(ctx, COMPANY_PAY, request, queryResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -140,20 +161,14 @@ func (c *CompanyPay) Pay(request CompanyPayRequest) (queryResponse *CompanyPayRe
 
 // Query 企业支付查询
 func (c *CompanyPay) Query(request CompanyPayQueryRequest) (queryResponse *CompanyPayQueryResponse, err error) {
+	return c.QueryContext(context.Background(), request)
+}
+
+// QueryContext 企业支付查询 支持ctx取消和超时
+func (c *CompanyPay) QueryContext(ctx context.Context, request CompanyPayQueryRequest) (queryResponse *CompanyPayQueryResponse, err error) {
+	queryResponse = new(CompanyPayQueryResponse)
 	// 向微信发送请求
-	resp, err := c.wechatPay.Request(COMPANY_PAY_QUERY, request)
-	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	//xml解码
-	err = xml.Unmarshal(respData, queryResponse)
+	err = c.wechatPay.requestXml(ctx, COMPANY_PAY_QUERY, request, queryResponse)
 	if err != nil {
 		return nil, err
 	}
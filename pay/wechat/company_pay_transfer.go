@@ -0,0 +1,311 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TRANSFER_MIN_AMOUNT   = 30 // 微信企业付款单笔最低金额(分)
+	TRANSFER_SYSTEM_ERROR = "SYSTEMERROR"
+)
+
+// TransferLimit 企业付款限额配置, 金额单位为分, 0代表不限制
+type TransferLimit struct {
+	MinAmount       int // 单笔最低金额 小于微信最低金额时按微信最低金额处理
+	MaxAmount       int // 单笔最高金额
+	UserDailyAmount int // 单用户单日累计金额上限
+	UserDailyCount  int // 单用户单日付款次数上限
+}
+
+// TransferRecorder 记录用户每日已付款的金额和次数, 多实例部署时需业务方基于redis或数据库实现
+type TransferRecorder interface {
+	// Usage 获取用户某日已付款的金额和次数 day格式为20060102
+	Usage(openid string, day string) (amount int, count int, err error)
+	// Add 付款成功后累加用户当日的金额和次数
+	Add(openid string, day string, amount int) error
+}
+
+// TransferResult 企业付款最终结果
+type TransferResult struct {
+	Status         int    // TRANSFER_PROCESS TRANSFER_SUCCESS TRANSFER_FAIL
+	PartnerTradeNo string // 业务订单号
+	PaymentNo      string // 微信付款单号
+	PaymentTime    string // 付款成功时间
+	ErrCode        string // 失败时的错误码
+	ErrCodeDes     string // 失败时的错误描述
+	Attempts       int    // 实际发起付款的次数
+}
+
+// TransferExecutor 企业付款执行器 负责限额校验 原单重试 以及结果查询
+type TransferExecutor struct {
+	companyPay    *CompanyPay
+	limit         TransferLimit
+	recorder      TransferRecorder
+	maxRetry      int
+	retryInterval time.Duration
+
+	// 已通过限额校验但尚未记入recorder的付款 避免并发付款同时通过限额校验
+	mu          sync.Mutex
+	reservedDay string
+	reserved    map[string]transferUsage
+}
+
+// transferUsage 用户当日预占的金额和次数
+type transferUsage struct {
+	amount int
+	count  int
+}
+
+// memoryTransferRecorder 单机内存版付款记录 只保留最近一天的数据
+type memoryTransferRecorder struct {
+	mu     sync.Mutex
+	day    string
+	amount map[string]int
+	count  map[string]int
+}
+
+/**
+ * NewTransferExecutor 构造企业付款执行器
+ * @params companyPay 企业支付客户端
+ * @params limit 限额配置
+ * @params recorder 付款记录 传nil时使用单机内存记录
+ * @return TransferExecutor
+ */
+func NewTransferExecutor(companyPay *CompanyPay, limit TransferLimit, recorder TransferRecorder) *TransferExecutor {
+	if limit.MinAmount < TRANSFER_MIN_AMOUNT {
+		limit.MinAmount = TRANSFER_MIN_AMOUNT
+	}
+	if recorder == nil {
+		recorder = NewMemoryTransferRecorder()
+	}
+	return &TransferExecutor{
+		companyPay:    companyPay,
+		limit:         limit,
+		recorder:      recorder,
+		maxRetry:      3,
+		retryInterval: time.Second,
+		reserved:      make(map[string]transferUsage),
+	}
+}
+
+// SetRetry 设置SYSTEMERROR或网络异常时的原单重试次数和间隔
+func (t *TransferExecutor) SetRetry(maxRetry int, interval time.Duration) {
+	t.maxRetry = maxRetry
+	t.retryInterval = interval
+}
+
+/**
+ * Transfer 执行企业付款
+ * 遇到SYSTEMERROR或网络异常时使用完全相同的请求(相同的partner_trade_no和nonce_str)重试,
+ * 重试仍无法确定结果时通过CompanyPay.Query查询最终状态
+ * 结果为TRANSFER_PROCESS时微信可能已受理 只能使用相同的partner_trade_no再次调用 不能换单号重新付款
+ *
+ * @params request CompanyPayRequest
+ * @return TransferResult err 校验失败时result为nil 付款成功但记录付款失败时同时返回result和err
+ */
+func (t *TransferExecutor) Transfer(request CompanyPayRequest) (result *TransferResult, err error) {
+	return t.TransferContext(context.Background(), request)
+}
+
+// TransferContext 执行企业付款 ctx取消时停止重试并返回TRANSFER_PROCESS状态和ctx.Err()
+func (t *TransferExecutor) TransferContext(ctx context.Context, request CompanyPayRequest) (result *TransferResult, err error) {
+	err = t.check(request)
+	if err != nil {
+		return nil, err
+	}
+	day := time.Now().Format("20060102")
+	// 先在锁内预占限额 再发起付款
+	err = t.reserve(request, day)
+	if err != nil {
+		return nil, err
+	}
+	result = &TransferResult{
+		Status:         TRANSFER_PROCESS,
+		PartnerTradeNo: request.PartnerTradeNo,
+	}
+	err = t.transfer(ctx, request, result)
+	settleErr := t.settle(request, day, result)
+	if err == nil {
+		err = settleErr
+	}
+	return result, err
+}
+
+// transfer 发起付款并原单重试 仍无明确结果时查询
+func (t *TransferExecutor) transfer(ctx context.Context, request CompanyPayRequest, result *TransferResult) error {
+	for i := 0; i <= t.maxRetry; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(t.retryInterval):
+			}
+		}
+		result.Attempts++
+		resp, payErr := t.companyPay.PayContext(ctx, request)
+		if payErr != nil {
+			// 网络异常无法确定微信是否已受理 只能原单重试
+			result.ErrCodeDes = payErr.Error()
+			continue
+		}
+		if resp.ReturnCode == "SUCCESS" && resp.ResultCode == "SUCCESS" {
+			result.Status = TRANSFER_SUCCESS
+			result.PaymentNo = resp.PaymentNo
+			result.PaymentTime = resp.PaymentTime
+			result.ErrCode = ""
+			result.ErrCodeDes = ""
+			return nil
+		}
+		result.ErrCode = resp.ErrCode
+		result.ErrCodeDes = resp.ErrCodeDes
+		if resp.ReturnCode == "SUCCESS" && resp.ErrCode != TRANSFER_SYSTEM_ERROR {
+			// 明确的业务错误 如余额不足 姓名校验失败等 不再重试
+			result.Status = TRANSFER_FAIL
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// 重试后仍未得到明确结果 查询最终状态
+	t.query(ctx, request, result)
+	return nil
+}
+
+// check 校验付款参数和单笔限额
+func (t *TransferExecutor) check(request CompanyPayRequest) error {
+	if request.PartnerTradeNo == "" || request.Openid == "" {
+		return errors.New("partner_trade_no和openid不能为空")
+	}
+	if request.CheckName == CHECK_NAME_FORCE && request.ReUserName == "" {
+		return errors.New("FORCE_CHECK时re_user_name不能为空")
+	}
+	if request.Amount < t.limit.MinAmount {
+		return errors.New("付款金额低于最低限额:" + strconv.Itoa(t.limit.MinAmount))
+	}
+	if t.limit.MaxAmount > 0 && request.Amount > t.limit.MaxAmount {
+		return errors.New("付款金额超过单笔限额:" + strconv.Itoa(t.limit.MaxAmount))
+	}
+	return nil
+}
+
+// reserve 校验单日限额并预占 已记录的付款加上预占中的付款一起计算
+func (t *TransferExecutor) reserve(request CompanyPayRequest, day string) error {
+	if t.limit.UserDailyAmount == 0 && t.limit.UserDailyCount == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reservedDay != day {
+		t.reservedDay = day
+		t.reserved = make(map[string]transferUsage)
+	}
+	amount, count, err := t.recorder.Usage(request.Openid, day)
+	if err != nil {
+		return errors.New("获取付款记录异常:" + err.Error())
+	}
+	usage := t.reserved[request.Openid]
+	if t.limit.UserDailyAmount > 0 && amount+usage.amount+request.Amount > t.limit.UserDailyAmount {
+		return errors.New("超过用户单日付款金额限额:" + strconv.Itoa(t.limit.UserDailyAmount))
+	}
+	if t.limit.UserDailyCount > 0 && count+usage.count+1 > t.limit.UserDailyCount {
+		return errors.New("超过用户单日付款次数限额:" + strconv.Itoa(t.limit.UserDailyCount))
+	}
+	t.reserved[request.Openid] = transferUsage{amount: usage.amount + request.Amount, count: usage.count + 1}
+	return nil
+}
+
+// settle 根据结果处理预占 成功时记入recorder 失败时释放 处理中时保留预占避免重复付款超限
+func (t *TransferExecutor) settle(request CompanyPayRequest, day string, result *TransferResult) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch result.Status {
+	case TRANSFER_SUCCESS:
+		err := t.recorder.Add(request.Openid, day, request.Amount)
+		if err != nil {
+			// 记录失败时保留预占 本实例内仍计入限额
+			return errors.New("记录付款异常:" + err.Error())
+		}
+		t.release(request, day)
+	case TRANSFER_FAIL:
+		t.release(request, day)
+	}
+	return nil
+}
+
+// release 释放预占 需持有t.mu
+func (t *TransferExecutor) release(request CompanyPayRequest, day string) {
+	usage, ok := t.reserved[request.Openid]
+	if !ok || t.reservedDay != day {
+		return
+	}
+	usage.amount -= request.Amount
+	usage.count--
+	if usage.count <= 0 {
+		delete(t.reserved, request.Openid)
+		return
+	}
+	t.reserved[request.Openid] = usage
+}
+
+// query 通过企业付款查询接口确定最终状态 查询失败时保持TRANSFER_PROCESS由业务方稍后再查
+func (t *TransferExecutor) query(ctx context.Context, request CompanyPayRequest, result *TransferResult) {
+	queryResp, err := t.companyPay.QueryContext(ctx, t.companyPay.NewCompanyPayQueryRequest(request.PartnerTradeNo))
+	if err != nil {
+		result.ErrCodeDes = err.Error()
+		return
+	}
+	if queryResp.ReturnCode != "SUCCESS" || queryResp.ResultCode != "SUCCESS" {
+		// NOT_FOUND时原请求可能仍在受理中 保持TRANSFER_PROCESS 由业务方使用相同的partner_trade_no重试
+		result.ErrCode = queryResp.ErrCode
+		result.ErrCodeDes = queryResp.ErrCodeDes
+		return
+	}
+	switch queryResp.Status {
+	case "SUCCESS":
+		result.Status = TRANSFER_SUCCESS
+		result.PaymentNo = queryResp.DetailId
+		result.PaymentTime = queryResp.PaymentTime
+		result.ErrCode = ""
+		result.ErrCodeDes = ""
+	case "FAILED":
+		result.Status = TRANSFER_FAIL
+		result.ErrCodeDes = queryResp.Reason
+	default:
+		result.Status = TRANSFER_PROCESS
+	}
+}
+
+// NewMemoryTransferRecorder 构造单机内存版付款记录 仅适用于单实例部署
+func NewMemoryTransferRecorder() TransferRecorder {
+	return &memoryTransferRecorder{
+		amount: make(map[string]int),
+		count:  make(map[string]int),
+	}
+}
+
+func (m *memoryTransferRecorder) Usage(openid string, day string) (amount int, count int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if day != m.day {
+		return 0, 0, nil
+	}
+	return m.amount[openid], m.count[openid], nil
+}
+
+func (m *memoryTransferRecorder) Add(openid string, day string, amount int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if day != m.day {
+		m.day = day
+		m.amount = make(map[string]int)
+		m.count = make(map[string]int)
+	}
+	m.amount[openid] += amount
+	m.count[openid]++
+	return nil
+}
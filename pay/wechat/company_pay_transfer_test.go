@@ -0,0 +1,104 @@
+package wechat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	transferPath      = "/mmpaymkttransfers/promotion/transfers"
+	transferQueryPath = "/mmpaymkttransfers/gettransferinfo"
+)
+
+func transferSuccess(string, string) string {
+	return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><payment_no>P1</payment_no></xml>`
+}
+
+// failRecorder Add始终失败
+type failRecorder struct {
+	TransferRecorder
+}
+
+func (failRecorder) Add(openid string, day string, amount int) error {
+	return errors.New("redis unavailable")
+}
+
+func TestTransferDailyLimit(t *testing.T) {
+	release := make(chan struct{})
+	transport := &fakeTransport{handle: func(path, body string) string {
+		<-release
+		return transferSuccess(path, body)
+	}}
+	companyPay := &CompanyPay{wechatPay: newFakeWechatPay(transport)}
+	executor := NewTransferExecutor(companyPay, TransferLimit{UserDailyAmount: 150}, nil)
+
+	done := make(chan *TransferResult)
+	go func() {
+		result, _ := executor.Transfer(companyPay.NewCompanyPayRequest("T1", 100, "o1", "提现"))
+		done <- result
+	}()
+	// 第一笔付款未返回时已预占限额 第二笔必须被拒绝
+	time.Sleep(20 * time.Millisecond)
+	_, err := executor.Transfer(companyPay.NewCompanyPayRequest("T2", 100, "o1", "提现"))
+	if err == nil || !strings.Contains(err.Error(), "单日付款金额") {
+		t.Fatalf("并发付款应超过单日限额: %v", err)
+	}
+	close(release)
+	if result := <-done; result.Status != TRANSFER_SUCCESS {
+		t.Fatalf("第一笔付款应成功: %+v", result)
+	}
+	_, err = executor.Transfer(companyPay.NewCompanyPayRequest("T3", 100, "o1", "提现"))
+	if err == nil {
+		t.Fatal("记入recorder后仍应超过单日限额")
+	}
+	if result, err := executor.Transfer(companyPay.NewCompanyPayRequest("T4", 50, "o1", "提现")); err != nil || result.Status != TRANSFER_SUCCESS {
+		t.Fatalf("未超限的付款应成功: %+v %v", result, err)
+	}
+}
+
+func TestTransferQueryNotFound(t *testing.T) {
+	transport := &fakeTransport{handle: func(path, body string) string {
+		if path == transferQueryPath {
+			return `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>NOT_FOUND</err_code></xml>`
+		}
+		return `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>SYSTEMERROR</err_code></xml>`
+	}}
+	companyPay := &CompanyPay{wechatPay: newFakeWechatPay(transport)}
+	executor := NewTransferExecutor(companyPay, TransferLimit{UserDailyCount: 1}, nil)
+	executor.SetRetry(1, time.Millisecond)
+
+	result, err := executor.Transfer(companyPay.NewCompanyPayRequest("T1", 100, "o1", "提现"))
+	if err != nil || result.Status != TRANSFER_PROCESS || result.ErrCode != "NOT_FOUND" {
+		t.Fatalf("查询NOT_FOUND应保持处理中: %+v %v", result, err)
+	}
+	if result.Attempts != 2 || transport.count(transferQueryPath) != 1 {
+		t.Fatalf("应原单重试后查询: attempts=%d query=%d", result.Attempts, transport.count(transferQueryPath))
+	}
+	// 处理中的付款保留预占 同一用户不能换单号再次付款
+	if _, err = executor.Transfer(companyPay.NewCompanyPayRequest("T2", 100, "o1", "提现")); err == nil {
+		t.Fatal("处理中的付款应继续占用限额")
+	}
+}
+
+func TestTransferRecorder(t *testing.T) {
+	recorder := NewMemoryTransferRecorder()
+	recorder.Add("o1", "20200101", 100)
+	recorder.Add("o1", "20200101", 50)
+	if amount, count, _ := recorder.Usage("o1", "20200101"); amount != 150 || count != 2 {
+		t.Fatalf("累计错误: %d %d", amount, count)
+	}
+	recorder.Add("o1", "20200102", 30)
+	if amount, count, _ := recorder.Usage("o1", "20200101"); amount != 0 || count != 0 {
+		t.Fatalf("跨天后旧数据应清空: %d %d", amount, count)
+	}
+
+	// 付款成功但记录失败时返回err 结果仍为成功
+	companyPay := &CompanyPay{wechatPay: newFakeWechatPay(&fakeTransport{handle: transferSuccess})}
+	executor := NewTransferExecutor(companyPay, TransferLimit{UserDailyCount: 5}, failRecorder{NewMemoryTransferRecorder()})
+	result, err := executor.Transfer(companyPay.NewCompanyPayRequest("T1", 100, "o1", "提现"))
+	if err == nil || result == nil || result.Status != TRANSFER_SUCCESS {
+		t.Fatalf("记录失败应返回err: %+v %v", result, err)
+	}
+}
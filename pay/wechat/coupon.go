@@ -0,0 +1,266 @@
+package wechat
+
+import (
+	"context"
+	"github.com/mjd-pub/common_golang/utils"
+)
+
+const (
+	COUPON_STOCK_INACTIVE  = 1  // 批次未激活
+	COUPON_STOCK_REVIEWING = 2  // 批次审批中
+	COUPON_STOCK_ACTIVE    = 4  // 批次已激活
+	COUPON_STOCK_INVALID   = 8  // 批次已作废
+	COUPON_STOCK_STOPPED   = 16 // 批次中止发放
+)
+
+// Coupon 代金券
+type Coupon struct {
+	wechatPay *wechatPay
+}
+
+// SendCouponRequest 发放代金券请求
+type SendCouponRequest struct {
+	CouponStockId  string `json:"coupon_stock_id" xml:"coupon_stock_id" structs:"coupon_stock_id"`
+	OpenidCount    int    `json:"openid_count" xml:"openid_count" structs:"openid_count"`
+	PartnerTradeNo string `json:"partner_trade_no" xml:"partner_trade_no" structs:"partner_trade_no"`
+	Openid         string `json:"openid" xml:"openid" structs:"openid"`
+	Appid          string `json:"appid" xml:"appid" structs:"appid"`
+	MchId          string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	OpUserId       string `json:"op_user_id" xml:"op_user_id" structs:"op_user_id"`
+	DeviceInfo     string `json:"device_info" xml:"device_info" structs:"device_info"`
+	NonceStr       string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	Version        string `json:"version" xml:"version" structs:"version"`
+	Type           string `json:"type" xml:"type" structs:"type"`
+}
+
+// SendCouponResponse 发放代金券返回
+type SendCouponResponse struct {
+	ReturnCode    string `json:"return_code" xml:"return_code" structs:"return_code"`
+	ReturnMsg     string `json:"return_msg" xml:"return_msg" structs:"return_msg"`
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	DeviceInfo    string `json:"device_info" xml:"device_info" structs:"device_info"`
+	NonceStr      string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	Sign          string `json:"sign" xml:"sign" structs:"sign"`
+	ResultCode    string `json:"result_code" xml:"result_code" structs:"result_code"`
+	ErrCode       string `json:"err_code" xml:"err_code" structs:"err_code"`
+	ErrCodeDes    string `json:"err_code_des" xml:"err_code_des" structs:"err_code_des"`
+	CouponStockId string `json:"coupon_stock_id" xml:"coupon_stock_id" structs:"coupon_stock_id"`
+	RespCount     int    `json:"resp_count" xml:"resp_count" structs:"resp_count"`
+	SuccessCount  int    `json:"success_count" xml:"success_count" structs:"success_count"`
+	FailedCount   int    `json:"failed_count" xml:"failed_count" structs:"failed_count"`
+	Openid        string `json:"openid" xml:"openid" structs:"openid"`
+	RetCode       string `json:"ret_code" xml:"ret_code" structs:"ret_code"`
+	CouponId      string `json:"coupon_id" xml:"coupon_id" structs:"coupon_id"`
+	RetMsg        string `json:"ret_msg" xml:"ret_msg" structs:"ret_msg"`
+}
+
+// CouponStockQueryRequest 查询代金券批次请求
+type CouponStockQueryRequest struct {
+	CouponStockId string `json:"coupon_stock_id" xml:"coupon_stock_id" structs:"coupon_stock_id"`
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	OpUserId      string `json:"op_user_id" xml:"op_user_id" structs:"op_user_id"`
+	DeviceInfo    string `json:"device_info" xml:"device_info" structs:"device_info"`
+	NonceStr      string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	Version       string `json:"version" xml:"version" structs:"version"`
+	Type          string `json:"type" xml:"type" structs:"type"`
+}
+
+// CouponStockQueryResponse 查询代金券批次返回
+type CouponStockQueryResponse struct {
+	ReturnCode        string `json:"return_code" xml:"return_code" structs:"return_code"`
+	ReturnMsg         string `json:"return_msg" xml:"return_msg" structs:"return_msg"`
+	Appid             string `json:"appid" xml:"appid" structs:"appid"`
+	MchId             string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	DeviceInfo        string `json:"device_info" xml:"device_info" structs:"device_info"`
+	NonceStr          string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	Sign              string `json:"sign" xml:"sign" structs:"sign"`
+	ResultCode        string `json:"result_code" xml:"result_code" structs:"result_code"`
+	ErrCode           string `json:"err_code" xml:"err_code" structs:"err_code"`
+	ErrCodeDes        string `json:"err_code_des" xml:"err_code_des" structs:"err_code_des"`
+	CouponStockId     string `json:"coupon_stock_id" xml:"coupon_stock_id" structs:"coupon_stock_id"`
+	CouponName        string `json:"coupon_name" xml:"coupon_name" structs:"coupon_name"`
+	CouponValue       int    `json:"coupon_value" xml:"coupon_value" structs:"coupon_value"`
+	CouponMininumn    int    `json:"coupon_mininumn" xml:"coupon_mininumn" structs:"coupon_mininumn"`
+	CouponStockStatus int    `json:"coupon_stock_status" xml:"coupon_stock_status" structs:"coupon_stock_status"`
+	CouponTotal       int    `json:"coupon_total" xml:"coupon_total" structs:"coupon_total"`
+	MaxQuota          int    `json:"max_quota" xml:"max_quota" structs:"max_quota"`
+	IsSendNum         int    `json:"is_send_num" xml:"is_send_num" structs:"is_send_num"`
+	BeginTime         string `json:"begin_time" xml:"begin_time" structs:"begin_time"`
+	EndTime           string `json:"end_time" xml:"end_time" structs:"end_time"`
+	CreateTime        string `json:"create_time" xml:"create_time" structs:"create_time"`
+	CouponBudget      int    `json:"coupon_budget" xml:"coupon_budget" structs:"coupon_budget"`
+}
+
+// CouponQueryRequest 查询代金券信息请求
+type CouponQueryRequest struct {
+	CouponId   string `json:"coupon_id" xml:"coupon_id" structs:"coupon_id"`
+	Openid     string `json:"openid" xml:"openid" structs:"openid"`
+	Appid      string `json:"appid" xml:"appid" structs:"appid"`
+	MchId      string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	StockId    string `json:"stock_id" xml:"stock_id" structs:"stock_id"`
+	OpUserId   string `json:"op_user_id" xml:"op_user_id" structs:"op_user_id"`
+	DeviceInfo string `json:"device_info" xml:"device_info" structs:"device_info"`
+	NonceStr   string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	Version    string `json:"version" xml:"version" structs:"version"`
+	Type       string `json:"type" xml:"type" structs:"type"`
+}
+
+// CouponQueryResponse 查询代金券信息返回
+type CouponQueryResponse struct {
+	ReturnCode        string `json:"return_code" xml:"return_code" structs:"return_code"`
+	ReturnMsg         string `json:"return_msg" xml:"return_msg" structs:"return_msg"`
+	Appid             string `json:"appid" xml:"appid" structs:"appid"`
+	MchId             string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	DeviceInfo        string `json:"device_info" xml:"device_info" structs:"device_info"`
+	NonceStr          string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	Sign              string `json:"sign" xml:"sign" structs:"sign"`
+	ResultCode        string `json:"result_code" xml:"result_code" structs:"result_code"`
+	ErrCode           string `json:"err_code" xml:"err_code" structs:"err_code"`
+	ErrCodeDes        string `json:"err_code_des" xml:"err_code_des" structs:"err_code_des"`
+	CouponStockId     string `json:"coupon_stock_id" xml:"coupon_stock_id" structs:"coupon_stock_id"`
+	CouponStockType   int    `json:"coupon_stock_type" xml:"coupon_stock_type" structs:"coupon_stock_type"`
+	CouponId          string `json:"coupon_id" xml:"coupon_id" structs:"coupon_id"`
+	CouponValue       int    `json:"coupon_value" xml:"coupon_value" structs:"coupon_value"`
+	CouponMininum     int    `json:"coupon_mininum" xml:"coupon_mininum" structs:"coupon_mininum"`
+	CouponName        string `json:"coupon_name" xml:"coupon_name" structs:"coupon_name"`
+	CouponState       string `json:"coupon_state" xml:"coupon_state" structs:"coupon_state"`
+	CouponDesc        string `json:"coupon_desc" xml:"coupon_desc" structs:"coupon_desc"`
+	CouponUseValue    int    `json:"coupon_use_value" xml:"coupon_use_value" structs:"coupon_use_value"`
+	CouponRemainValue int    `json:"coupon_remain_value" xml:"coupon_remain_value" structs:"coupon_remain_value"`
+	BeginTime         string `json:"begin_time" xml:"begin_time" structs:"begin_time"`
+	EndTime           string `json:"end_time" xml:"end_time" structs:"end_time"`
+	SendTime          string `json:"send_time" xml:"send_time" structs:"send_time"`
+	UseTime           string `json:"use_time" xml:"use_time" structs:"use_time"`
+	TradeNo           string `json:"trade_no" xml:"trade_no" structs:"trade_no"`
+	ConsumerMchId     string `json:"consumer_mch_id" xml:"consumer_mch_id" structs:"consumer_mch_id"`
+	ConsumerMchName   string `json:"consumer_mch_name" xml:"consumer_mch_name" structs:"consumer_mch_name"`
+	ConsumerMchAppid  string `json:"consumer_mch_appid" xml:"consumer_mch_appid" structs:"consumer_mch_appid"`
+	SendSource        string `json:"send_source" xml:"send_source" structs:"send_source"`
+	IsPartialUse      string `json:"is_partial_use" xml:"is_partial_use" structs:"is_partial_use"`
+}
+
+// NewCouponClient 构造代金券客户端
+func NewCouponClient(appid, mchid, key, apiclientKey, apiclientCert string) *Coupon {
+	wechatPay := NewWechatPay(appid, mchid, key, apiclientKey, apiclientCert)
+	return &Coupon{
+		wechatPay: wechatPay,
+	}
+}
+
+// AddObserver 添加接口调用观察者 需在初始化时调用
+func (coupon *Coupon) AddObserver(observer Observer) {
+	coupon.wechatPay.AddObserver(observer)
+}
+
+// SetGuard 开启限流和熔断 需在初始化时调用
+func (coupon *Coupon) SetGuard(guard *Guard) {
+	coupon.wechatPay.SetGuard(guard)
+}
+
+/**
+ * NewSendCouponRequest 构造发放代金券请求
+ * @params stockId 代金券批次id
+ * @params partnerTradeNo 商户单据号 重发时必须使用相同的单号
+ * @params openid 用户openid
+ * @return SendCouponRequest
+ */
+func (coupon *Coupon) NewSendCouponRequest(stockId, partnerTradeNo, openid string) SendCouponRequest {
+	return SendCouponRequest{
+		CouponStockId:  stockId,
+		OpenidCount:    1,
+		PartnerTradeNo: partnerTradeNo,
+		Openid:         openid,
+		Appid:          coupon.wechatPay.appid,
+		MchId:          coupon.wechatPay.mchid,
+		OpUserId:       coupon.wechatPay.mchid,
+		NonceStr:       utils.GetNonceStr(),
+		Version:        "1.0",
+		Type:           "XML",
+	}
+}
+
+// NewCouponStockQueryRequest 构造查询代金券批次请求
+func (coupon *Coupon) NewCouponStockQueryRequest(stockId string) CouponStockQueryRequest {
+	return CouponStockQueryRequest{
+		CouponStockId: stockId,
+		Appid:         coupon.wechatPay.appid,
+		MchId:         coupon.wechatPay.mchid,
+		OpUserId:      coupon.wechatPay.mchid,
+		NonceStr:      utils.GetNonceStr(),
+		Version:       "1.0",
+		Type:          "XML",
+	}
+}
+
+/**
+ * NewCouponQueryRequest 构造查询代金券信息请求
+ * @params couponId 代金券id
+ * @params openid 用户openid
+ * @params stockId 代金券批次id
+ * @return CouponQueryRequest
+ */
+func (coupon *Coupon) NewCouponQueryRequest(couponId, openid, stockId string) CouponQueryRequest {
+	return CouponQueryRequest{
+		CouponId: couponId,
+		Openid:   openid,
+		Appid:    coupon.wechatPay.appid,
+		MchId:    coupon.wechatPay.mchid,
+		StockId:  stockId,
+		OpUserId: coupon.wechatPay.mchid,
+		NonceStr: utils.GetNonceStr(),
+		Version:  "1.0",
+		Type:     "XML",
+	}
+}
+
+// Send 发放代金券
+func (coupon *Coupon) Send(request SendCouponRequest) (sendResponse *SendCouponResponse, err error) {
+	return coupon.SendContext(context.Background(), request)
+}
+
+// SendContext 发放代金券 支持ctx取消和超时
+func (coupon *Coupon) SendContext(ctx context.Context, request SendCouponRequest) (sendResponse *SendCouponResponse, err error) {
+	sendResponse = new(SendCouponResponse)
+	err = coupon.wechatPay.requestXml(ctx, SEND_COUPON, request, sendResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// QueryStock 查询代金券批次
+func (coupon *Coupon) QueryStock(request CouponStockQueryRequest) (queryResponse *CouponStockQueryResponse, err error) {
+	return coupon.QueryStockContext(context.Background(), request)
+}
+
+// QueryStockContext 查询代金券批次 支持ctx取消和超时
+func (coupon *Coupon) QueryStockContext(ctx context.Context, request CouponStockQueryRequest) (queryResponse *CouponStockQueryResponse, err error) {
+	queryResponse = new(CouponStockQueryResponse)
+	err = coupon.wechatPay.requestXml(ctx, COUPON_STOCK_QUERY, request, queryResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Query 查询代金券信息
+func (coupon *Coupon) Query(request CouponQueryRequest) (queryResponse *CouponQueryResponse, err error) {
+	return coupon.QueryContext(context.Background(), request)
+}
+
+// QueryContext 查询代金券信息 支持ctx取消和超时
+func (coupon *Coupon) QueryContext(ctx context.Context, request CouponQueryRequest) (queryResponse *CouponQueryResponse, err error) {
+	queryResponse = new(CouponQueryResponse)
+	err = coupon.wechatPay.requestXml(ctx, COUPON_QUERY, request, queryResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// IsSuccess 判断代金券是否发放成功 ret_code为SUCCESS才代表用户实际收到了代金券
+func (resp *SendCouponResponse) IsSuccess() bool {
+	return resp.ReturnCode == "SUCCESS" && resp.ResultCode == "SUCCESS" && resp.RetCode == "SUCCESS"
+}
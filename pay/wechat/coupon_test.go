@@ -0,0 +1,107 @@
+package wechat
+
+import (
+	"testing"
+)
+
+func TestCouponSend(t *testing.T) {
+	var fields map[string]string
+	transport := &fakeTransport{handle: func(path, body string) string {
+		fields = xmlFields(body)
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><coupon_stock_id>S1</coupon_stock_id>` +
+			`<resp_count>1</resp_count><success_count>1</success_count><failed_count>0</failed_count>` +
+			`<openid>o1</openid><ret_code>SUCCESS</ret_code><coupon_id>C1</coupon_id><ret_msg>ok</ret_msg></xml>`
+	}}
+	coupon := &Coupon{wechatPay: newFakeWechatPay(transport)}
+	resp, err := coupon.Send(coupon.NewSendCouponRequest("S1", "P1", "o1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/mmpaymkttransfers/send_coupon") != 1 {
+		t.Fatalf("请求地址错误: %v", transport.calls)
+	}
+	if fields["coupon_stock_id"] != "S1" || fields["openid_count"] != "1" || fields["partner_trade_no"] != "P1" || fields["openid"] != "o1" ||
+		fields["appid"] != "wx123" || fields["mch_id"] != "1900000109" || fields["op_user_id"] != "1900000109" ||
+		fields["version"] != "1.0" || fields["type"] != "XML" || fields["nonce_str"] == "" {
+		t.Errorf("请求字段错误: %v", fields)
+	}
+	if fields["sign"] != md5Sign(fields, "key") {
+		t.Errorf("签名错误: %v", fields)
+	}
+	if resp.CouponId != "C1" || resp.SuccessCount != 1 || resp.RespCount != 1 || !resp.IsSuccess() {
+		t.Errorf("返回解析错误: %+v", resp)
+	}
+}
+
+func TestCouponSendStatus(t *testing.T) {
+	cases := []struct {
+		resp    SendCouponResponse
+		success bool
+	}{
+		{SendCouponResponse{ReturnCode: "SUCCESS", ResultCode: "SUCCESS", RetCode: "SUCCESS"}, true},
+		// 请求成功但用户未实际收到代金券
+		{SendCouponResponse{ReturnCode: "SUCCESS", ResultCode: "SUCCESS", RetCode: "FAIL"}, false},
+		{SendCouponResponse{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "NOT_ENOUGH", RetCode: "SUCCESS"}, false},
+		{SendCouponResponse{ReturnCode: "FAIL"}, false},
+	}
+	for _, c := range cases {
+		if c.resp.IsSuccess() != c.success {
+			t.Errorf("%+v 期望%v", c.resp, c.success)
+		}
+	}
+}
+
+func TestCouponQueryStock(t *testing.T) {
+	var fields map[string]string
+	transport := &fakeTransport{handle: func(path, body string) string {
+		fields = xmlFields(body)
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><coupon_stock_id>S1</coupon_stock_id>` +
+			`<coupon_name>满100减10</coupon_name><coupon_value>1000</coupon_value><coupon_mininumn>10000</coupon_mininumn>` +
+			`<coupon_stock_status>4</coupon_stock_status><coupon_total>500</coupon_total><is_send_num>20</is_send_num></xml>`
+	}}
+	coupon := &Coupon{wechatPay: newFakeWechatPay(transport)}
+	resp, err := coupon.QueryStock(coupon.NewCouponStockQueryRequest("S1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/mmpaymkttransfers/query_coupon_stock") != 1 {
+		t.Fatalf("请求地址错误: %v", transport.calls)
+	}
+	if fields["coupon_stock_id"] != "S1" || fields["appid"] != "wx123" || fields["mch_id"] != "1900000109" || fields["op_user_id"] != "1900000109" {
+		t.Errorf("请求字段错误: %v", fields)
+	}
+	if fields["sign"] != md5Sign(fields, "key") {
+		t.Errorf("签名错误: %v", fields)
+	}
+	if resp.CouponName != "满100减10" || resp.CouponValue != 1000 || resp.CouponMininumn != 10000 ||
+		resp.CouponStockStatus != COUPON_STOCK_ACTIVE || resp.CouponTotal != 500 || resp.IsSendNum != 20 {
+		t.Errorf("返回解析错误: %+v", resp)
+	}
+}
+
+func TestCouponQuery(t *testing.T) {
+	var fields map[string]string
+	transport := &fakeTransport{handle: func(path, body string) string {
+		fields = xmlFields(body)
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><coupon_stock_id>S1</coupon_stock_id>` +
+			`<coupon_id>C1</coupon_id><coupon_value>1000</coupon_value><coupon_state>USED</coupon_state>` +
+			`<coupon_use_value>1000</coupon_use_value><coupon_remain_value>0</coupon_remain_value><trade_no>T1</trade_no></xml>`
+	}}
+	coupon := &Coupon{wechatPay: newFakeWechatPay(transport)}
+	resp, err := coupon.Query(coupon.NewCouponQueryRequest("C1", "o1", "S1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/mmpaymkttransfers/querycouponsinfo") != 1 {
+		t.Fatalf("请求地址错误: %v", transport.calls)
+	}
+	if fields["coupon_id"] != "C1" || fields["openid"] != "o1" || fields["stock_id"] != "S1" || fields["appid"] != "wx123" || fields["mch_id"] != "1900000109" {
+		t.Errorf("请求字段错误: %v", fields)
+	}
+	if fields["sign"] != md5Sign(fields, "key") {
+		t.Errorf("签名错误: %v", fields)
+	}
+	if resp.CouponId != "C1" || resp.CouponState != "USED" || resp.CouponUseValue != 1000 || resp.TradeNo != "T1" {
+		t.Errorf("返回解析错误: %+v", resp)
+	}
+}
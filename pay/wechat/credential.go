@@ -0,0 +1,221 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ACCESS_TOKEN = "https://api.weixin.qq.com/cgi-bin/token" // 获取access_token
+
+	TOKEN_EXPIRE_MARGIN = 5 * time.Minute // 提前刷新时间 避免临界时刻使用已过期的token
+)
+
+// apiClient 请求api.weixin.qq.com的公共http客户端 不需要商户证书
+var apiClient = &http.Client{Timeout: DEFAULT_TIMEOUT}
+
+// ApiError 微信公众平台接口返回的错误
+type ApiError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// TokenCache access_token和jsapi_ticket缓存 多实例部署时需业务方基于redis实现 避免各实例互相刷新导致token失效
+type TokenCache interface {
+	// Get 获取未过期的值 不存在时返回空字符串
+	Get(key string) (value string, err error)
+	// Set 保存并设置有效期
+	Set(key string, value string, expire time.Duration) error
+}
+
+// memoryTokenCache 单机内存缓存
+type memoryTokenCache struct {
+	mu     sync.Mutex
+	values map[string]string
+	expire map[string]time.Time
+}
+
+// credential 公众号和小程序共用的appid/secret以及access_token管理
+type credential struct {
+	appid  string
+	secret string
+	cache  TokenCache
+	mu     sync.Mutex
+	locks  map[string]*sync.Mutex // 按缓存key加锁 同一进程内只有一个请求去刷新
+}
+
+// tokenResponse 获取access_token和jsapi_ticket的返回
+type tokenResponse struct {
+	ApiError
+	AccessToken string `json:"access_token"`
+	Ticket      string `json:"ticket"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (e *ApiError) Error() string {
+	return "微信接口异常:" + strconv.Itoa(e.ErrCode) + " " + e.ErrMsg
+}
+
+// IsTokenExpired access_token失效或过期 调用方可强制刷新后重试
+func (e *ApiError) IsTokenExpired() bool {
+	return e.ErrCode == 40001 || e.ErrCode == 40014 || e.ErrCode == 42001
+}
+
+// NewMemoryTokenCache 构造单机内存缓存 仅适用于单实例部署
+func NewMemoryTokenCache() TokenCache {
+	return &memoryTokenCache{
+		values: make(map[string]string),
+		expire: make(map[string]time.Time),
+	}
+}
+
+func (m *memoryTokenCache) Get(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Now().After(m.expire[key]) {
+		return "", nil
+	}
+	return m.values[key], nil
+}
+
+func (m *memoryTokenCache) Set(key string, value string, expire time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	m.expire[key] = time.Now().Add(expire)
+	return nil
+}
+
+func newCredential(appid, secret string) *credential {
+	return &credential{
+		appid:  appid,
+		secret: secret,
+		cache:  NewMemoryTokenCache(),
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+// SetTokenCache 设置access_token缓存 需在初始化时调用
+func (c *credential) SetTokenCache(cache TokenCache) {
+	c.cache = cache
+}
+
+/**
+ * AccessToken 获取access_token 优先读取缓存 过期前TOKEN_EXPIRE_MARGIN自动刷新
+ * @return access_token err
+ */
+func (c *credential) AccessToken() (string, error) {
+	return c.AccessTokenContext(context.Background())
+}
+
+// AccessTokenContext 获取access_token 支持ctx取消和超时
+func (c *credential) AccessTokenContext(ctx context.Context) (string, error) {
+	return c.cached(ctx, "access_token:"+c.appid, "", c.fetchAccessToken)
+}
+
+/**
+ * RefreshAccessToken 刷新access_token 接口返回ApiError.IsTokenExpired时调用
+ * 只有缓存中仍是失效的token时才请求微信 并发刷新时只有一个请求生效 避免新token被反复刷掉
+ * @params staleToken 调用接口时使用的已失效的access_token
+ * @return 新的access_token err
+ */
+func (c *credential) RefreshAccessToken(ctx context.Context, staleToken string) (string, error) {
+	return c.cached(ctx, "access_token:"+c.appid, staleToken, c.fetchAccessToken)
+}
+
+// fetchAccessToken 请求微信获取新的access_token
+func (c *credential) fetchAccessToken(ctx context.Context) (string, time.Duration, error) {
+	params := url.Values{}
+	params.Set("grant_type", "client_credential")
+	params.Set("appid", c.appid)
+	params.Set("secret", c.secret)
+	resp := new(tokenResponse)
+	err := getJson(ctx, ACCESS_TOKEN, params, resp)
+	if err != nil {
+		return "", 0, err
+	}
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+/**
+ * cached 读取缓存 缓存不存在或仍为stale时通过fetch获取并写入缓存
+ * @params key 缓存key
+ * @params stale 已失效的值 缓存中仍是该值时刷新 为空时只在缓存不存在时获取
+ * @params fetch 获取新值及其有效期
+ */
+func (c *credential) cached(ctx context.Context, key string, stale string,
+	fetch func(ctx context.Context) (string, time.Duration, error)) (string, error) {
+	value, err := c.cache.Get(key)
+	if err == nil && value != "" && value != stale {
+		return value, nil
+	}
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	// 等锁期间可能已被其他请求刷新
+	value, err = c.cache.Get(key)
+	if err == nil && value != "" && value != stale {
+		return value, nil
+	}
+	value, expire, err := fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if expire > 2*TOKEN_EXPIRE_MARGIN {
+		expire -= TOKEN_EXPIRE_MARGIN
+	}
+	err = c.cache.Set(key, value, expire)
+	if err != nil {
+		return "", errors.New("缓存token异常:" + err.Error())
+	}
+	return value, nil
+}
+
+// lock 获取缓存key对应的锁 jsapi_ticket刷新时需要获取access_token 不能共用一把锁
+func (c *credential) lock(key string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, ok := c.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[key] = lock
+	}
+	return lock
+}
+
+/**
+ * getJson 请求微信公众平台接口并将返回的json解码到response
+ * @params uri 接口地址
+ * @params params query参数
+ * @params response 返回结构体指针 需嵌入ApiError
+ */
+func getJson(ctx context.Context, uri string, params url.Values, response interface{}) error {
+	request, err := http.NewRequest("GET", uri+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := apiClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return errors.New("请求异常:" + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	apiErr := new(ApiError)
+	if json.Unmarshal(data, apiErr) == nil && apiErr.ErrCode != 0 {
+		return apiErr
+	}
+	return json.Unmarshal(data, response)
+}
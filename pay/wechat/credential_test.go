@@ -0,0 +1,44 @@
+package wechat
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCredentialRefresh(t *testing.T) {
+	c := newCredential("wx123", "secret")
+	var fetches int32
+	fetch := func(ctx context.Context) (string, time.Duration, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		time.Sleep(10 * time.Millisecond)
+		return "token" + strconv.Itoa(int(n)), time.Hour, nil
+	}
+	stale, err := c.cached(context.Background(), "access_token", "", fetch)
+	if err != nil || stale != "token1" {
+		t.Fatalf("首次获取异常: %s %v", stale, err)
+	}
+	// 多个请求同时发现token失效 只刷新一次
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := c.cached(context.Background(), "access_token", stale, fetch)
+			if err != nil || token != "token2" {
+				t.Errorf("刷新后的token异常: %s %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 2 {
+		t.Fatalf("并发刷新应只请求一次 实际%d次", fetches-1)
+	}
+	// 使用更早失效的token刷新时直接返回缓存
+	if token, _ := c.cached(context.Background(), "access_token", stale, fetch); token != "token2" || fetches != 2 {
+		t.Errorf("缓存已更新时不应再次刷新: %s %d", token, fetches)
+	}
+}
@@ -0,0 +1,343 @@
+package wechat
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"github.com/mjd-pub/common_golang/pay"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	CUSTOM_DECLARE_ORDER     = "https://api.mch.weixin.qq.com/cgi-bin/mch/customs/customdeclareorder"        // 订单附加信息提交(报关)
+	CUSTOM_DECLARE_QUERY     = "https://api.mch.weixin.qq.com/cgi-bin/mch/customs/customdeclarequery"        // 报关查询
+	CUSTOM_DECLARE_REDECLARE = "https://api.mch.weixin.qq.com/cgi-bin/mch/newcustoms/customdeclareredeclare" // 报关重推
+)
+
+const (
+	CUSTOMS_STATE_UNDECLARED = "UNDECLARED" // 未申报
+	CUSTOMS_STATE_SUBMITTED  = "SUBMITTED"  // 申报已提交
+	CUSTOMS_STATE_PROCESSING = "PROCESSING" // 申报中
+	CUSTOMS_STATE_SUCCESS    = "SUCCESS"    // 申报成功
+	CUSTOMS_STATE_FAIL       = "FAIL"       // 申报失败
+	CUSTOMS_STATE_EXCEPT     = "EXCEPT"     // 海关接口异常
+
+	CERT_TYPE_IDCARD = "IDCARD" // 身份证
+)
+
+// CustomDeclareRequest 报关请求参数 拆单时每个子订单单独提交 子订单号及金额字段必填
+type CustomDeclareRequest struct {
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	OutTradeNo    string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+	TransactionId string `json:"transaction_id" xml:"transaction_id" structs:"transaction_id"`
+	Customs       string `json:"customs" xml:"customs" structs:"customs"`                      // 海关编号 如GUANGZHOU_ZS
+	MchCustomsNo  string `json:"mch_customs_no" xml:"mch_customs_no" structs:"mch_customs_no"` // 商户海关备案号
+	Duty          int    `json:"duty" xml:"duty" structs:"duty"`                               // 关税(分)
+	SubOrderNo    string `json:"sub_order_no" xml:"sub_order_no" structs:"sub_order_no"`       // 商户子订单号 拆单时必填
+	FeeType       string `json:"fee_type" xml:"fee_type" structs:"fee_type"`
+	OrderFee      int    `json:"order_fee" xml:"order_fee" structs:"order_fee"`             // 子订单金额(分) 等于物流费加商品价格
+	TransportFee  int    `json:"transport_fee" xml:"transport_fee" structs:"transport_fee"` // 物流费(分)
+	ProductFee    int    `json:"product_fee" xml:"product_fee" structs:"product_fee"`       // 商品价格(分)
+	CertType      string `json:"cert_type" xml:"cert_type" structs:"cert_type"`             // 证件类型 目前只支持IDCARD
+	CertId        string `json:"cert_id" xml:"cert_id" structs:"cert_id"`                   // 证件号码
+	Name          string `json:"name" xml:"name" structs:"name"`                            // 姓名
+}
+
+// CustomSubOrder 拆单时的子订单
+type CustomSubOrder struct {
+	SubOrderNo   string // 商户子订单号
+	TransportFee int    // 物流费(分)
+	ProductFee   int    // 商品价格(分)
+	Duty         int    // 关税(分)
+}
+
+// CustomDeclareResponse 报关返回
+type CustomDeclareResponse struct {
+	ReturnCode      string `json:"return_code" xml:"return_code"`
+	ReturnMsg       string `json:"return_msg" xml:"return_msg"`
+	SignType        string `json:"sign_type" xml:"sign_type"`
+	Sign            string `json:"sign" xml:"sign"`
+	Appid           string `json:"appid" xml:"appid"`
+	MchId           string `json:"mch_id" xml:"mch_id"`
+	ResultCode      string `json:"result_code" xml:"result_code"`
+	ErrCode         string `json:"err_code" xml:"err_code"`
+	ErrCodeDes      string `json:"err_code_des" xml:"err_code_des"`
+	State           string `json:"state" xml:"state"` // CUSTOMS_STATE_*
+	TransactionId   string `json:"transaction_id" xml:"transaction_id"`
+	OutTradeNo      string `json:"out_trade_no" xml:"out_trade_no"`
+	SubOrderNo      string `json:"sub_order_no" xml:"sub_order_no"`
+	SubOrderId      string `json:"sub_order_id" xml:"sub_order_id"` // 微信子订单号
+	ModifyTime      string `json:"modify_time" xml:"modify_time"`
+	CertCheckResult string `json:"cert_check_result" xml:"cert_check_result"` // 订购人和支付人身份信息校验结果 UNCHECKED SAME DIFFERENT
+	Explanation     string `json:"explanation" xml:"explanation"`             // 申报结果说明 仅重推接口返回
+}
+
+// CustomDeclareQueryRequest 报关查询请求参数 out_trade_no transaction_id sub_order_no sub_order_id四选一
+type CustomDeclareQueryRequest struct {
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	OutTradeNo    string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+	TransactionId string `json:"transaction_id" xml:"transaction_id" structs:"transaction_id"`
+	SubOrderNo    string `json:"sub_order_no" xml:"sub_order_no" structs:"sub_order_no"`
+	SubOrderId    string `json:"sub_order_id" xml:"sub_order_id" structs:"sub_order_id"`
+	Customs       string `json:"customs" xml:"customs" structs:"customs"`
+}
+
+// CustomDeclareRecord 报关查询返回的单条申报记录
+type CustomDeclareRecord struct {
+	SubOrderNo      string
+	SubOrderId      string
+	MchCustomsNo    string
+	Customs         string
+	Duty            pay.Money
+	State           string
+	Explanation     string
+	ModifyTime      string
+	CertCheckResult string
+	FeeType         string
+	OrderFee        pay.Money
+	TransportFee    pay.Money
+	ProductFee      pay.Money
+}
+
+// CustomDeclareQueryResponse 报关查询返回 微信以_$n后缀返回多条记录 解码后放入Records
+type CustomDeclareQueryResponse struct {
+	ReturnCode    string
+	ReturnMsg     string
+	Appid         string
+	MchId         string
+	ResultCode    string
+	ErrCode       string
+	ErrCodeDes    string
+	TransactionId string
+	OutTradeNo    string
+	Count         int
+	Records       []CustomDeclareRecord
+}
+
+// CustomRedeclareRequest 报关重推请求参数 海关返回申报异常或需要补推时使用
+type CustomRedeclareRequest struct {
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	OutTradeNo    string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+	TransactionId string `json:"transaction_id" xml:"transaction_id" structs:"transaction_id"`
+	SubOrderNo    string `json:"sub_order_no" xml:"sub_order_no" structs:"sub_order_no"`
+	SubOrderId    string `json:"sub_order_id" xml:"sub_order_id" structs:"sub_order_id"`
+	Customs       string `json:"customs" xml:"customs" structs:"customs"`
+	MchCustomsNo  string `json:"mch_customs_no" xml:"mch_customs_no" structs:"mch_customs_no"`
+}
+
+/**
+ * NewCustomDeclareRequest 构造不拆单的报关请求
+ * @params transactionId 微信支付订单号
+ * @params outTradeNo 商户订单号
+ * @params customs 海关编号
+ * @params mchCustomsNo 商户海关备案号
+ * @return CustomDeclareRequest
+ */
+func (wechat *wechatPay) NewCustomDeclareRequest(transactionId, outTradeNo, customs, mchCustomsNo string) CustomDeclareRequest {
+	return CustomDeclareRequest{
+		Appid:         wechat.appid,
+		MchId:         wechat.mchid,
+		SignType:      "MD5",
+		OutTradeNo:    outTradeNo,
+		TransactionId: transactionId,
+		Customs:       customs,
+		MchCustomsNo:  mchCustomsNo,
+	}
+}
+
+/**
+ * NewCustomDeclareSubRequests 构造拆单报关请求 一个子订单一个请求 需逐个调用CustomDeclare
+ * @params transactionId 微信支付订单号
+ * @params outTradeNo 商户订单号
+ * @params customs 海关编号
+ * @params mchCustomsNo 商户海关备案号
+ * @params subOrders 子订单 子订单金额之和需等于支付金额
+ * @return []CustomDeclareRequest
+ */
+func (wechat *wechatPay) NewCustomDeclareSubRequests(transactionId, outTradeNo, customs, mchCustomsNo string,
+	subOrders []CustomSubOrder) []CustomDeclareRequest {
+	requests := make([]CustomDeclareRequest, 0, len(subOrders))
+	for _, subOrder := range subOrders {
+		request := wechat.NewCustomDeclareRequest(transactionId, outTradeNo, customs, mchCustomsNo)
+		request.SubOrderNo = subOrder.SubOrderNo
+		request.FeeType = "CNY"
+		request.OrderFee = subOrder.TransportFee + subOrder.ProductFee
+		request.TransportFee = subOrder.TransportFee
+		request.ProductFee = subOrder.ProductFee
+		request.Duty = subOrder.Duty
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+/**
+ * CustomDeclare 提交报关
+ *
+ * @params request CustomDeclareRequest 需要海关校验订购人身份时填写CertType CertId Name
+ * @return CustomDeclareResponse err
+ */
+func (wechat *wechatPay) CustomDeclare(request CustomDeclareRequest) (resp *CustomDeclareResponse, err error) {
+	return wechat.CustomDeclareContext(context.Background(), request)
+}
+
+// CustomDeclareContext 提交报关 支持ctx取消和超时
+func (wechat *wechatPay) CustomDeclareContext(ctx context.Context, request CustomDeclareRequest) (resp *CustomDeclareResponse, err error) {
+	if request.Customs == "" || request.MchCustomsNo == "" {
+		return nil, errors.New("customs和mch_customs_no不能为空")
+	}
+	if request.SubOrderNo != "" && request.OrderFee != request.TransportFee+request.ProductFee {
+		return nil, errors.New("子订单金额需等于物流费加商品价格")
+	}
+	resp = new(CustomDeclareResponse)
+	err = wechat.requestXml(ctx, CUSTOM_DECLARE_ORDER, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+/**
+ * NewCustomDeclareQueryRequest 按商户订单号构造报关查询请求
+ * @params outTradeNo 商户订单号
+ * @params customs 海关编号
+ * @return CustomDeclareQueryRequest
+ */
+func (wechat *wechatPay) NewCustomDeclareQueryRequest(outTradeNo, customs string) CustomDeclareQueryRequest {
+	return CustomDeclareQueryRequest{
+		Appid:      wechat.appid,
+		MchId:      wechat.mchid,
+		SignType:   "MD5",
+		OutTradeNo: outTradeNo,
+		Customs:    customs,
+	}
+}
+
+/**
+ * CustomDeclareQuery 报关查询 拆单时返回全部子订单的申报记录
+ *
+ * @params request CustomDeclareQueryRequest
+ * @return CustomDeclareQueryResponse err
+ */
+func (wechat *wechatPay) CustomDeclareQuery(request CustomDeclareQueryRequest) (resp *CustomDeclareQueryResponse, err error) {
+	return wechat.CustomDeclareQueryContext(context.Background(), request)
+}
+
+// CustomDeclareQueryContext 报关查询 支持ctx取消和超时
+func (wechat *wechatPay) CustomDeclareQueryContext(ctx context.Context, request CustomDeclareQueryRequest) (resp *CustomDeclareQueryResponse, err error) {
+	resp = new(CustomDeclareQueryResponse)
+	err = wechat.requestXml(ctx, CUSTOM_DECLARE_QUERY, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+/**
+ * NewCustomRedeclareRequest 构造报关重推请求
+ * @params outTradeNo 商户订单号
+ * @params subOrderNo 商户子订单号 未拆单时为空
+ * @params customs 海关编号
+ * @params mchCustomsNo 商户海关备案号
+ * @return CustomRedeclareRequest
+ */
+func (wechat *wechatPay) NewCustomRedeclareRequest(outTradeNo, subOrderNo, customs, mchCustomsNo string) CustomRedeclareRequest {
+	return CustomRedeclareRequest{
+		Appid:        wechat.appid,
+		MchId:        wechat.mchid,
+		SignType:     "MD5",
+		OutTradeNo:   outTradeNo,
+		SubOrderNo:   subOrderNo,
+		Customs:      customs,
+		MchCustomsNo: mchCustomsNo,
+	}
+}
+
+/**
+ * CustomRedeclare 报关重推
+ *
+ * @params request CustomRedeclareRequest
+ * @return CustomDeclareResponse err
+ */
+func (wechat *wechatPay) CustomRedeclare(request CustomRedeclareRequest) (resp *CustomDeclareResponse, err error) {
+	return wechat.CustomRedeclareContext(context.Background(), request)
+}
+
+// CustomRedeclareContext 报关重推 支持ctx取消和超时
+func (wechat *wechatPay) CustomRedeclareContext(ctx context.Context, request CustomRedeclareRequest) (resp *CustomDeclareResponse, err error) {
+	resp = new(CustomDeclareResponse)
+	err = wechat.requestXml(ctx, CUSTOM_DECLARE_REDECLARE, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// UnmarshalXML 将_$n后缀的字段解码为Records
+func (resp *CustomDeclareQueryResponse) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	fields := make(map[string]string)
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			if _, end := token.(xml.EndElement); end {
+				break
+			}
+			continue
+		}
+		var value string
+		err = d.DecodeElement(&value, &element)
+		if err != nil {
+			return err
+		}
+		fields[element.Name.Local] = value
+	}
+	resp.ReturnCode = fields["return_code"]
+	resp.ReturnMsg = fields["return_msg"]
+	resp.Appid = fields["appid"]
+	resp.MchId = fields["mch_id"]
+	resp.ResultCode = fields["result_code"]
+	resp.ErrCode = fields["err_code"]
+	resp.ErrCodeDes = fields["err_code_des"]
+	resp.TransactionId = fields["transaction_id"]
+	resp.OutTradeNo = fields["out_trade_no"]
+	resp.Count, _ = strconv.Atoi(fields["count"])
+	for i := 0; i < resp.Count; i++ {
+		n := "_" + strconv.Itoa(i)
+		resp.Records = append(resp.Records, CustomDeclareRecord{
+			SubOrderNo:      fields["sub_order_no"+n],
+			SubOrderId:      fields["sub_order_id"+n],
+			MchCustomsNo:    fields["mch_customs_no"+n],
+			Customs:         fields["customs"+n],
+			Duty:            parseFen(fields["duty"+n]),
+			State:           fields["state"+n],
+			Explanation:     fields["explanation"+n],
+			ModifyTime:      fields["modify_time"+n],
+			CertCheckResult: fields["cert_check_result"+n],
+			FeeType:         fields["fee_type"+n],
+			OrderFee:        parseFen(fields["order_fee"+n]),
+			TransportFee:    parseFen(fields["transport_fee"+n]),
+			ProductFee:      parseFen(fields["product_fee"+n]),
+		})
+	}
+	return nil
+}
+
+// parseFen 解析以分为单位的金额 为空或格式错误时为0
+func parseFen(value string) pay.Money {
+	fen, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return pay.Money(fen)
+}
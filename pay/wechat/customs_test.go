@@ -0,0 +1,26 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestCustomDeclareQueryResponseUnmarshal(t *testing.T) {
+	data := `<xml><return_code><![CDATA[SUCCESS]]></return_code><result_code><![CDATA[SUCCESS]]></result_code>` +
+		`<out_trade_no>15112496832609</out_trade_no><count>2</count>` +
+		`<sub_order_no_0>A1</sub_order_no_0><state_0><![CDATA[SUCCESS]]></state_0><order_fee_0>1000</order_fee_0>` +
+		`<sub_order_no_1>A2</sub_order_no_1><state_1><![CDATA[FAIL]]></state_1><explanation_1>支付人身份信息不一致</explanation_1></xml>`
+	resp := new(CustomDeclareQueryResponse)
+	if err := xml.Unmarshal([]byte(data), resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ReturnCode != "SUCCESS" || resp.OutTradeNo != "15112496832609" || len(resp.Records) != 2 {
+		t.Fatalf("解码结果异常: %+v", resp)
+	}
+	if resp.Records[0].SubOrderNo != "A1" || resp.Records[0].OrderFee != 1000 || resp.Records[0].State != CUSTOMS_STATE_SUCCESS {
+		t.Errorf("子订单0异常: %+v", resp.Records[0])
+	}
+	if resp.Records[1].State != CUSTOMS_STATE_FAIL || resp.Records[1].Explanation != "支付人身份信息不一致" {
+		t.Errorf("子订单1异常: %+v", resp.Records[1])
+	}
+}
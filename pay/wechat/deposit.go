@@ -0,0 +1,528 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"github.com/mjd-pub/common_golang/pay"
+	"github.com/mjd-pub/common_golang/utils"
+	"strconv"
+	"time"
+)
+
+const (
+	DEPOSIT_MICROPAY     = "https://api.mch.weixin.qq.com/deposit/micropay"    // 押金付款码支付
+	DEPOSIT_FACEPAY      = "https://api.mch.weixin.qq.com/deposit/facepay"     // 押金人脸支付
+	DEPOSIT_ORDER_QUERY  = "https://api.mch.weixin.qq.com/deposit/orderquery"  // 押金订单查询
+	DEPOSIT_REVERSE      = "https://api.mch.weixin.qq.com/deposit/reverse"     // 押金撤销 全额解冻
+	DEPOSIT_CONSUME      = "https://api.mch.weixin.qq.com/deposit/consume"     // 押金消费 剩余部分自动解冻
+	DEPOSIT_REFUND       = "https://api.mch.weixin.qq.com/deposit/refund"      // 押金消费后退款
+	DEPOSIT_REFUND_QUERY = "https://api.mch.weixin.qq.com/deposit/refundquery" // 押金退款查询
+)
+
+const (
+	TRADE_STATE_SETTLING TradeState = "SETTLING" // 押金消费结算中
+	TRADE_STATE_CONSUMED TradeState = "CONSUMED" // 押金已消费
+)
+
+// Deposit 押金支付 用于租车(SCP_ZUCHE) 包车(SCP_BAOCHE)等先冻结后结算的场景 签名方式为HMAC-SHA256
+type Deposit struct {
+	wechatPay    *wechatPay
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+}
+
+// DepositMicropayRequest 押金付款码支付请求参数
+type DepositMicropayRequest struct {
+	Appid          string `json:"appid" xml:"appid" structs:"appid"`
+	MchId          string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	NonceStr       string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	SignType       string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	Deposit        string `json:"deposit" xml:"deposit" structs:"deposit"` // 固定为Y
+	Body           string `json:"body" xml:"body" structs:"body"`
+	Attach         string `json:"attach" xml:"attach" structs:"attach"`
+	OutTradeNo     string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+	TotalFee       int    `json:"total_fee" xml:"total_fee" structs:"total_fee"` // 冻结金额(分)
+	FeeType        string `json:"fee_type" xml:"fee_type" structs:"fee_type"`
+	SpbillCreateIp string `json:"spbill_create_ip" xml:"spbill_create_ip" structs:"spbill_create_ip"`
+	AuthCode       string `json:"auth_code" xml:"auth_code" structs:"auth_code"` // 用户付款码
+}
+
+// DepositFacepayRequest 押金人脸支付请求参数
+type DepositFacepayRequest struct {
+	Appid          string `json:"appid" xml:"appid" structs:"appid"`
+	MchId          string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	NonceStr       string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	SignType       string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	Deposit        string `json:"deposit" xml:"deposit" structs:"deposit"`
+	Body           string `json:"body" xml:"body" structs:"body"`
+	Attach         string `json:"attach" xml:"attach" structs:"attach"`
+	OutTradeNo     string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+	TotalFee       int    `json:"total_fee" xml:"total_fee" structs:"total_fee"`
+	FeeType        string `json:"fee_type" xml:"fee_type" structs:"fee_type"`
+	SpbillCreateIp string `json:"spbill_create_ip" xml:"spbill_create_ip" structs:"spbill_create_ip"`
+	Openid         string `json:"openid" xml:"openid" structs:"openid"`
+	FaceCode       string `json:"face_code" xml:"face_code" structs:"face_code"` // 人脸凭证
+}
+
+// DepositPayResponse 押金支付返回
+type DepositPayResponse struct {
+	ReturnCode    string    `json:"return_code" xml:"return_code"`
+	ReturnMsg     string    `json:"return_msg" xml:"return_msg"`
+	Appid         string    `json:"appid" xml:"appid"`
+	MchId         string    `json:"mch_id" xml:"mch_id"`
+	NonceStr      string    `json:"nonce_str" xml:"nonce_str"`
+	Sign          string    `json:"sign" xml:"sign"`
+	ResultCode    string    `json:"result_code" xml:"result_code"`
+	ErrCode       string    `json:"err_code" xml:"err_code"`
+	ErrCodeDes    string    `json:"err_code_des" xml:"err_code_des"`
+	Openid        string    `json:"openid" xml:"openid"`
+	TradeType     string    `json:"trade_type" xml:"trade_type"`
+	BankType      string    `json:"bank_type" xml:"bank_type"`
+	TotalFee      pay.Money `json:"total_fee" xml:"total_fee"`
+	CashFee       pay.Money `json:"cash_fee" xml:"cash_fee"`
+	TransactionId string    `json:"transaction_id" xml:"transaction_id"`
+	OutTradeNo    string    `json:"out_trade_no" xml:"out_trade_no"`
+	Attach        string    `json:"attach" xml:"attach"`
+	TimeEnd       string    `json:"time_end" xml:"time_end"`
+}
+
+// DepositOrderRequest 押金查询和撤销请求参数 transaction_id和out_trade_no二选一
+type DepositOrderRequest struct {
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	NonceStr      string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	TransactionId string `json:"transaction_id" xml:"transaction_id" structs:"transaction_id"`
+	OutTradeNo    string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+}
+
+// DepositQueryResponse 押金查询返回
+type DepositQueryResponse struct {
+	ReturnCode     string     `json:"return_code" xml:"return_code"`
+	ReturnMsg      string     `json:"return_msg" xml:"return_msg"`
+	ResultCode     string     `json:"result_code" xml:"result_code"`
+	ErrCode        string     `json:"err_code" xml:"err_code"`
+	ErrCodeDes     string     `json:"err_code_des" xml:"err_code_des"`
+	Openid         string     `json:"openid" xml:"openid"`
+	TradeType      string     `json:"trade_type" xml:"trade_type"`
+	TradeState     TradeState `json:"trade_state" xml:"trade_state"`
+	TradeStateDesc string     `json:"trade_state_desc" xml:"trade_state_desc"`
+	TotalFee       pay.Money  `json:"total_fee" xml:"total_fee"`
+	ConsumeFee     pay.Money  `json:"consume_fee" xml:"consume_fee"` // 已消费金额
+	TransactionId  string     `json:"transaction_id" xml:"transaction_id"`
+	OutTradeNo     string     `json:"out_trade_no" xml:"out_trade_no"`
+	Attach         string     `json:"attach" xml:"attach"`
+	TimeEnd        string     `json:"time_end" xml:"time_end"`
+}
+
+// DepositReverseResponse 押金撤销返回
+type DepositReverseResponse struct {
+	ReturnCode string `json:"return_code" xml:"return_code"`
+	ReturnMsg  string `json:"return_msg" xml:"return_msg"`
+	ResultCode string `json:"result_code" xml:"result_code"`
+	ErrCode    string `json:"err_code" xml:"err_code"`
+	ErrCodeDes string `json:"err_code_des" xml:"err_code_des"`
+	Recall     string `json:"recall" xml:"recall"` // Y需要继续调用撤销 N不需要
+}
+
+// DepositConsumeRequest 押金消费请求参数
+type DepositConsumeRequest struct {
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	NonceStr      string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+	TransactionId string `json:"transaction_id" xml:"transaction_id" structs:"transaction_id"`
+	TotalFee      int    `json:"total_fee" xml:"total_fee" structs:"total_fee"`       // 冻结金额(分)
+	ConsumeFee    int    `json:"consume_fee" xml:"consume_fee" structs:"consume_fee"` // 消费金额(分) 不能大于冻结金额
+	FeeType       string `json:"fee_type" xml:"fee_type" structs:"fee_type"`
+}
+
+// DepositConsumeResponse 押金消费返回
+type DepositConsumeResponse struct {
+	ReturnCode    string    `json:"return_code" xml:"return_code"`
+	ReturnMsg     string    `json:"return_msg" xml:"return_msg"`
+	ResultCode    string    `json:"result_code" xml:"result_code"`
+	ErrCode       string    `json:"err_code" xml:"err_code"`
+	ErrCodeDes    string    `json:"err_code_des" xml:"err_code_des"`
+	TransactionId string    `json:"transaction_id" xml:"transaction_id"`
+	OutTradeNo    string    `json:"out_trade_no" xml:"out_trade_no"`
+	TotalFee      pay.Money `json:"total_fee" xml:"total_fee"`
+	ConsumeFee    pay.Money `json:"consume_fee" xml:"consume_fee"`
+	FeeType       string    `json:"fee_type" xml:"fee_type"`
+}
+
+// DepositFreezeResult 押金冻结流程的最终结果
+type DepositFreezeResult struct {
+	Status        int    // DEPOSIT_PROCESS DEPOSIT_FROZEN DEPOSIT_FAIL
+	TransactionId string // 微信订单号 消费和撤销时使用
+	OutTradeNo    string
+	TotalFee      pay.Money
+	ErrCode       string
+	ErrCodeDes    string
+}
+
+/**
+ * NewDepositClient 构造押金支付客户端
+ * @return Deposit
+ */
+func NewDepositClient(appid, mchid, key, apiclientKey, apiclientCert string) *Deposit {
+	wechatPay := NewWechatPay(appid, mchid, key, apiclientKey, apiclientCert)
+	return &Deposit{
+		wechatPay:    wechatPay,
+		pollInterval: 5 * time.Second,
+		pollTimeout:  30 * time.Second,
+	}
+}
+
+// AddObserver 添加接口调用观察者 需在初始化时调用
+func (deposit *Deposit) AddObserver(observer Observer) {
+	deposit.wechatPay.AddObserver(observer)
+}
+
+// SetGuard 开启限流和熔断 需在初始化时调用
+func (deposit *Deposit) SetGuard(guard *Guard) {
+	deposit.wechatPay.SetGuard(guard)
+}
+
+// SetPoll 设置用户支付中时的查询间隔和最长等待时间 超时后撤销订单
+func (deposit *Deposit) SetPoll(interval, timeout time.Duration) {
+	deposit.pollInterval = interval
+	deposit.pollTimeout = timeout
+}
+
+/**
+ * NewDepositMicropayRequest 构造押金付款码支付请求
+ *
+ * @params bizType 业务类型 如utils.SCP_ZUCHE utils.SCP_BAOCHE 写入attach便于对账
+ * @params body 商品描述
+ * @params orderId 商户订单号
+ * @params authCode 用户付款码
+ * @params userIp 终端ip
+ * @params totalFee 冻结金额(分)
+ * @return DepositMicropayRequest
+ */
+func (deposit *Deposit) NewDepositMicropayRequest(bizType int, body, orderId, authCode, userIp string, totalFee int) DepositMicropayRequest {
+	return DepositMicropayRequest{
+		Appid:          deposit.wechatPay.appid,
+		MchId:          deposit.wechatPay.mchid,
+		NonceStr:       utils.GetNonceStr(),
+		SignType:       SIGN_TYPE_HMAC_SHA256,
+		Deposit:        "Y",
+		Body:           body,
+		Attach:         strconv.Itoa(bizType),
+		OutTradeNo:     orderId,
+		TotalFee:       totalFee,
+		FeeType:        "CNY",
+		SpbillCreateIp: userIp,
+		AuthCode:       authCode,
+	}
+}
+
+// NewDepositFacepayRequest 构造押金人脸支付请求 参数同NewDepositMicropayRequest
+func (deposit *Deposit) NewDepositFacepayRequest(bizType int, body, orderId, openid, faceCode, userIp string, totalFee int) DepositFacepayRequest {
+	return DepositFacepayRequest{
+		Appid:          deposit.wechatPay.appid,
+		MchId:          deposit.wechatPay.mchid,
+		NonceStr:       utils.GetNonceStr(),
+		SignType:       SIGN_TYPE_HMAC_SHA256,
+		Deposit:        "Y",
+		Body:           body,
+		Attach:         strconv.Itoa(bizType),
+		OutTradeNo:     orderId,
+		TotalFee:       totalFee,
+		FeeType:        "CNY",
+		SpbillCreateIp: userIp,
+		Openid:         openid,
+		FaceCode:       faceCode,
+	}
+}
+
+// NewDepositOrderRequest 构造押金查询和撤销请求
+func (deposit *Deposit) NewDepositOrderRequest(transactionId, outTradeNo string) DepositOrderRequest {
+	return DepositOrderRequest{
+		Appid:         deposit.wechatPay.appid,
+		MchId:         deposit.wechatPay.mchid,
+		NonceStr:      utils.GetNonceStr(),
+		SignType:      SIGN_TYPE_HMAC_SHA256,
+		TransactionId: transactionId,
+		OutTradeNo:    outTradeNo,
+	}
+}
+
+/**
+ * NewDepositConsumeRequest 构造押金消费请求
+ * @params transactionId 微信订单号
+ * @params totalFee 冻结金额(分)
+ * @params consumeFee 消费金额(分) 剩余部分自动解冻
+ * @return DepositConsumeRequest
+ */
+func (deposit *Deposit) NewDepositConsumeRequest(transactionId string, totalFee, consumeFee int) DepositConsumeRequest {
+	return DepositConsumeRequest{
+		Appid:         deposit.wechatPay.appid,
+		MchId:         deposit.wechatPay.mchid,
+		NonceStr:      utils.GetNonceStr(),
+		SignType:      SIGN_TYPE_HMAC_SHA256,
+		TransactionId: transactionId,
+		TotalFee:      totalFee,
+		ConsumeFee:    consumeFee,
+		FeeType:       "CNY",
+	}
+}
+
+/**
+ * NewDepositRefundRequest 构造押金退款请求 只能退已消费的部分
+ * @params transactionId 微信订单号
+ * @params outRefundNo 商户退款单号
+ * @params totalFee 消费金额(分)
+ * @params refundFee 退款金额(分)
+ * @return RefundRequests
+ */
+func (deposit *Deposit) NewDepositRefundRequest(transactionId, outRefundNo string, totalFee, refundFee int) RefundRequests {
+	request := deposit.wechatPay.NewRefundRequests(outRefundNo, transactionId, "", "", totalFee, refundFee)
+	request.SignType = SIGN_TYPE_HMAC_SHA256
+	return request
+}
+
+// NewDepositRefundQueryRequest 构造押金退款查询请求
+func (deposit *Deposit) NewDepositRefundQueryRequest(outRefundNo string) RefundQueryRequests {
+	request := deposit.wechatPay.NewRefundQueryRequests(outRefundNo)
+	request.SignType = SIGN_TYPE_HMAC_SHA256
+	return request
+}
+
+// Micropay 押金付款码支付 返回USERPAYING时需查询结果 建议使用Freeze
+func (deposit *Deposit) Micropay(request DepositMicropayRequest) (resp *DepositPayResponse, err error) {
+	return deposit.MicropayContext(context.Background(), request)
+}
+
+// MicropayContext 押金付款码支付 支持ctx取消和超时
+func (deposit *Deposit) MicropayContext(ctx context.Context, request DepositMicropayRequest) (resp *DepositPayResponse, err error) {
+	resp = new(DepositPayResponse)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_MICROPAY, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Facepay 押金人脸支付 返回USERPAYING时需查询结果 建议使用FreezeByFace
+func (deposit *Deposit) Facepay(request DepositFacepayRequest) (resp *DepositPayResponse, err error) {
+	return deposit.FacepayContext(context.Background(), request)
+}
+
+// FacepayContext 押金人脸支付 支持ctx取消和超时
+func (deposit *Deposit) FacepayContext(ctx context.Context, request DepositFacepayRequest) (resp *DepositPayResponse, err error) {
+	resp = new(DepositPayResponse)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_FACEPAY, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Query 押金订单查询
+func (deposit *Deposit) Query(request DepositOrderRequest) (resp *DepositQueryResponse, err error) {
+	return deposit.QueryContext(context.Background(), request)
+}
+
+// QueryContext 押金订单查询 支持ctx取消和超时
+func (deposit *Deposit) QueryContext(ctx context.Context, request DepositOrderRequest) (resp *DepositQueryResponse, err error) {
+	resp = new(DepositQueryResponse)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_ORDER_QUERY, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Reverse 押金撤销 全额解冻 返回recall为Y时需要再次调用
+func (deposit *Deposit) Reverse(request DepositOrderRequest) (resp *DepositReverseResponse, err error) {
+	return deposit.ReverseContext(context.Background(), request)
+}
+
+// ReverseContext 押金撤销 支持ctx取消和超时
+func (deposit *Deposit) ReverseContext(ctx context.Context, request DepositOrderRequest) (resp *DepositReverseResponse, err error) {
+	resp = new(DepositReverseResponse)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_REVERSE, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Consume 押金消费 扣除consume_fee 剩余部分自动解冻
+func (deposit *Deposit) Consume(request DepositConsumeRequest) (resp *DepositConsumeResponse, err error) {
+	return deposit.ConsumeContext(context.Background(), request)
+}
+
+// ConsumeContext 押金消费 支持ctx取消和超时
+func (deposit *Deposit) ConsumeContext(ctx context.Context, request DepositConsumeRequest) (resp *DepositConsumeResponse, err error) {
+	if request.ConsumeFee <= 0 || request.ConsumeFee > request.TotalFee {
+		return nil, errors.New("消费金额需大于0且不能超过冻结金额")
+	}
+	resp = new(DepositConsumeResponse)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_CONSUME, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Refund 押金消费后退款 与普通退款使用相同的结构体
+func (deposit *Deposit) Refund(request RefundRequests) (resp *RefundRespones, err error) {
+	return deposit.RefundContext(context.Background(), request)
+}
+
+// RefundContext 押金退款 支持ctx取消和超时
+func (deposit *Deposit) RefundContext(ctx context.Context, request RefundRequests) (resp *RefundRespones, err error) {
+	resp = new(RefundRespones)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_REFUND, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RefundQuery 押金退款查询
+func (deposit *Deposit) RefundQuery(request RefundQueryRequests) (resp *RefundQueryRespones, err error) {
+	return deposit.RefundQueryContext(context.Background(), request)
+}
+
+// RefundQueryContext 押金退款查询 支持ctx取消和超时
+func (deposit *Deposit) RefundQueryContext(ctx context.Context, request RefundQueryRequests) (resp *RefundQueryRespones, err error) {
+	resp = new(RefundQueryRespones)
+	err = deposit.wechatPay.requestXml(ctx, DEPOSIT_REFUND_QUERY, request, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+/**
+ * Freeze 付款码冻结押金
+ * 用户支付中或结果未知时按SetPoll的配置查询 超时仍未支付则撤销订单
+ *
+ * @params request NewDepositMicropayRequest构造的请求
+ * @return DepositFreezeResult err 只有ctx取消时err才不为空
+ */
+func (deposit *Deposit) Freeze(request DepositMicropayRequest) (*DepositFreezeResult, error) {
+	return deposit.FreezeContext(context.Background(), request)
+}
+
+// FreezeContext 付款码冻结押金 支持ctx取消 取消时停止查询并返回DEPOSIT_PROCESS
+func (deposit *Deposit) FreezeContext(ctx context.Context, request DepositMicropayRequest) (*DepositFreezeResult, error) {
+	return deposit.freeze(ctx, request.OutTradeNo, func() (*DepositPayResponse, error) {
+		return deposit.MicropayContext(ctx, request)
+	})
+}
+
+// FreezeByFace 人脸支付冻结押金 流程同Freeze
+func (deposit *Deposit) FreezeByFace(request DepositFacepayRequest) (*DepositFreezeResult, error) {
+	return deposit.FreezeByFaceContext(context.Background(), request)
+}
+
+// FreezeByFaceContext 人脸支付冻结押金 支持ctx取消
+func (deposit *Deposit) FreezeByFaceContext(ctx context.Context, request DepositFacepayRequest) (*DepositFreezeResult, error) {
+	return deposit.freeze(ctx, request.OutTradeNo, func() (*DepositPayResponse, error) {
+		return deposit.FacepayContext(ctx, request)
+	})
+}
+
+/**
+ * Settle 结算押金 consumeFee大于0时消费该金额并自动解冻剩余部分 为0时全额解冻
+ * @params transactionId 微信订单号
+ * @params totalFee 冻结金额(分)
+ * @params consumeFee 消费金额(分)
+ */
+func (deposit *Deposit) Settle(transactionId string, totalFee, consumeFee int) error {
+	return deposit.SettleContext(context.Background(), transactionId, totalFee, consumeFee)
+}
+
+// SettleContext 结算押金 支持ctx取消和超时
+func (deposit *Deposit) SettleContext(ctx context.Context, transactionId string, totalFee, consumeFee int) error {
+	if consumeFee == 0 {
+		return deposit.release(ctx, deposit.NewDepositOrderRequest(transactionId, ""))
+	}
+	resp, err := deposit.ConsumeContext(ctx, deposit.NewDepositConsumeRequest(transactionId, totalFee, consumeFee))
+	if err != nil {
+		return err
+	}
+	return resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+}
+
+// freeze 支付后根据结果查询或撤销
+func (deposit *Deposit) freeze(ctx context.Context, outTradeNo string, payFunc func() (*DepositPayResponse, error)) (*DepositFreezeResult, error) {
+	result := &DepositFreezeResult{Status: DEPOSIT_PROCESS, OutTradeNo: outTradeNo}
+	resp, err := payFunc()
+	if err == nil {
+		result.ErrCode = resp.ErrCode
+		result.ErrCodeDes = resp.ErrCodeDes
+		if resp.ReturnCode == "SUCCESS" && resp.ResultCode == "SUCCESS" {
+			result.Status = DEPOSIT_FROZEN
+			result.TransactionId = resp.TransactionId
+			result.TotalFee = resp.TotalFee
+			return result, nil
+		}
+		if resp.ReturnCode == "SUCCESS" && resp.ErrCode != "USERPAYING" &&
+			resp.ErrCode != "SYSTEMERROR" && resp.ErrCode != "BANKERROR" {
+			// 余额不足 付款码过期等明确失败
+			result.Status = DEPOSIT_FAIL
+			return result, nil
+		}
+	} else {
+		result.ErrCodeDes = err.Error()
+	}
+	// 用户支付中或结果未知 轮询查询
+	deadline := time.Now().Add(deposit.pollTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(deposit.pollInterval):
+		}
+		queryResp, err := deposit.QueryContext(ctx, deposit.NewDepositOrderRequest("", outTradeNo))
+		if err != nil || queryResp.ReturnCode != "SUCCESS" || queryResp.ResultCode != "SUCCESS" {
+			continue
+		}
+		switch queryResp.TradeState {
+		case TRADE_STATE_SUCCESS:
+			result.Status = DEPOSIT_FROZEN
+			result.TransactionId = queryResp.TransactionId
+			result.TotalFee = queryResp.TotalFee
+			result.ErrCode = ""
+			result.ErrCodeDes = ""
+			return result, nil
+		case TRADE_STATE_NOTPAY, TRADE_STATE_USERPAYING:
+			continue
+		default:
+			result.Status = DEPOSIT_FAIL
+			result.ErrCode = string(queryResp.TradeState)
+			result.ErrCodeDes = queryResp.TradeStateDesc
+			return result, nil
+		}
+	}
+	// 超时未支付 撤销订单避免用户稍后支付成功
+	if deposit.release(ctx, deposit.NewDepositOrderRequest("", outTradeNo)) == nil {
+		result.Status = DEPOSIT_FAIL
+		result.ErrCode = string(TRADE_STATE_REVOKED)
+		result.ErrCodeDes = "用户支付超时 已撤销"
+	}
+	return result, ctx.Err()
+}
+
+// release 撤销押金 recall为Y时最多重试3次
+func (deposit *Deposit) release(ctx context.Context, request DepositOrderRequest) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		var resp *DepositReverseResponse
+		resp, err = deposit.ReverseContext(ctx, request)
+		if err != nil {
+			continue
+		}
+		err = resultError(resp.ReturnCode, resp.ReturnMsg, resp.ResultCode, resp.ErrCode, resp.ErrCodeDes)
+		if err == nil || resp.Recall != "Y" {
+			return err
+		}
+	}
+	return err
+}
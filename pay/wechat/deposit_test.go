@@ -0,0 +1,97 @@
+package wechat
+
+import (
+	"testing"
+	"time"
+)
+
+// sequenceTransport 每个接口按顺序返回预设的xml 用完后重复最后一条
+func sequenceTransport(responses map[string][]string) *fakeTransport {
+	transport := &fakeTransport{}
+	transport.handle = func(path, body string) string {
+		list := responses[path]
+		if len(list) == 0 {
+			return `<xml><return_code>FAIL</return_code><return_msg>unexpected</return_msg></xml>`
+		}
+		n := transport.count(path) - 1
+		if n >= len(list) {
+			n = len(list) - 1
+		}
+		return list[n]
+	}
+	return transport
+}
+
+func TestDepositFreeze(t *testing.T) {
+	const (
+		micropayPath = "/deposit/micropay"
+		queryPath    = "/deposit/orderquery"
+		reversePath  = "/deposit/reverse"
+
+		paySuccess  = `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><transaction_id>W1</transaction_id><total_fee>500</total_fee></xml>`
+		userPaying  = `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>USERPAYING</err_code></xml>`
+		notEnough   = `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>NOTENOUGH</err_code></xml>`
+		stateNotpay = `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><trade_state>USERPAYING</trade_state></xml>`
+		stateOk     = `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><trade_state>SUCCESS</trade_state><transaction_id>W1</transaction_id><total_fee>500</total_fee></xml>`
+		stateClosed = `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><trade_state>CLOSED</trade_state></xml>`
+		recall      = `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>SYSTEMERROR</err_code><recall>Y</recall></xml>`
+		reverseOk   = `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><recall>N</recall></xml>`
+	)
+	cases := []struct {
+		name      string
+		responses map[string][]string
+		status    int
+		errCode   string
+		queries   int // -1不校验
+		reverses  int
+	}{
+		{"冻结成功", map[string][]string{micropayPath: {paySuccess}}, DEPOSIT_FROZEN, "", 0, 0},
+		{"余额不足", map[string][]string{micropayPath: {notEnough}}, DEPOSIT_FAIL, "NOTENOUGH", 0, 0},
+		{"支付中查询成功", map[string][]string{micropayPath: {userPaying}, queryPath: {stateNotpay, stateOk}}, DEPOSIT_FROZEN, "", 2, 0},
+		{"支付中订单关闭", map[string][]string{micropayPath: {userPaying}, queryPath: {stateClosed}}, DEPOSIT_FAIL, "CLOSED", 1, 0},
+		{"超时撤销重试后成功", map[string][]string{micropayPath: {userPaying}, queryPath: {stateNotpay}, reversePath: {recall, reverseOk}}, DEPOSIT_FAIL, "REVOKED", -1, 2},
+		{"撤销重试耗尽", map[string][]string{micropayPath: {userPaying}, queryPath: {stateNotpay}, reversePath: {recall}}, DEPOSIT_PROCESS, "USERPAYING", -1, 3},
+	}
+	for _, c := range cases {
+		transport := sequenceTransport(c.responses)
+		deposit := &Deposit{wechatPay: newFakeWechatPay(transport)}
+		deposit.SetPoll(time.Millisecond, 20*time.Millisecond)
+		result, err := deposit.Freeze(deposit.NewDepositMicropayRequest(1, "押金", "D1", "134567", "127.0.0.1", 500))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if result.Status != c.status || result.ErrCode != c.errCode {
+			t.Errorf("%s: 结果异常 %+v", c.name, result)
+		}
+		if c.status == DEPOSIT_FROZEN && (result.TransactionId != "W1" || result.TotalFee != 500) {
+			t.Errorf("%s: 冻结信息异常 %+v", c.name, result)
+		}
+		if c.queries >= 0 && transport.count(queryPath) != c.queries {
+			t.Errorf("%s: 查询%d次 期望%d次", c.name, transport.count(queryPath), c.queries)
+		}
+		if transport.count(reversePath) != c.reverses {
+			t.Errorf("%s: 撤销%d次 期望%d次", c.name, transport.count(reversePath), c.reverses)
+		}
+	}
+}
+
+func TestDepositSettle(t *testing.T) {
+	transport := sequenceTransport(map[string][]string{
+		"/deposit/consume": {`<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><consume_fee>300</consume_fee></xml>`},
+		"/deposit/reverse": {`<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code></xml>`},
+	})
+	deposit := &Deposit{wechatPay: newFakeWechatPay(transport)}
+	if err := deposit.Settle("W1", 500, 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := deposit.Settle("W1", 500, 0); err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/deposit/consume") != 1 || transport.count("/deposit/reverse") != 1 {
+		t.Errorf("消费走consume 全额解冻走reverse: %v", transport.calls)
+	}
+	if err := deposit.Settle("W1", 500, 600); err == nil {
+		t.Error("消费金额超过冻结金额应返回err")
+	}
+}
@@ -0,0 +1,281 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	BREAKER_CLOSED    = 0 // 正常调用
+	BREAKER_OPEN      = 1 // 熔断中 直接拒绝
+	BREAKER_HALF_OPEN = 2 // 熔断到期 放行一个探测请求
+)
+
+// DEFAULT_FAILURE_CODES 计入熔断失败次数的微信错误码 业务错误(如订单已支付)不计入
+var DEFAULT_FAILURE_CODES = []string{"SYSTEMERROR", "FREQUENCY_LIMITED", "FREQ_LIMIT", "SYSTEM_ERROR"}
+
+// EndpointPolicy 单个接口的限流和熔断策略 零值代表不限流不熔断
+type EndpointPolicy struct {
+	Rate             float64       // 每秒允许的请求数 0不限流
+	Burst            int           // 令牌桶容量 小于1时按1处理
+	MaxWait          time.Duration // 令牌不足时最长等待时间 0代表立即拒绝
+	FailureThreshold int           // 连续失败多少次后熔断 0不熔断
+	OpenTimeout      time.Duration // 熔断持续时间 到期后放行一个探测请求
+	FailureCodes     []string      // 计入失败的err_code 为空时使用DEFAULT_FAILURE_CODES
+}
+
+// RateLimitError 令牌不足被限流
+type RateLimitError struct {
+	MchId    string
+	Endpoint string
+}
+
+// CircuitOpenError 熔断中被拒绝
+type CircuitOpenError struct {
+	MchId      string
+	Endpoint   string
+	RetryAfter time.Duration // 距离熔断结束的时间
+}
+
+// Guard 按商户和接口维度的限流和熔断 可在多个客户端之间共享
+type Guard struct {
+	mu            sync.Mutex
+	defaultPolicy EndpointPolicy
+	policies      map[string]EndpointPolicy
+	buckets       map[string]*tokenBucket
+	breakers      map[string]*breaker
+}
+
+// tokenBucket 令牌桶 tokens可以为负数 代表已被等待中的请求预占
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// breaker 熔断器
+type breaker struct {
+	state     int
+	failures  int
+	openedAt  time.Time // 最近一次熔断的时间 早于该时间发出的请求结果不再影响熔断器
+	openUntil time.Time
+	probing   bool
+}
+
+func (e *RateLimitError) Error() string {
+	return "请求过于频繁:商户" + e.MchId + "接口" + e.Endpoint + "已被限流"
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "熔断中:商户" + e.MchId + "接口" + e.Endpoint + "暂停调用," +
+		strconv.Itoa(int(e.RetryAfter/time.Second)) + "秒后重试"
+}
+
+/**
+ * NewGuard 构造限流熔断器
+ * @params defaultPolicy 未单独配置的接口使用的策略
+ * @return Guard
+ */
+func NewGuard(defaultPolicy EndpointPolicy) *Guard {
+	return &Guard{
+		defaultPolicy: defaultPolicy,
+		policies:      make(map[string]EndpointPolicy),
+		buckets:       make(map[string]*tokenBucket),
+		breakers:      make(map[string]*breaker),
+	}
+}
+
+/**
+ * SetPolicy 单独配置某个接口的策略
+ * @params endpoint 接口地址或接口名 如REFUND或"refund"
+ * @params policy 策略
+ */
+func (g *Guard) SetPolicy(endpoint string, policy EndpointPolicy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policies[endpointName(endpoint)] = policy
+}
+
+// SetGuard 为客户端开启限流和熔断 需在初始化时调用
+func (wechat *wechatPay) SetGuard(guard *Guard) {
+	wechat.guard = guard
+}
+
+/**
+ * Allow 请求前检查熔断状态并获取令牌
+ * @return err *CircuitOpenError *RateLimitError 或等待令牌时ctx的错误
+ */
+func (g *Guard) Allow(ctx context.Context, mchid string, endpoint string) error {
+	endpoint = endpointName(endpoint)
+	key := mchid + "|" + endpoint
+	g.mu.Lock()
+	policy := g.policy(endpoint)
+	now := time.Now()
+	// probe 本次请求是否为半开状态的探测请求
+	probe := false
+	// 1.熔断检查
+	if policy.FailureThreshold > 0 {
+		b := g.breaker(key)
+		switch b.state {
+		case BREAKER_OPEN:
+			if now.Before(b.openUntil) {
+				g.mu.Unlock()
+				return &CircuitOpenError{MchId: mchid, Endpoint: endpoint, RetryAfter: b.openUntil.Sub(now)}
+			}
+			b.state = BREAKER_HALF_OPEN
+			b.probing = true
+			probe = true
+		case BREAKER_HALF_OPEN:
+			// 已有探测请求在进行中
+			if b.probing {
+				g.mu.Unlock()
+				return &CircuitOpenError{MchId: mchid, Endpoint: endpoint}
+			}
+			b.probing = true
+			probe = true
+		}
+	}
+	// 2.令牌桶
+	if policy.Rate <= 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	burst := float64(policy.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	bucket, ok := g.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		g.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * policy.Rate
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		g.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration((1 - bucket.tokens) / policy.Rate * float64(time.Second))
+	if wait > policy.MaxWait {
+		g.releaseProbe(key, probe)
+		g.mu.Unlock()
+		return &RateLimitError{MchId: mchid, Endpoint: endpoint}
+	}
+	// 预占令牌后等待
+	bucket.tokens--
+	g.mu.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		bucket.tokens++
+		g.releaseProbe(key, probe)
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+/**
+ * Report 请求结束后上报结果 网络异常 http状态码异常以及FailureCodes中的错误码计为失败
+ * ctx取消或超时不计入成功或失败 熔断前发出的请求(record.StartTime早于熔断时间)的结果直接忽略
+ * 半开状态下只有探测请求的结果能结束探测
+ * @params record 调用记录 StartTime需为Allow返回后的时间 为空时视为当前熔断周期内的请求
+ */
+func (g *Guard) Report(mchid string, endpoint string, record *AuditRecord) {
+	endpoint = endpointName(endpoint)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	policy := g.policy(endpoint)
+	if policy.FailureThreshold <= 0 {
+		return
+	}
+	failed := record.Err != nil
+	if !failed && record.ErrCode != "" {
+		codes := policy.FailureCodes
+		if len(codes) == 0 {
+			codes = DEFAULT_FAILURE_CODES
+		}
+		for _, code := range codes {
+			if record.ErrCode == code {
+				failed = true
+				break
+			}
+		}
+	}
+	b := g.breaker(mchid + "|" + endpoint)
+	if b.state != BREAKER_CLOSED && !record.StartTime.IsZero() && record.StartTime.Before(b.openedAt) {
+		return
+	}
+	// 熔断后只放行探测请求 非关闭状态下收到的当前周期结果只能来自探测请求
+	if b.state == BREAKER_HALF_OPEN {
+		b.probing = false
+	}
+	if errors.Is(record.Err, context.Canceled) || errors.Is(record.Err, context.DeadlineExceeded) {
+		return
+	}
+	if !failed {
+		b.state = BREAKER_CLOSED
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BREAKER_HALF_OPEN || b.failures >= policy.FailureThreshold {
+		b.state = BREAKER_OPEN
+		b.openedAt = time.Now()
+		b.openUntil = b.openedAt.Add(policy.OpenTimeout)
+	}
+}
+
+/**
+ * State 获取熔断器状态
+ * @return BREAKER_CLOSED BREAKER_OPEN BREAKER_HALF_OPEN
+ */
+func (g *Guard) State(mchid string, endpoint string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[mchid+"|"+endpointName(endpoint)]
+	if !ok {
+		return BREAKER_CLOSED
+	}
+	if b.state == BREAKER_OPEN && !time.Now().Before(b.openUntil) {
+		return BREAKER_HALF_OPEN
+	}
+	return b.state
+}
+
+// policy 获取接口策略 调用方需持有锁
+func (g *Guard) policy(endpoint string) EndpointPolicy {
+	if policy, ok := g.policies[endpoint]; ok {
+		return policy
+	}
+	return g.defaultPolicy
+}
+
+// breaker 获取熔断器 调用方需持有锁
+func (g *Guard) breaker(key string) *breaker {
+	b, ok := g.breakers[key]
+	if !ok {
+		b = &breaker{}
+		g.breakers[key] = b
+	}
+	return b
+}
+
+// releaseProbe 探测请求未发出时释放探测名额 只有探测请求自身才能释放 调用方需持有锁
+func (g *Guard) releaseProbe(key string, probe bool) {
+	if !probe {
+		return
+	}
+	if b, ok := g.breakers[key]; ok && b.state == BREAKER_HALF_OPEN {
+		b.probing = false
+	}
+}
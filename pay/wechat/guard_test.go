@@ -0,0 +1,103 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGuardRateLimit(t *testing.T) {
+	guard := NewGuard(EndpointPolicy{})
+	guard.SetPolicy(REFUND, EndpointPolicy{Rate: 10, Burst: 2})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := guard.Allow(ctx, "1900000109", "refund"); err != nil {
+			t.Fatalf("第%d次请求不应被限流: %v", i+1, err)
+		}
+	}
+	err := guard.Allow(ctx, "1900000109", "refund")
+	if _, ok := err.(*RateLimitError); !ok {
+		t.Fatalf("令牌耗尽后应返回RateLimitError, got %v", err)
+	}
+	// 其他商户和未配置的接口不受影响
+	if err = guard.Allow(ctx, "1900000110", "refund"); err != nil {
+		t.Error(err)
+	}
+	if err = guard.Allow(ctx, "1900000109", "orderquery"); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(110 * time.Millisecond)
+	if err = guard.Allow(ctx, "1900000109", "refund"); err != nil {
+		t.Errorf("补充令牌后应放行: %v", err)
+	}
+}
+
+func TestGuardCircuitBreaker(t *testing.T) {
+	guard := NewGuard(EndpointPolicy{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+	mchid, endpoint := "1900000109", "orderquery"
+	fail := &AuditRecord{Err: errors.New("请求异常:timeout")}
+	for i := 0; i < 2; i++ {
+		if err := guard.Allow(ctx, mchid, endpoint); err != nil {
+			t.Fatal(err)
+		}
+		guard.Report(mchid, endpoint, fail)
+	}
+	err := guard.Allow(ctx, mchid, endpoint)
+	if _, ok := err.(*CircuitOpenError); !ok {
+		t.Fatalf("连续失败后应熔断, got %v", err)
+	}
+	// 业务错误不计入失败
+	guard.Report("1900000110", endpoint, &AuditRecord{HttpCode: 200, ErrCode: "ORDERNOTEXIST"})
+	if guard.State("1900000110", endpoint) != BREAKER_CLOSED {
+		t.Error("业务错误不应触发熔断")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err = guard.Allow(ctx, mchid, endpoint); err != nil {
+		t.Fatalf("熔断到期后应放行探测请求: %v", err)
+	}
+	if err = guard.Allow(ctx, mchid, endpoint); err == nil {
+		t.Fatal("探测期间应拒绝其他请求")
+	}
+	guard.Report(mchid, endpoint, &AuditRecord{HttpCode: 200, ReturnCode: "SUCCESS"})
+	if guard.State(mchid, endpoint) != BREAKER_CLOSED {
+		t.Error("探测成功后应恢复")
+	}
+}
+
+func TestGuardProbeReport(t *testing.T) {
+	guard := NewGuard(EndpointPolicy{FailureThreshold: 1, OpenTimeout: 30 * time.Millisecond})
+	ctx := context.Background()
+	mchid, endpoint := "1900000109", "orderquery"
+	// ctx取消不计入失败
+	guard.Report(mchid, endpoint, &AuditRecord{StartTime: time.Now(), Err: context.Canceled})
+	if guard.State(mchid, endpoint) != BREAKER_CLOSED {
+		t.Fatal("ctx取消不应触发熔断")
+	}
+	// slow在熔断前发出 结果晚于探测请求返回
+	slow := &AuditRecord{StartTime: time.Now(), HttpCode: 200, ReturnCode: "SUCCESS"}
+	time.Sleep(time.Millisecond)
+	guard.Report(mchid, endpoint, &AuditRecord{StartTime: time.Now(), Err: errors.New("请求异常:timeout")})
+	time.Sleep(40 * time.Millisecond)
+	if err := guard.Allow(ctx, mchid, endpoint); err != nil {
+		t.Fatalf("熔断到期后应放行探测请求: %v", err)
+	}
+	probeStart := time.Now()
+	guard.Report(mchid, endpoint, slow)
+	if err := guard.Allow(ctx, mchid, endpoint); err == nil {
+		t.Fatal("熔断前请求的结果不应结束探测")
+	}
+	// 探测请求被取消 释放探测名额但不恢复
+	guard.Report(mchid, endpoint, &AuditRecord{StartTime: probeStart, Err: context.DeadlineExceeded})
+	if guard.State(mchid, endpoint) != BREAKER_HALF_OPEN {
+		t.Fatal("探测请求取消后应保持半开")
+	}
+	if err := guard.Allow(ctx, mchid, endpoint); err != nil {
+		t.Fatalf("探测请求取消后应放行新的探测请求: %v", err)
+	}
+	guard.Report(mchid, endpoint, &AuditRecord{StartTime: time.Now(), HttpCode: 200, ReturnCode: "SUCCESS"})
+	if guard.State(mchid, endpoint) != BREAKER_CLOSED {
+		t.Error("探测成功后应恢复")
+	}
+}
@@ -1,11 +1,10 @@
 package wechat
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"github.com/mjd-pub/common_golang/utils"
-	"io/ioutil"
 	"strconv"
 	"time"
 )
@@ -59,6 +58,31 @@ func NewH5PayClient(appid, mchid, key, apiclientKey, apiclientCert string) *H5Pa
 	}
 }
 
+// AddObserver 添加接口调用观察者 需在初始化时调用
+func (h5Pay *H5Pay) AddObserver(observer Observer) {
+	h5Pay.wechatPay.AddObserver(observer)
+}
+
+// SetGuard 开启限流和熔断 需在初始化时调用
+func (h5Pay *H5Pay) SetGuard(guard *Guard) {
+	h5Pay.wechatPay.SetGuard(guard)
+}
+
+// NewOrderQueryRequest 构造查询订单请求 transactionId优先
+func (h5Pay *H5Pay) NewOrderQueryRequest(transactionId, outTradeNo string) OrderQueryRequest {
+	return h5Pay.wechatPay.NewOrderQueryRequest(transactionId, outTradeNo)
+}
+
+// QueryOrder 查询订单 返回带交易状态的OrderQueryResult
+func (h5Pay *H5Pay) QueryOrder(request OrderQueryRequest) (result *OrderQueryResult, err error) {
+	return h5Pay.wechatPay.QueryOrderContext(context.Background(), request)
+}
+
+// QueryOrderContext 查询订单 支持ctx取消和超时
+func (h5Pay *H5Pay) QueryOrderContext(ctx context.Context, request OrderQueryRequest) (result *OrderQueryResult, err error) {
+	return h5Pay.wechatPay.QueryOrderContext(ctx, request)
+}
+
 /**
  * NewH5PayRequest 构造下单请求
  *
@@ -109,22 +133,76 @@ func (h5Pay *H5Pay) NewH5PayRequest(body, detail, orderId, userIp, notifyUrl, op
  * @return h5Resp err
  */
 func (h5Pay *H5Pay) Pay(request H5PayRequest) (h5Resp *H5PayRespones, err error) {
+	return h5Pay.PayContext(context.Background(), request)
+}
+
+// PayContext 发起支付 支持ctx取消和超时
+func (h5Pay *H5Pay) PayContext(ctx context.Context, request H5PayRequest) (h5Resp *H5PayRespones, err error) {
+	h5Resp = new(H5PayRespones)
 	// 向微信发送请求
-	resp, err := h5Pay.wechatPay.Request(UNIFIED_ORDER, request)
+	err = h5Pay.wechatPay.requestXml(ctx, UNIFIED_ORDER, request, h5Resp)
 	if err != nil {
-		return nil, errors.New("请求异常:" + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("httpCode Err:" + strconv.Itoa(resp.StatusCode))
+		return nil, err
 	}
-	respData, err := ioutil.ReadAll(resp.Body)
+	return
+}
+
+/**
+ * NewH5JsapiPayRequest 构造微信内置浏览器中的JSAPI下单请求 客户端需使用公众号appid构造
+ * openid通过OfficialAccount网页授权获取
+ *
+ * @params body
+ * @params detail
+ * @params orderId 订单id
+ * @params userIp 用户ip
+ * @params notifyUrl 异步回调url
+ * @params openid 公众号openid
+ * @params price 价格单位(元)
+ *
+ * @return H5PayRequest
+ */
+func (h5Pay *H5Pay) NewH5JsapiPayRequest(body, detail, orderId, userIp, notifyUrl, openid string, price float64) H5PayRequest {
+	request := h5Pay.NewH5PayRequest(body, detail, orderId, userIp, notifyUrl, openid, price)
+	request.TradeType = "JSAPI"
+	request.SceneInfo = ""
+	return request
+}
+
+/**
+ * JsapiPay JSAPI下单 并生成WeixinJSBridge调起支付所需的参数
+ *
+ * @params request NewH5JsapiPayRequest构造的请求
+ * @return h5Resp frontRequest err
+ */
+func (h5Pay *H5Pay) JsapiPay(request H5PayRequest) (h5Resp *H5PayRespones, frontRequest *AppletPayFrontRequest, err error) {
+	return h5Pay.JsapiPayContext(context.Background(), request)
+}
+
+// JsapiPayContext JSAPI下单 支持ctx取消和超时
+func (h5Pay *H5Pay) JsapiPayContext(ctx context.Context, request H5PayRequest) (h5Resp *H5PayRespones, frontRequest *AppletPayFrontRequest, err error) {
+	h5Resp, err = h5Pay.PayContext(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if h5Resp.ReturnCode != "SUCCESS" || h5Resp.ResultCode != "SUCCESS" {
+		return h5Resp, nil, errors.New("下单失败:" + h5Resp.ReturnMsg + h5Resp.ErrCodeDes)
 	}
-	//xml解码
-	err = xml.Unmarshal(respData, &h5Resp)
+	frontRequest = &AppletPayFrontRequest{
+		Appid:     h5Pay.wechatPay.appid,
+		TimeStamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  utils.GetNonceStr(),
+		Package:   "prepay_id=" + h5Resp.PrepayId,
+		SignType:  "MD5",
+	}
+	frontRequest.PaySign, err = h5Pay.wechatPay.signData(map[string]interface{}{
+		"appId":     frontRequest.Appid,
+		"timeStamp": frontRequest.TimeStamp,
+		"nonceStr":  frontRequest.NonceStr,
+		"package":   frontRequest.Package,
+		"signType":  frontRequest.SignType,
+	})
 	if err != nil {
-		return nil, err
+		return h5Resp, nil, err
 	}
 	return
 }
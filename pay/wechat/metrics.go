@@ -0,0 +1,198 @@
+package wechat
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CALL_SUCCESS = "SUCCESS" // 通信和业务均成功
+	CALL_FAIL    = "FAIL"    // 微信返回业务或通信失败
+	CALL_ERROR   = "ERROR"   // 网络异常 http状态码异常或解码失败
+)
+
+// DEFAULT_LATENCY_BUCKETS 默认耗时分布区间(秒)
+var DEFAULT_LATENCY_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics 微信接口调用指标
+type Metrics interface {
+	// IncCall 调用次数 result为CALL_SUCCESS CALL_FAIL CALL_ERROR
+	IncCall(endpoint string, httpCode int, result string)
+	// IncErrCode 业务错误码次数
+	IncErrCode(endpoint string, errCode string)
+	// ObserveLatency 调用耗时
+	ObserveLatency(endpoint string, latency time.Duration)
+}
+
+// metricsObserver 将调用记录转换为指标
+type metricsObserver struct {
+	metrics Metrics
+}
+
+// PromMetrics 以prometheus文本格式输出的内存指标 不依赖prometheus客户端
+type PromMetrics struct {
+	mu        sync.Mutex
+	buckets   []float64
+	calls     map[[3]string]int64
+	errCodes  map[[2]string]int64
+	latencies map[string]*histogram
+}
+
+// histogram 耗时分布 counts与buckets一一对应 不累加
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// NewMetricsObserver 构造指标观察者 通过AddObserver注册
+func NewMetricsObserver(metrics Metrics) Observer {
+	return &metricsObserver{metrics: metrics}
+}
+
+// Observe 记录一次调用
+func (m *metricsObserver) Observe(record *AuditRecord) {
+	endpoint := endpointName(record.Uri)
+	result := CALL_SUCCESS
+	if record.Err != nil {
+		result = CALL_ERROR
+	} else if record.ReturnCode != "SUCCESS" || (record.ResultCode != "" && record.ResultCode != "SUCCESS") {
+		result = CALL_FAIL
+	}
+	m.metrics.IncCall(endpoint, record.HttpCode, result)
+	if record.ErrCode != "" {
+		m.metrics.IncErrCode(endpoint, record.ErrCode)
+	}
+	m.metrics.ObserveLatency(endpoint, record.Latency)
+}
+
+// endpointName 取uri最后一段作为接口名 如unifiedorder
+func endpointName(uri string) string {
+	uri = strings.TrimRight(uri, "/")
+	return uri[strings.LastIndex(uri, "/")+1:]
+}
+
+// NewPromMetrics 构造prometheus文本格式指标 buckets为空时使用DEFAULT_LATENCY_BUCKETS
+func NewPromMetrics(buckets ...float64) *PromMetrics {
+	if len(buckets) == 0 {
+		buckets = DEFAULT_LATENCY_BUCKETS
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &PromMetrics{
+		buckets:   buckets,
+		calls:     make(map[[3]string]int64),
+		errCodes:  make(map[[2]string]int64),
+		latencies: make(map[string]*histogram),
+	}
+}
+
+// IncCall 实现Metrics
+func (p *PromMetrics) IncCall(endpoint string, httpCode int, result string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[[3]string{endpoint, strconv.Itoa(httpCode), result}]++
+}
+
+// IncErrCode 实现Metrics
+func (p *PromMetrics) IncErrCode(endpoint string, errCode string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errCodes[[2]string{endpoint, errCode}]++
+}
+
+// ObserveLatency 实现Metrics
+func (p *PromMetrics) ObserveLatency(endpoint string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.latencies[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(p.buckets))}
+		p.latencies[endpoint] = h
+	}
+	seconds := latency.Seconds()
+	for i, bucket := range p.buckets {
+		if seconds <= bucket {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteTo 按prometheus文本格式输出全部指标
+func (p *PromMetrics) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	var buff strings.Builder
+	buff.WriteString("# HELP wechat_pay_requests_total Total number of WeChat Pay API calls.\n")
+	buff.WriteString("# TYPE wechat_pay_requests_total counter\n")
+	callKeys := make([][3]string, 0, len(p.calls))
+	for key := range p.calls {
+		callKeys = append(callKeys, key)
+	}
+	sort.Slice(callKeys, func(i, j int) bool {
+		return strings.Join(callKeys[i][:], "\x00") < strings.Join(callKeys[j][:], "\x00")
+	})
+	for _, key := range callKeys {
+		fmt.Fprintf(&buff, "wechat_pay_requests_total{endpoint=\"%s\",http_code=\"%s\",result=\"%s\"} %d\n",
+			escapeLabel(key[0]), key[1], key[2], p.calls[key])
+	}
+
+	buff.WriteString("# HELP wechat_pay_err_code_total Total number of WeChat Pay err_code responses.\n")
+	buff.WriteString("# TYPE wechat_pay_err_code_total counter\n")
+	errKeys := make([][2]string, 0, len(p.errCodes))
+	for key := range p.errCodes {
+		errKeys = append(errKeys, key)
+	}
+	sort.Slice(errKeys, func(i, j int) bool {
+		return errKeys[i][0]+"\x00"+errKeys[i][1] < errKeys[j][0]+"\x00"+errKeys[j][1]
+	})
+	for _, key := range errKeys {
+		fmt.Fprintf(&buff, "wechat_pay_err_code_total{endpoint=\"%s\",err_code=\"%s\"} %d\n",
+			escapeLabel(key[0]), escapeLabel(key[1]), p.errCodes[key])
+	}
+
+	buff.WriteString("# HELP wechat_pay_request_duration_seconds WeChat Pay API call latency.\n")
+	buff.WriteString("# TYPE wechat_pay_request_duration_seconds histogram\n")
+	endpoints := make([]string, 0, len(p.latencies))
+	for endpoint := range p.latencies {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		h := p.latencies[endpoint]
+		label := escapeLabel(endpoint)
+		var cumulative int64
+		for i, bucket := range p.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&buff, "wechat_pay_request_duration_seconds_bucket{endpoint=\"%s\",le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(bucket, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&buff, "wechat_pay_request_duration_seconds_bucket{endpoint=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&buff, "wechat_pay_request_duration_seconds_sum{endpoint=\"%s\"} %s\n", label, strconv.FormatFloat(h.sum, 'f', -1, 64))
+		fmt.Fprintf(&buff, "wechat_pay_request_duration_seconds_count{endpoint=\"%s\"} %d\n", label, h.count)
+	}
+	p.mu.Unlock()
+	n, err := io.WriteString(w, buff.String())
+	return int64(n), err
+}
+
+// ServeHTTP 提供/metrics接口
+func (p *PromMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// escapeLabel 转义prometheus标签值
+func escapeLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
@@ -0,0 +1,36 @@
+package wechat
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromMetrics(t *testing.T) {
+	metrics := NewPromMetrics(0.1, 1)
+	observer := NewMetricsObserver(metrics)
+	observer.Observe(&AuditRecord{Uri: UNIFIED_ORDER, HttpCode: 200, ReturnCode: "SUCCESS", ResultCode: "SUCCESS", Latency: 50 * time.Millisecond})
+	observer.Observe(&AuditRecord{Uri: UNIFIED_ORDER, HttpCode: 200, ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "ORDERPAID", Latency: 500 * time.Millisecond})
+	observer.Observe(&AuditRecord{Uri: REFUND, Err: errors.New("timeout"), Latency: 2 * time.Second})
+
+	buff := new(bytes.Buffer)
+	metrics.WriteTo(buff)
+	out := buff.String()
+	for _, line := range []string{
+		`wechat_pay_requests_total{endpoint="unifiedorder",http_code="200",result="SUCCESS"} 1`,
+		`wechat_pay_requests_total{endpoint="unifiedorder",http_code="200",result="FAIL"} 1`,
+		`wechat_pay_requests_total{endpoint="refund",http_code="0",result="ERROR"} 1`,
+		`wechat_pay_err_code_total{endpoint="unifiedorder",err_code="ORDERPAID"} 1`,
+		`wechat_pay_request_duration_seconds_bucket{endpoint="unifiedorder",le="0.1"} 1`,
+		`wechat_pay_request_duration_seconds_bucket{endpoint="unifiedorder",le="1"} 2`,
+		`wechat_pay_request_duration_seconds_bucket{endpoint="refund",le="1"} 0`,
+		`wechat_pay_request_duration_seconds_bucket{endpoint="refund",le="+Inf"} 1`,
+		`wechat_pay_request_duration_seconds_count{endpoint="unifiedorder"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("缺少指标: %s\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package wechat
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/mjd-pub/common_golang/utils"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OAUTH_AUTHORIZE     = "https://open.weixin.qq.com/connect/oauth2/authorize" // 网页授权跳转地址
+	OAUTH_ACCESS_TOKEN  = "https://api.weixin.qq.com/sns/oauth2/access_token"   // 通过code换取网页授权access_token
+	OAUTH_REFRESH_TOKEN = "https://api.weixin.qq.com/sns/oauth2/refresh_token"  // 刷新网页授权access_token
+	OAUTH_USERINFO      = "https://api.weixin.qq.com/sns/userinfo"              // 拉取用户信息
+	JSAPI_TICKET        = "https://api.weixin.qq.com/cgi-bin/ticket/getticket"  // 获取jsapi_ticket
+)
+
+const (
+	SCOPE_BASE     = "snsapi_base"     // 静默授权 只能获取openid
+	SCOPE_USERINFO = "snsapi_userinfo" // 需用户确认 可获取昵称头像等信息
+)
+
+// OfficialAccount 公众号网页授权和JS-SDK签名 用于微信内置浏览器中的JSAPI支付
+type OfficialAccount struct {
+	*credential
+}
+
+// OAuthToken 网页授权access_token 与基础access_token不同 每个用户一个
+type OAuthToken struct {
+	ApiError
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Openid       string `json:"openid"`
+	Scope        string `json:"scope"`
+	Unionid      string `json:"unionid"`
+}
+
+// OAuthUserInfo snsapi_userinfo授权后拉取的用户信息
+type OAuthUserInfo struct {
+	ApiError
+	Openid     string   `json:"openid"`
+	Nickname   string   `json:"nickname"`
+	Sex        int      `json:"sex"`
+	Province   string   `json:"province"`
+	City       string   `json:"city"`
+	Country    string   `json:"country"`
+	HeadImgUrl string   `json:"headimgurl"`
+	Privilege  []string `json:"privilege"`
+	Unionid    string   `json:"unionid"`
+}
+
+// JsSdkConfig 前端wx.config所需参数
+type JsSdkConfig struct {
+	AppId     string `json:"appId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+/**
+ * NewOfficialAccountClient 构造公众号客户端
+ * @params appid 公众号appid
+ * @params secret 公众号appsecret
+ * @return OfficialAccount
+ */
+func NewOfficialAccountClient(appid, secret string) *OfficialAccount {
+	return &OfficialAccount{
+		credential: newCredential(appid, secret),
+	}
+}
+
+/**
+ * AuthorizeUrl 构造网页授权地址 用户同意后跳转到redirectUri?code=CODE&state=STATE
+ * @params redirectUri 回调地址 域名需与公众号后台配置一致
+ * @params scope SCOPE_BASE或SCOPE_USERINFO
+ * @params state 原样带回 用于防止csrf
+ * @return 授权地址
+ */
+func (account *OfficialAccount) AuthorizeUrl(redirectUri, scope, state string) string {
+	// 微信要求参数按固定顺序拼接
+	return OAUTH_AUTHORIZE + "?appid=" + account.appid +
+		"&redirect_uri=" + url.QueryEscape(redirectUri) +
+		"&response_type=code&scope=" + scope +
+		"&state=" + url.QueryEscape(state) + "#wechat_redirect"
+}
+
+/**
+ * ExchangeCode 通过授权回调的code换取openid和网页授权access_token
+ * @params code 授权回调参数 只能使用一次 5分钟未使用自动过期
+ * @return OAuthToken err 微信返回错误时err为*ApiError
+ */
+func (account *OfficialAccount) ExchangeCode(code string) (*OAuthToken, error) {
+	return account.ExchangeCodeContext(context.Background(), code)
+}
+
+// ExchangeCodeContext 通过code换取openid 支持ctx取消和超时
+func (account *OfficialAccount) ExchangeCodeContext(ctx context.Context, code string) (*OAuthToken, error) {
+	if code == "" {
+		return nil, errors.New("code不能为空")
+	}
+	params := url.Values{}
+	params.Set("appid", account.appid)
+	params.Set("secret", account.secret)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+	token := new(OAuthToken)
+	err := getJson(ctx, OAUTH_ACCESS_TOKEN, params, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// RefreshOAuthToken 使用refresh_token刷新网页授权access_token refresh_token有效期30天
+func (account *OfficialAccount) RefreshOAuthToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	params := url.Values{}
+	params.Set("appid", account.appid)
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", refreshToken)
+	token := new(OAuthToken)
+	err := getJson(ctx, OAUTH_REFRESH_TOKEN, params, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+/**
+ * UserInfo 拉取用户信息 仅SCOPE_USERINFO授权可用
+ * @params oauthAccessToken ExchangeCode返回的网页授权access_token
+ * @params openid
+ * @return OAuthUserInfo err
+ */
+func (account *OfficialAccount) UserInfo(ctx context.Context, oauthAccessToken, openid string) (*OAuthUserInfo, error) {
+	params := url.Values{}
+	params.Set("access_token", oauthAccessToken)
+	params.Set("openid", openid)
+	params.Set("lang", "zh_CN")
+	userInfo := new(OAuthUserInfo)
+	err := getJson(ctx, OAUTH_USERINFO, params, userInfo)
+	if err != nil {
+		return nil, err
+	}
+	return userInfo, nil
+}
+
+/**
+ * JsapiTicket 获取jsapi_ticket 与access_token共用缓存 有效期7200秒
+ * @return jsapi_ticket err
+ */
+func (account *OfficialAccount) JsapiTicket(ctx context.Context) (string, error) {
+	return account.cached(ctx, "jsapi_ticket:"+account.appid, "", account.fetchJsapiTicket)
+}
+
+// fetchJsapiTicket 请求微信获取新的jsapi_ticket access_token失效时刷新后重试一次
+func (account *OfficialAccount) fetchJsapiTicket(ctx context.Context) (string, time.Duration, error) {
+	accessToken, err := account.AccessTokenContext(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	resp := new(tokenResponse)
+	err = getJson(ctx, JSAPI_TICKET, url.Values{"access_token": {accessToken}, "type": {"jsapi"}}, resp)
+	if apiErr, ok := err.(*ApiError); ok && apiErr.IsTokenExpired() {
+		accessToken, err = account.RefreshAccessToken(ctx, accessToken)
+		if err != nil {
+			return "", 0, err
+		}
+		err = getJson(ctx, JSAPI_TICKET, url.Values{"access_token": {accessToken}, "type": {"jsapi"}}, resp)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return resp.Ticket, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+/**
+ * JsConfig 生成前端wx.config所需的签名参数
+ * @params pageUrl 调用JS-SDK的当前页面完整地址 #及其后面的部分会被去掉
+ * @return JsSdkConfig err
+ */
+func (account *OfficialAccount) JsConfig(ctx context.Context, pageUrl string) (*JsSdkConfig, error) {
+	ticket, err := account.JsapiTicket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := &JsSdkConfig{
+		AppId:     account.appid,
+		Timestamp: time.Now().Unix(),
+		NonceStr:  utils.GetNonceStr(),
+	}
+	config.Signature = SignJsSdk(ticket, config.NonceStr, config.Timestamp, pageUrl)
+	return config, nil
+}
+
+/**
+ * SignJsSdk JS-SDK签名 按字段名ASCII排序后拼接再做SHA1
+ * @params ticket jsapi_ticket
+ * @params nonceStr 随机字符串
+ * @params timestamp 时间戳(秒)
+ * @params pageUrl 当前页面地址
+ * @return 小写十六进制签名
+ */
+func SignJsSdk(ticket, nonceStr string, timestamp int64, pageUrl string) string {
+	if index := strings.Index(pageUrl, "#"); index >= 0 {
+		pageUrl = pageUrl[:index]
+	}
+	str := "jsapi_ticket=" + ticket + "&noncestr=" + nonceStr +
+		"&timestamp=" + strconv.FormatInt(timestamp, 10) + "&url=" + pageUrl
+	return fmt.Sprintf("%x", sha1.Sum([]byte(str)))
+}
@@ -0,0 +1,24 @@
+package wechat
+
+import (
+	"testing"
+)
+
+func TestSignJsSdk(t *testing.T) {
+	// 微信JS-SDK文档中的签名示例
+	ticket := "sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg"
+	sign := SignJsSdk(ticket, "Wm3WZYTPz0wzccnW", 1414587457, "http://mp.weixin.qq.com?params=value#top")
+	if sign != "0f9de62fce790f9a083d5c99e95740ceb90c27ed" {
+		t.Errorf("SignJsSdk() = %s", sign)
+	}
+}
+
+func TestAuthorizeUrl(t *testing.T) {
+	account := NewOfficialAccountClient("wx520c15f417810387", "secret")
+	got := account.AuthorizeUrl("https://m.example.com/pay?id=1", SCOPE_BASE, "abc")
+	want := "https://open.weixin.qq.com/connect/oauth2/authorize?appid=wx520c15f417810387" +
+		"&redirect_uri=https%3A%2F%2Fm.example.com%2Fpay%3Fid%3D1&response_type=code&scope=snsapi_base&state=abc#wechat_redirect"
+	if got != want {
+		t.Errorf("AuthorizeUrl() = %s", got)
+	}
+}
@@ -0,0 +1,136 @@
+package wechat
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"github.com/mjd-pub/common_golang/pay"
+	"github.com/mjd-pub/common_golang/utils"
+	"time"
+)
+
+// TradeState 微信订单交易状态
+type TradeState string
+
+const (
+	TRADE_STATE_SUCCESS    TradeState = "SUCCESS"    // 支付成功
+	TRADE_STATE_REFUND     TradeState = "REFUND"     // 转入退款
+	TRADE_STATE_NOTPAY     TradeState = "NOTPAY"     // 未支付
+	TRADE_STATE_CLOSED     TradeState = "CLOSED"     // 已关闭
+	TRADE_STATE_REVOKED    TradeState = "REVOKED"    // 已撤销(付款码支付)
+	TRADE_STATE_USERPAYING TradeState = "USERPAYING" // 用户支付中(付款码支付)
+	TRADE_STATE_PAYERROR   TradeState = "PAYERROR"   // 支付失败
+)
+
+// wechatLocation 微信返回的时间均为北京时间
+var wechatLocation = time.FixedZone("CST", 8*3600)
+
+// OrderQueryRequest 查询订单请求参数 transaction_id和out_trade_no二选一
+type OrderQueryRequest struct {
+	Appid         string `json:"appid" xml:"appid" structs:"appid"`
+	MchId         string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	TransactionId string `json:"transaction_id" xml:"transaction_id" structs:"transaction_id"`
+	OutTradeNo    string `json:"out_trade_no" xml:"out_trade_no" structs:"out_trade_no"`
+	NonceStr      string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	SignType      string `json:"sign_type" xml:"sign_type" structs:"sign_type"`
+}
+
+// OrderQueryResult 查询订单返回 金额单位为分
+type OrderQueryResult struct {
+	ReturnCode         string     `json:"return_code,omitempty" xml:"return_code,omitempty"`
+	ReturnMsg          string     `json:"return_msg,omitempty" xml:"return_msg,omitempty"`
+	Appid              string     `json:"appid,omitempty" xml:"appid,omitempty"`
+	MchId              string     `json:"mch_id,omitempty" xml:"mch_id,omitempty"`
+	NonceStr           string     `json:"nonce_str,omitempty" xml:"nonce_str,omitempty"`
+	Sign               string     `json:"sign,omitempty" xml:"sign,omitempty"`
+	ResultCode         string     `json:"result_code,omitempty" xml:"result_code,omitempty"`
+	ErrCode            string     `json:"err_code,omitempty" xml:"err_code,omitempty"`
+	ErrCodeDes         string     `json:"err_code_des,omitempty" xml:"err_code_des,omitempty"`
+	DeviceInfo         string     `json:"device_info,omitempty" xml:"device_info,omitempty"`
+	Openid             string     `json:"openid,omitempty" xml:"openid,omitempty"`
+	IsSubscribe        string     `json:"is_subscribe,omitempty" xml:"is_subscribe,omitempty"`
+	TradeType          string     `json:"trade_type,omitempty" xml:"trade_type,omitempty"`
+	TradeState         TradeState `json:"trade_state,omitempty" xml:"trade_state,omitempty"`
+	TradeStateDesc     string     `json:"trade_state_desc,omitempty" xml:"trade_state_desc,omitempty"`
+	BankType           string     `json:"bank_type,omitempty" xml:"bank_type,omitempty"`
+	TotalFee           pay.Money  `json:"total_fee,omitempty" xml:"total_fee,omitempty"`
+	SettlementTotalFee pay.Money  `json:"settlement_total_fee,omitempty" xml:"settlement_total_fee,omitempty"`
+	FeeType            string     `json:"fee_type,omitempty" xml:"fee_type,omitempty"`
+	CashFee            pay.Money  `json:"cash_fee,omitempty" xml:"cash_fee,omitempty"`
+	CashFeeType        string     `json:"cash_fee_type,omitempty" xml:"cash_fee_type,omitempty"`
+	CouponFee          pay.Money  `json:"coupon_fee,omitempty" xml:"coupon_fee,omitempty"`
+	CouponCount        int        `json:"coupon_count,omitempty" xml:"coupon_count,omitempty"`
+	CouponType0        string     `json:"coupon_type_0,omitempty" xml:"coupon_type_0,omitempty"`
+	CouponId0          string     `json:"coupon_id_0,omitempty" xml:"coupon_id_0,omitempty"`
+	CouponFee0         pay.Money  `json:"coupon_fee_0,omitempty" xml:"coupon_fee_0,omitempty"`
+	TransactionId      string     `json:"transaction_id,omitempty" xml:"transaction_id,omitempty"`
+	OutTradeNo         string     `json:"out_trade_no,omitempty" xml:"out_trade_no,omitempty"`
+	Attach             string     `json:"attach,omitempty" xml:"attach,omitempty"`
+	TimeEnd            time.Time  `json:"time_end,omitempty" xml:"-"` // 支付完成时间 未支付时为零值
+}
+
+// UnmarshalXML 解析time_end为time.Time
+func (result *OrderQueryResult) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type alias OrderQueryResult
+	raw := struct {
+		*alias
+		TimeEnd string `xml:"time_end"`
+	}{alias: (*alias)(result)}
+	err := d.DecodeElement(&raw, &start)
+	if err != nil {
+		return err
+	}
+	if raw.TimeEnd == "" {
+		return nil
+	}
+	result.TimeEnd, err = time.ParseInLocation("20060102150405", raw.TimeEnd, wechatLocation)
+	if err != nil {
+		return errors.New("time_end格式错误:" + raw.TimeEnd)
+	}
+	return nil
+}
+
+// IsPaid 是否已支付 转入退款的订单同样已支付
+func (result *OrderQueryResult) IsPaid() bool {
+	return result.TradeState == TRADE_STATE_SUCCESS || result.TradeState == TRADE_STATE_REFUND
+}
+
+/**
+ * NewOrderQueryRequest 构造查询订单请求
+ * @params transactionId 微信订单号 优先使用
+ * @params outTradeNo 商户订单号 transactionId为空时使用
+ * @return OrderQueryRequest
+ */
+func (wechat *wechatPay) NewOrderQueryRequest(transactionId, outTradeNo string) OrderQueryRequest {
+	return OrderQueryRequest{
+		Appid:         wechat.appid,
+		MchId:         wechat.mchid,
+		TransactionId: transactionId,
+		OutTradeNo:    outTradeNo,
+		NonceStr:      utils.GetNonceStr(),
+		SignType:      "MD5",
+	}
+}
+
+/**
+ * QueryOrder 查询订单 小程序 h5和JSAPI支付通用
+ *
+ * @params request OrderQueryRequest
+ * @return OrderQueryResult err
+ */
+func (wechat *wechatPay) QueryOrder(request OrderQueryRequest) (result *OrderQueryResult, err error) {
+	return wechat.QueryOrderContext(context.Background(), request)
+}
+
+// QueryOrderContext 查询订单 支持ctx取消和超时
+func (wechat *wechatPay) QueryOrderContext(ctx context.Context, request OrderQueryRequest) (result *OrderQueryResult, err error) {
+	if request.TransactionId == "" && request.OutTradeNo == "" {
+		return nil, errors.New("transaction_id和out_trade_no不能同时为空")
+	}
+	result = new(OrderQueryResult)
+	err = wechat.requestXml(ctx, ORDER_QUERY, request, result)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestOrderQueryResultUnmarshal(t *testing.T) {
+	data := `<xml><return_code><![CDATA[SUCCESS]]></return_code><result_code><![CDATA[SUCCESS]]></result_code>` +
+		`<openid><![CDATA[oUpF8uMuAJO_M2pxb1Q9zNjWeS6o]]></openid><trade_state><![CDATA[SUCCESS]]></trade_state>` +
+		`<total_fee>101</total_fee><cash_fee>100</cash_fee><time_end><![CDATA[20141030133525]]></time_end></xml>`
+	result := new(OrderQueryResult)
+	if err := xml.Unmarshal([]byte(data), result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Openid != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" || result.TotalFee.String() != "1.01" || result.CashFee.Fen() != 100 {
+		t.Errorf("解码结果异常: %+v", result)
+	}
+	if !result.IsPaid() || result.TradeState != TRADE_STATE_SUCCESS {
+		t.Errorf("trade_state异常: %s", result.TradeState)
+	}
+	if !result.TimeEnd.Equal(time.Date(2014, 10, 30, 5, 35, 25, 0, time.UTC)) {
+		t.Errorf("time_end解析异常: %s", result.TimeEnd)
+	}
+}
+
+func TestAppletPayQueryRespones(t *testing.T) {
+	transport := &fakeTransport{handle: func(path, body string) string {
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><openid>o1</openid>` +
+			`<trade_state>SUCCESS</trade_state><total_fee>101</total_fee><fee_type>CNY</fee_type><time_end>20141030133525</time_end></xml>`
+	}}
+	appletPay := &AppletPay{wechatPay: newFakeWechatPay(transport)}
+	resp, err := appletPay.Query(AppletPayQueryRequests{OutTradeNo: "T1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OppenId != "o1" || resp.TotalFree != 101 || resp.FreeType != "CNY" || resp.TimeEnd != "20141030133525" || resp.TradeState != "SUCCESS" {
+		t.Errorf("旧结构字段未填充: %+v", resp)
+	}
+
+	// 旧调用方依赖交易状态区分已关闭和未支付的订单
+	transport.handle = func(path, body string) string {
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code>` +
+			`<trade_state>CLOSED</trade_state><trade_state_desc>订单已关闭</trade_state_desc></xml>`
+	}
+	resp, err = appletPay.Query(AppletPayQueryRequests{OutTradeNo: "T1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TradeState != "CLOSED" || resp.TradeStateDesc != "订单已关闭" {
+		t.Errorf("交易状态未填充: %+v", resp)
+	}
+}
@@ -0,0 +1,272 @@
+package wechat
+
+import (
+	"context"
+	"github.com/mjd-pub/common_golang/utils"
+)
+
+// RedPack 现金红包
+type RedPack struct {
+	wechatPay *wechatPay
+}
+
+// RedPackRequest 普通红包发放请求
+type RedPackRequest struct {
+	NonceStr    string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	MchBillno   string `json:"mch_billno" xml:"mch_billno" structs:"mch_billno"`
+	MchId       string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	Wxappid     string `json:"wxappid" xml:"wxappid" structs:"wxappid"`
+	SendName    string `json:"send_name" xml:"send_name" structs:"send_name"`
+	ReOpenid    string `json:"re_openid" xml:"re_openid" structs:"re_openid"`
+	TotalAmount int    `json:"total_amount" xml:"total_amount" structs:"total_amount"`
+	TotalNum    int    `json:"total_num" xml:"total_num" structs:"total_num"`
+	Wishing     string `json:"wishing" xml:"wishing" structs:"wishing"`
+	ClientIp    string `json:"client_ip" xml:"client_ip" structs:"client_ip"`
+	ActName     string `json:"act_name" xml:"act_name" structs:"act_name"`
+	Remark      string `json:"remark" xml:"remark" structs:"remark"`
+	SceneId     string `json:"scene_id" xml:"scene_id" structs:"scene_id"`
+	RiskInfo    string `json:"risk_info" xml:"risk_info" structs:"risk_info"`
+}
+
+// GroupRedPackRequest 裂变红包发放请求
+type GroupRedPackRequest struct {
+	NonceStr    string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	MchBillno   string `json:"mch_billno" xml:"mch_billno" structs:"mch_billno"`
+	MchId       string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	Wxappid     string `json:"wxappid" xml:"wxappid" structs:"wxappid"`
+	SendName    string `json:"send_name" xml:"send_name" structs:"send_name"`
+	ReOpenid    string `json:"re_openid" xml:"re_openid" structs:"re_openid"`
+	TotalAmount int    `json:"total_amount" xml:"total_amount" structs:"total_amount"`
+	TotalNum    int    `json:"total_num" xml:"total_num" structs:"total_num"`
+	AmtType     string `json:"amt_type" xml:"amt_type" structs:"amt_type"`
+	Wishing     string `json:"wishing" xml:"wishing" structs:"wishing"`
+	ActName     string `json:"act_name" xml:"act_name" structs:"act_name"`
+	Remark      string `json:"remark" xml:"remark" structs:"remark"`
+	SceneId     string `json:"scene_id" xml:"scene_id" structs:"scene_id"`
+	RiskInfo    string `json:"risk_info" xml:"risk_info" structs:"risk_info"`
+}
+
+// RedPackResponse 红包发放返回(普通红包和裂变红包相同)
+type RedPackResponse struct {
+	ReturnCode  string `json:"return_code" xml:"return_code" structs:"return_code"`
+	ReturnMsg   string `json:"return_msg" xml:"return_msg" structs:"return_msg"`
+	ResultCode  string `json:"result_code" xml:"result_code" structs:"result_code"`
+	ErrCode     string `json:"err_code" xml:"err_code" structs:"err_code"`
+	ErrCodeDes  string `json:"err_code_des" xml:"err_code_des" structs:"err_code_des"`
+	MchBillno   string `json:"mch_billno" xml:"mch_billno" structs:"mch_billno"`
+	MchId       string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	Wxappid     string `json:"wxappid" xml:"wxappid" structs:"wxappid"`
+	ReOpenid    string `json:"re_openid" xml:"re_openid" structs:"re_openid"`
+	TotalAmount int    `json:"total_amount" xml:"total_amount" structs:"total_amount"`
+	SendListid  string `json:"send_listid" xml:"send_listid" structs:"send_listid"`
+}
+
+// RedPackQueryRequest 红包查询请求
+type RedPackQueryRequest struct {
+	NonceStr  string `json:"nonce_str" xml:"nonce_str" structs:"nonce_str"`
+	MchBillno string `json:"mch_billno" xml:"mch_billno" structs:"mch_billno"`
+	MchId     string `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	Appid     string `json:"appid" xml:"appid" structs:"appid"`
+	BillType  string `json:"bill_type" xml:"bill_type" structs:"bill_type"`
+}
+
+// RedPackReceiver 红包领取记录
+type RedPackReceiver struct {
+	Openid  string `json:"openid" xml:"openid"`
+	Amount  int    `json:"amount" xml:"amount"`
+	RcvTime string `json:"rcv_time" xml:"rcv_time"`
+}
+
+// RedPackQueryResponse 红包查询返回
+type RedPackQueryResponse struct {
+	ReturnCode   string            `json:"return_code" xml:"return_code" structs:"return_code"`
+	ReturnMsg    string            `json:"return_msg" xml:"return_msg" structs:"return_msg"`
+	ResultCode   string            `json:"result_code" xml:"result_code" structs:"result_code"`
+	ErrCode      string            `json:"err_code" xml:"err_code" structs:"err_code"`
+	ErrCodeDes   string            `json:"err_code_des" xml:"err_code_des" structs:"err_code_des"`
+	MchBillno    string            `json:"mch_billno" xml:"mch_billno" structs:"mch_billno"`
+	MchId        string            `json:"mch_id" xml:"mch_id" structs:"mch_id"`
+	DetailId     string            `json:"detail_id" xml:"detail_id" structs:"detail_id"`
+	Status       string            `json:"status" xml:"status" structs:"status"`
+	SendType     string            `json:"send_type" xml:"send_type" structs:"send_type"`
+	HbType       string            `json:"hb_type" xml:"hb_type" structs:"hb_type"`
+	TotalNum     int               `json:"total_num" xml:"total_num" structs:"total_num"`
+	TotalAmount  int               `json:"total_amount" xml:"total_amount" structs:"total_amount"`
+	Reason       string            `json:"reason" xml:"reason" structs:"reason"`
+	SendTime     string            `json:"send_time" xml:"send_time" structs:"send_time"`
+	RefundTime   string            `json:"refund_time" xml:"refund_time" structs:"refund_time"`
+	RefundAmount int               `json:"refund_amount" xml:"refund_amount" structs:"refund_amount"`
+	Wishing      string            `json:"wishing" xml:"wishing" structs:"wishing"`
+	Remark       string            `json:"remark" xml:"remark" structs:"remark"`
+	ActName      string            `json:"act_name" xml:"act_name" structs:"act_name"`
+	HbList       []RedPackReceiver `json:"hblist" xml:"hblist>hbinfo" structs:"hblist"`
+}
+
+// NewRedPackClient 构造红包客户端 appid需为公众号或小程序的appid
+func NewRedPackClient(appid, mchid, key, apiclientKey, apiclientCert string) *RedPack {
+	wechatPay := NewWechatPay(appid, mchid, key, apiclientKey, apiclientCert)
+	return &RedPack{
+		wechatPay: wechatPay,
+	}
+}
+
+// AddObserver 添加接口调用观察者 需在初始化时调用
+func (redPack *RedPack) AddObserver(observer Observer) {
+	redPack.wechatPay.AddObserver(observer)
+}
+
+// SetGuard 开启限流和熔断 需在初始化时调用
+func (redPack *RedPack) SetGuard(guard *Guard) {
+	redPack.wechatPay.SetGuard(guard)
+}
+
+/**
+ * NewRedPackRequest 构造普通红包请求
+ * @params billno 商户订单号 同一订单重发时必须使用相同的单号
+ * @params openid 接收红包的用户openid
+ * @params amount 红包金额(单位:分)
+ * @params sendName 商户名称
+ * @params wishing 红包祝福语
+ * @params actName 活动名称
+ * @params remark 备注
+ * @params clientIp 调用接口的机器ip
+ * @return RedPackRequest
+ */
+func (redPack *RedPack) NewRedPackRequest(billno, openid string, amount int, sendName, wishing, actName, remark, clientIp string) RedPackRequest {
+	return RedPackRequest{
+		NonceStr:    utils.GetNonceStr(),
+		MchBillno:   billno,
+		MchId:       redPack.wechatPay.mchid,
+		Wxappid:     redPack.wechatPay.appid,
+		SendName:    sendName,
+		ReOpenid:    openid,
+		TotalAmount: amount,
+		TotalNum:    1,
+		Wishing:     wishing,
+		ClientIp:    clientIp,
+		ActName:     actName,
+		Remark:      remark,
+	}
+}
+
+/**
+ * NewGroupRedPackRequest 构造裂变红包请求
+ * @params billno 商户订单号
+ * @params openid 种子用户openid
+ * @params amount 红包总金额(单位:分)
+ * @params num 红包发放总人数 至少3人
+ * @params sendName 商户名称
+ * @params wishing 红包祝福语
+ * @params actName 活动名称
+ * @params remark 备注
+ * @return GroupRedPackRequest
+ */
+func (redPack *RedPack) NewGroupRedPackRequest(billno, openid string, amount, num int, sendName, wishing, actName, remark string) GroupRedPackRequest {
+	return GroupRedPackRequest{
+		NonceStr:    utils.GetNonceStr(),
+		MchBillno:   billno,
+		MchId:       redPack.wechatPay.mchid,
+		Wxappid:     redPack.wechatPay.appid,
+		SendName:    sendName,
+		ReOpenid:    openid,
+		TotalAmount: amount,
+		TotalNum:    num,
+		AmtType:     "ALL_RAND", // 全部随机
+		Wishing:     wishing,
+		ActName:     actName,
+		Remark:      remark,
+	}
+}
+
+// NewRedPackQueryRequest 构造红包查询请求
+func (redPack *RedPack) NewRedPackQueryRequest(billno string) RedPackQueryRequest {
+	return RedPackQueryRequest{
+		NonceStr:  utils.GetNonceStr(),
+		MchBillno: billno,
+		MchId:     redPack.wechatPay.mchid,
+		Appid:     redPack.wechatPay.appid,
+		BillType:  "MCHT", // 通过商户订单号查询
+	}
+}
+
+// Send 发放普通红包
+func (redPack *RedPack) Send(request RedPackRequest) (sendResponse *RedPackResponse, err error) {
+	return redPack.SendContext(context.Background(), request)
+}
+
+// SendContext 发放普通红包 支持ctx取消和超时
+func (redPack *RedPack) SendContext(ctx context.Context, request RedPackRequest) (sendResponse *RedPackResponse, err error) {
+	sendResponse = new(RedPackResponse)
+	err = redPack.wechatPay.requestXml(ctx, SEND_REDPACK, request, sendResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// SendGroup 发放裂变红包
+func (redPack *RedPack) SendGroup(request GroupRedPackRequest) (sendResponse *RedPackResponse, err error) {
+	return redPack.SendGroupContext(context.Background(), request)
+}
+
+// SendGroupContext 发放裂变红包 支持ctx取消和超时
+func (redPack *RedPack) SendGroupContext(ctx context.Context, request GroupRedPackRequest) (sendResponse *RedPackResponse, err error) {
+	sendResponse = new(RedPackResponse)
+	err = redPack.wechatPay.requestXml(ctx, SEND_GROUP_REDPACK, request, sendResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Query 红包查询
+func (redPack *RedPack) Query(request RedPackQueryRequest) (queryResponse *RedPackQueryResponse, err error) {
+	return redPack.QueryContext(context.Background(), request)
+}
+
+// QueryContext 红包查询 支持ctx取消和超时
+func (redPack *RedPack) QueryContext(ctx context.Context, request RedPackQueryRequest) (queryResponse *RedPackQueryResponse, err error) {
+	queryResponse = new(RedPackQueryResponse)
+	err = redPack.wechatPay.requestXml(ctx, REDPACK_QUERY, request, queryResponse)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+/**
+ * SendStatus 根据发放返回判断红包状态
+ * SYSTEMERROR和PROCESSING时结果未知 需使用原单号重试或查询
+ * @return REDPACK_SENT REDPACK_SENDING REDPACK_FAIL
+ */
+func (resp *RedPackResponse) SendStatus() int {
+	if resp.ReturnCode == "SUCCESS" && resp.ResultCode == "SUCCESS" {
+		return REDPACK_SENT
+	}
+	if resp.ReturnCode != "SUCCESS" || resp.ErrCode == "SYSTEMERROR" || resp.ErrCode == "PROCESSING" {
+		return REDPACK_SENDING
+	}
+	return REDPACK_FAIL
+}
+
+// RedPackStatus 将查询返回的红包状态转换为状态码 查询失败或未知状态返回DEFAULT
+func (resp *RedPackQueryResponse) RedPackStatus() int {
+	if resp.ReturnCode != "SUCCESS" || resp.ResultCode != "SUCCESS" {
+		return DEFAULT
+	}
+	switch resp.Status {
+	case "SENDING":
+		return REDPACK_SENDING
+	case "SENT":
+		return REDPACK_SENT
+	case "FAILED":
+		return REDPACK_FAIL
+	case "RECEIVED":
+		return REDPACK_RECEIVED
+	case "RFUND_ING":
+		return REDPACK_REFUNDING
+	case "REFUND":
+		return REDPACK_REFUND
+	}
+	return DEFAULT
+}
@@ -0,0 +1,127 @@
+package wechat
+
+import (
+	"testing"
+)
+
+func TestRedPackSend(t *testing.T) {
+	var fields map[string]string
+	transport := &fakeTransport{handle: func(path, body string) string {
+		fields = xmlFields(body)
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><mch_billno>R1</mch_billno>` +
+			`<wxappid>wx123</wxappid><re_openid>o1</re_openid><total_amount>100</total_amount><send_listid>L1</send_listid></xml>`
+	}}
+	redPack := &RedPack{wechatPay: newFakeWechatPay(transport)}
+	resp, err := redPack.Send(redPack.NewRedPackRequest("R1", "o1", 100, "商户", "恭喜发财", "活动", "备注", "127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/mmpaymkttransfers/sendredpack") != 1 {
+		t.Fatalf("请求地址错误: %v", transport.calls)
+	}
+	// 红包接口使用wxappid而不是appid 签名同样基于wxappid
+	if fields["wxappid"] != "wx123" || fields["appid"] != "" || fields["mch_id"] != "1900000109" ||
+		fields["re_openid"] != "o1" || fields["total_amount"] != "100" || fields["total_num"] != "1" || fields["client_ip"] != "127.0.0.1" {
+		t.Errorf("请求字段错误: %v", fields)
+	}
+	if fields["sign"] != md5Sign(fields, "key") {
+		t.Errorf("签名错误: %v", fields)
+	}
+	if resp.SendListid != "L1" || resp.TotalAmount != 100 || resp.SendStatus() != REDPACK_SENT {
+		t.Errorf("返回解析错误: %+v", resp)
+	}
+}
+
+func TestRedPackSendGroup(t *testing.T) {
+	var fields map[string]string
+	transport := &fakeTransport{handle: func(path, body string) string {
+		fields = xmlFields(body)
+		return `<xml><return_code>SUCCESS</return_code><result_code>FAIL</result_code><err_code>NOTENOUGH</err_code></xml>`
+	}}
+	redPack := &RedPack{wechatPay: newFakeWechatPay(transport)}
+	resp, err := redPack.SendGroup(redPack.NewGroupRedPackRequest("R2", "o1", 300, 3, "商户", "恭喜发财", "活动", "备注"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/mmpaymkttransfers/sendgroupredpack") != 1 {
+		t.Fatalf("请求地址错误: %v", transport.calls)
+	}
+	if fields["wxappid"] != "wx123" || fields["amt_type"] != "ALL_RAND" || fields["total_num"] != "3" || fields["total_amount"] != "300" {
+		t.Errorf("请求字段错误: %v", fields)
+	}
+	if fields["sign"] != md5Sign(fields, "key") {
+		t.Errorf("签名错误: %v", fields)
+	}
+	if resp.ErrCode != "NOTENOUGH" || resp.SendStatus() != REDPACK_FAIL {
+		t.Errorf("返回解析错误: %+v", resp)
+	}
+}
+
+func TestRedPackQuery(t *testing.T) {
+	var fields map[string]string
+	transport := &fakeTransport{handle: func(path, body string) string {
+		fields = xmlFields(body)
+		return `<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><mch_billno>R2</mch_billno>` +
+			`<detail_id>D1</detail_id><status>RECEIVED</status><hb_type>GROUP</hb_type><total_num>2</total_num><total_amount>300</total_amount>` +
+			`<hblist><hbinfo><openid>o1</openid><amount>100</amount><rcv_time>2019-09-01 12:00:00</rcv_time></hbinfo>` +
+			`<hbinfo><openid>o2</openid><amount>200</amount><rcv_time>2019-09-01 12:01:00</rcv_time></hbinfo></hblist></xml>`
+	}}
+	redPack := &RedPack{wechatPay: newFakeWechatPay(transport)}
+	resp, err := redPack.Query(redPack.NewRedPackQueryRequest("R2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.count("/mmpaymkttransfers/gethbinfo") != 1 {
+		t.Fatalf("请求地址错误: %v", transport.calls)
+	}
+	// 查询接口使用appid
+	if fields["appid"] != "wx123" || fields["wxappid"] != "" || fields["bill_type"] != "MCHT" || fields["mch_billno"] != "R2" {
+		t.Errorf("请求字段错误: %v", fields)
+	}
+	if fields["sign"] != md5Sign(fields, "key") {
+		t.Errorf("签名错误: %v", fields)
+	}
+	if resp.DetailId != "D1" || resp.TotalAmount != 300 || resp.RedPackStatus() != REDPACK_RECEIVED {
+		t.Errorf("返回解析错误: %+v", resp)
+	}
+	if len(resp.HbList) != 2 || resp.HbList[0].Openid != "o1" || resp.HbList[1].Amount != 200 || resp.HbList[1].RcvTime != "2019-09-01 12:01:00" {
+		t.Errorf("领取记录解析错误: %+v", resp.HbList)
+	}
+}
+
+func TestRedPackStatus(t *testing.T) {
+	sendCases := []struct {
+		resp   RedPackResponse
+		status int
+	}{
+		{RedPackResponse{ReturnCode: "SUCCESS", ResultCode: "SUCCESS"}, REDPACK_SENT},
+		{RedPackResponse{ReturnCode: "FAIL"}, REDPACK_SENDING},
+		{RedPackResponse{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "SYSTEMERROR"}, REDPACK_SENDING},
+		{RedPackResponse{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "PROCESSING"}, REDPACK_SENDING},
+		{RedPackResponse{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "NOTENOUGH"}, REDPACK_FAIL},
+	}
+	for _, c := range sendCases {
+		if status := c.resp.SendStatus(); status != c.status {
+			t.Errorf("%+v 发放状态%d 期望%d", c.resp, status, c.status)
+		}
+	}
+	queryCases := map[string]int{
+		"SENDING":   REDPACK_SENDING,
+		"SENT":      REDPACK_SENT,
+		"FAILED":    REDPACK_FAIL,
+		"RECEIVED":  REDPACK_RECEIVED,
+		"RFUND_ING": REDPACK_REFUNDING,
+		"REFUND":    REDPACK_REFUND,
+		"UNKNOWN":   DEFAULT,
+	}
+	for state, expect := range queryCases {
+		resp := RedPackQueryResponse{ReturnCode: "SUCCESS", ResultCode: "SUCCESS", Status: state}
+		if status := resp.RedPackStatus(); status != expect {
+			t.Errorf("%s 查询状态%d 期望%d", state, status, expect)
+		}
+	}
+	resp := RedPackQueryResponse{ReturnCode: "SUCCESS", ResultCode: "FAIL", Status: "SENT"}
+	if resp.RedPackStatus() != DEFAULT {
+		t.Error("查询失败时应返回DEFAULT")
+	}
+}
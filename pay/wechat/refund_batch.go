@@ -0,0 +1,444 @@
+package wechat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// REFUND_RETRY_CODES 原单重试的退款错误码 其他业务错误直接判定失败
+var REFUND_RETRY_CODES = []string{"SYSTEMERROR", "BIZERR_NEED_RETRY", "FREQUENCY_LIMITED"}
+
+// Refunder 批量退款依赖的退款能力 AppletPay H5Pay等客户端均已实现
+type Refunder interface {
+	NewRefundQueryRequests(outRefundNo string) RefundQueryRequests
+	RefundContext(ctx context.Context, request RefundRequests) (*RefundRespones, error)
+	RefundQueryContext(ctx context.Context, request RefundQueryRequests) (*RefundQueryRespones, error)
+}
+
+// RefundProgress 单笔退款的执行进度
+type RefundProgress struct {
+	OutRefundNo string    `json:"out_refund_no"`
+	OutTradeNo  string    `json:"out_trade_no"`
+	RefundFee   int       `json:"refund_fee"`
+	Status      int       `json:"status"` // DEFAULT未发起 REFUND_PROCESS REFUND_SUCCESS REFUND_FAIL
+	RefundId    string    `json:"refund_id"`
+	ErrCode     string    `json:"err_code"`
+	ErrCodeDes  string    `json:"err_code_des"`
+	Attempts    int       `json:"attempts"` // 实际发起退款的次数
+	UpdateTime  time.Time `json:"update_time"`
+}
+
+// RefundProgressStore 批量退款进度存储 用于进程崩溃后断点续跑 多实例部署时需业务方基于redis或数据库实现
+type RefundProgressStore interface {
+	// Load 获取批次内全部退款的最新进度 key为out_refund_no
+	Load(batchId string) (map[string]RefundProgress, error)
+	// Save 保存单笔退款进度
+	Save(batchId string, progress RefundProgress) error
+}
+
+// RefundBatchReport 批量退款汇总报告
+type RefundBatchReport struct {
+	BatchId   string
+	Total     int
+	Success   int              // 退款成功
+	Fail      int              // 退款失败
+	Process   int              // 微信已受理或结果未知 需再次Run或等待退款回调
+	Pending   int              // 未发起 如ctx取消或进度保存失败
+	Items     []RefundProgress // 与传入的退款请求顺序一致
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// RefundBatchRunner 批量退款执行器 负责并发控制 限流 原单重试以及进度持久化
+type RefundBatchRunner struct {
+	refunder      Refunder
+	store         RefundProgressStore
+	concurrency   int
+	limiter       *Guard
+	maxRetry      int
+	retryInterval time.Duration
+}
+
+// memoryRefundProgressStore 单机内存版进度存储 进程退出后丢失
+type memoryRefundProgressStore struct {
+	mu      sync.Mutex
+	batches map[string]map[string]RefundProgress
+}
+
+// fileRefundProgressStore 本地文件版进度存储 每个批次一个文件 每行一条json进度
+type fileRefundProgressStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+/**
+ * NewRefundBatchRunner 构造批量退款执行器 默认5并发 不限流 原单重试3次
+ * @params refunder 退款客户端 如AppletPay
+ * @params store 进度存储 传nil时使用单机内存存储
+ * @return RefundBatchRunner
+ */
+func NewRefundBatchRunner(refunder Refunder, store RefundProgressStore) *RefundBatchRunner {
+	if store == nil {
+		store = NewMemoryRefundProgressStore()
+	}
+	return &RefundBatchRunner{
+		refunder:      refunder,
+		store:         store,
+		concurrency:   5,
+		maxRetry:      3,
+		retryInterval: time.Second,
+	}
+}
+
+// SetConcurrency 设置同时进行中的退款请求数
+func (r *RefundBatchRunner) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	r.concurrency = concurrency
+}
+
+// SetRate 设置每秒发起的退款请求数上限 rate为0时不限流 重试和查询同样计入
+func (r *RefundBatchRunner) SetRate(rate float64, burst int) {
+	if rate <= 0 {
+		r.limiter = nil
+		return
+	}
+	r.limiter = NewGuard(EndpointPolicy{Rate: rate, Burst: burst, MaxWait: time.Duration(math.MaxInt64)})
+}
+
+// SetRetry 设置SYSTEMERROR或网络异常时的原单重试次数和间隔
+func (r *RefundBatchRunner) SetRetry(maxRetry int, interval time.Duration) {
+	r.maxRetry = maxRetry
+	r.retryInterval = interval
+}
+
+/**
+ * Run 执行批量退款
+ * 同一batchId可重复执行: 已成功或失败的退款直接跳过, 处理中的退款先查询最终状态,
+ * 查询不到时使用相同的out_refund_no重新发起, 不会重复退款
+ *
+ * @params ctx 取消时不再发起新的退款 已发出的请求等待返回
+ * @params batchId 批次号 用于保存和恢复进度
+ * @params requests 退款请求 out_refund_no不能为空且不能重复
+ * @return RefundBatchReport err 参数错误 进度加载失败或ctx取消时不为空 ctx取消时同样返回已完成部分的报告
+ */
+func (r *RefundBatchRunner) Run(ctx context.Context, batchId string, requests []RefundRequests) (report *RefundBatchReport, err error) {
+	if batchId == "" {
+		return nil, errors.New("batchId不能为空")
+	}
+	seen := make(map[string]bool, len(requests))
+	for _, request := range requests {
+		if request.OutRefundNo == "" {
+			return nil, errors.New("out_refund_no不能为空")
+		}
+		if seen[request.OutRefundNo] {
+			return nil, errors.New("out_refund_no重复:" + request.OutRefundNo)
+		}
+		seen[request.OutRefundNo] = true
+	}
+	saved, err := r.store.Load(batchId)
+	if err != nil {
+		return nil, errors.New("加载退款进度异常:" + err.Error())
+	}
+	report = &RefundBatchReport{
+		BatchId:   batchId,
+		Total:     len(requests),
+		Items:     make([]RefundProgress, len(requests)),
+		StartTime: time.Now(),
+	}
+	for i, request := range requests {
+		progress, ok := saved[request.OutRefundNo]
+		if !ok {
+			progress = RefundProgress{
+				OutRefundNo: request.OutRefundNo,
+				OutTradeNo:  request.OutTradeNo,
+				RefundFee:   request.RefundFee,
+				Status:      DEFAULT,
+			}
+		}
+		report.Items[i] = progress
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				r.process(ctx, batchId, requests[index], &report.Items[index])
+			}
+		}()
+	}
+dispatch:
+	for i := range requests {
+		status := report.Items[i].Status
+		if status == REFUND_SUCCESS || status == REFUND_FAIL {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, item := range report.Items {
+		switch item.Status {
+		case REFUND_SUCCESS:
+			report.Success++
+		case REFUND_FAIL:
+			report.Fail++
+		case REFUND_PROCESS:
+			report.Process++
+		default:
+			report.Pending++
+		}
+	}
+	report.EndTime = time.Now()
+	return report, ctx.Err()
+}
+
+// process 处理单笔退款 结果写入progress
+func (r *RefundBatchRunner) process(ctx context.Context, batchId string, request RefundRequests, progress *RefundProgress) {
+	if progress.Status == REFUND_PROCESS && r.query(ctx, batchId, progress) {
+		return
+	}
+	for i := 0; i <= r.maxRetry; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.retryInterval):
+			}
+		}
+		if r.wait(ctx) != nil {
+			return
+		}
+		// 发起前先保存处理中状态 崩溃后可通过查询确认结果
+		previous := *progress
+		progress.Status = REFUND_PROCESS
+		progress.Attempts++
+		if err := r.save(batchId, progress); err != nil {
+			*progress = previous
+			progress.ErrCodeDes = "保存退款进度异常:" + err.Error()
+			return
+		}
+		resp, err := r.refunder.RefundContext(ctx, request)
+		if err != nil {
+			// 网络异常无法确定微信是否已受理 只能原单重试
+			progress.ErrCodeDes = err.Error()
+			r.save(batchId, progress)
+			continue
+		}
+		progress.ErrCode = resp.ErrCode
+		progress.ErrCodeDes = resp.ErrCodeDes
+		if resp.ReturnCode != "SUCCESS" {
+			// 签名错误 参数错误等通信失败 重试无意义
+			progress.Status = REFUND_FAIL
+			progress.ErrCodeDes = resp.ReturnMsg
+			r.save(batchId, progress)
+			return
+		}
+		if resp.ResultCode == "SUCCESS" {
+			// 微信退款为异步处理 受理成功后状态为REFUND_PROCESS
+			progress.RefundId = resp.RefundId
+			r.save(batchId, progress)
+			return
+		}
+		if !inCodes(resp.ErrCode, REFUND_RETRY_CODES) {
+			progress.Status = REFUND_FAIL
+			r.save(batchId, progress)
+			return
+		}
+		r.save(batchId, progress)
+	}
+}
+
+// query 查询处理中退款的最终状态 退款单不存在时返回false由调用方重新发起
+func (r *RefundBatchRunner) query(ctx context.Context, batchId string, progress *RefundProgress) bool {
+	if r.wait(ctx) != nil {
+		return true
+	}
+	resp, err := r.refunder.RefundQueryContext(ctx, r.refunder.NewRefundQueryRequests(progress.OutRefundNo))
+	if err != nil {
+		progress.ErrCodeDes = err.Error()
+		return true
+	}
+	if resp.ReturnCode != "SUCCESS" || resp.ResultCode != "SUCCESS" {
+		if resp.ErrCode == "REFUNDNOTEXIST" {
+			return false
+		}
+		progress.ErrCode = resp.ErrCode
+		progress.ErrCodeDes = resp.ErrCodeDes
+		return true
+	}
+	progress.RefundId = resp.RefundId0
+	progress.ErrCode = ""
+	progress.ErrCodeDes = ""
+	switch resp.RefundStatus0 {
+	case "SUCCESS":
+		progress.Status = REFUND_SUCCESS
+	case "REFUNDCLOSE", "CHANGE":
+		// CHANGE为退款到银行卡失败 需人工处理 批次内按失败统计
+		progress.Status = REFUND_FAIL
+		progress.ErrCode = resp.RefundStatus0
+	default:
+		progress.Status = REFUND_PROCESS
+	}
+	r.save(batchId, progress)
+	return true
+}
+
+// wait 等待限流令牌
+func (r *RefundBatchRunner) wait(ctx context.Context) error {
+	if r.limiter == nil {
+		return ctx.Err()
+	}
+	return r.limiter.Allow(ctx, "", REFUND)
+}
+
+// save 更新时间后保存进度
+func (r *RefundBatchRunner) save(batchId string, progress *RefundProgress) error {
+	progress.UpdateTime = time.Now()
+	return r.store.Save(batchId, *progress)
+}
+
+// inCodes 判断错误码是否在列表中
+func inCodes(code string, codes []string) bool {
+	for _, c := range codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter 按状态筛选退款进度 如report.Filter(REFUND_FAIL)
+func (report *RefundBatchReport) Filter(status int) []RefundProgress {
+	items := make([]RefundProgress, 0)
+	for _, item := range report.Items {
+		if item.Status == status {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// NewMemoryRefundProgressStore 构造单机内存版进度存储 仅适用于单次进程内的重跑
+func NewMemoryRefundProgressStore() RefundProgressStore {
+	return &memoryRefundProgressStore{
+		batches: make(map[string]map[string]RefundProgress),
+	}
+}
+
+func (m *memoryRefundProgressStore) Load(batchId string) (map[string]RefundProgress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make(map[string]RefundProgress, len(m.batches[batchId]))
+	for key, progress := range m.batches[batchId] {
+		result[key] = progress
+	}
+	return result, nil
+}
+
+func (m *memoryRefundProgressStore) Save(batchId string, progress RefundProgress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	batch, ok := m.batches[batchId]
+	if !ok {
+		batch = make(map[string]RefundProgress)
+		m.batches[batchId] = batch
+	}
+	batch[progress.OutRefundNo] = progress
+	return nil
+}
+
+/**
+ * NewFileRefundProgressStore 构造本地文件版进度存储 仅适用于单实例部署
+ * 进度以追加方式写入dir/batchId.log 同一退款以最后一行为准
+ * @params dir 存储目录 不存在时自动创建
+ */
+func NewFileRefundProgressStore(dir string) RefundProgressStore {
+	return &fileRefundProgressStore{dir: dir}
+}
+
+func (f *fileRefundProgressStore) Load(batchId string) (map[string]RefundProgress, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[string]RefundProgress)
+	path, err := f.path(batchId)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		progress := RefundProgress{}
+		// 崩溃时最后一行可能不完整 直接忽略
+		if json.Unmarshal(scanner.Bytes(), &progress) != nil {
+			continue
+		}
+		result[progress.OutRefundNo] = progress
+	}
+	return result, scanner.Err()
+}
+
+func (f *fileRefundProgressStore) Save(batchId string, progress RefundProgress) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path, err := f.path(batchId)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(f.dir, 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// 以换行开头 避免上次崩溃留下的不完整行与本行粘连
+	_, err = file.Write(append(append([]byte("\n"), data...), '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// path 批次文件路径 batchId不能包含路径分隔符
+func (f *fileRefundProgressStore) path(batchId string) (string, error) {
+	if strings.ContainsAny(batchId, `/\`) || batchId == "." || batchId == ".." {
+		return "", errors.New("batchId不合法:" + batchId)
+	}
+	return filepath.Join(f.dir, batchId+".log"), nil
+}
@@ -0,0 +1,89 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// fakeRefunder 按out_refund_no返回预设结果
+type fakeRefunder struct {
+	mu      sync.Mutex
+	refunds map[string][]*RefundRespones
+	queries map[string]*RefundQueryRespones
+	calls   map[string]int
+}
+
+func (f *fakeRefunder) NewRefundQueryRequests(outRefundNo string) RefundQueryRequests {
+	return RefundQueryRequests{OutRefundNo: outRefundNo}
+}
+
+func (f *fakeRefunder) RefundContext(ctx context.Context, request RefundRequests) (*RefundRespones, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[request.OutRefundNo]++
+	results := f.refunds[request.OutRefundNo]
+	if len(results) == 0 {
+		return nil, errors.New("timeout")
+	}
+	f.refunds[request.OutRefundNo] = results[1:]
+	return results[0], nil
+}
+
+func (f *fakeRefunder) RefundQueryContext(ctx context.Context, request RefundQueryRequests) (*RefundQueryRespones, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if resp, ok := f.queries[request.OutRefundNo]; ok {
+		return resp, nil
+	}
+	return &RefundQueryRespones{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "REFUNDNOTEXIST"}, nil
+}
+
+func TestRefundBatchRunnerResume(t *testing.T) {
+	accepted := &RefundRespones{ReturnCode: "SUCCESS", ResultCode: "SUCCESS", RefundId: "R1"}
+	refunder := &fakeRefunder{
+		refunds: map[string][]*RefundRespones{
+			"r1": {{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "SYSTEMERROR"}, accepted},
+			"r2": {{ReturnCode: "SUCCESS", ResultCode: "FAIL", ErrCode: "NOTENOUGH"}},
+		},
+		queries: map[string]*RefundQueryRespones{},
+		calls:   map[string]int{},
+	}
+	dir, err := ioutil.TempDir("", "refund_batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runner := NewRefundBatchRunner(refunder, NewFileRefundProgressStore(dir))
+	runner.SetRetry(1, 0)
+	runner.SetRate(1000, 10)
+	requests := []RefundRequests{{OutRefundNo: "r1"}, {OutRefundNo: "r2"}, {OutRefundNo: "r3"}}
+
+	report, err := runner.Run(context.Background(), "trip-1", requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Process != 2 || report.Fail != 1 || report.Items[0].RefundId != "R1" || report.Items[2].Attempts != 2 {
+		t.Fatalf("第一次执行结果异常: %+v", report)
+	}
+
+	// 续跑: r1查询到退款成功 r3查询不到后重新发起
+	refunder.queries["r1"] = &RefundQueryRespones{ReturnCode: "SUCCESS", ResultCode: "SUCCESS", RefundId0: "R1", RefundStatus0: "SUCCESS"}
+	refunder.refunds["r3"] = []*RefundRespones{accepted}
+	report, err = runner.Run(context.Background(), "trip-1", requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Success != 1 || report.Fail != 1 || report.Process != 1 {
+		t.Fatalf("续跑结果异常: %+v", report)
+	}
+	if refunder.calls["r1"] != 2 || refunder.calls["r2"] != 1 || refunder.calls["r3"] != 3 {
+		t.Errorf("退款请求次数异常: %v", refunder.calls)
+	}
+	if failed := report.Filter(REFUND_FAIL); len(failed) != 1 || failed[0].ErrCode != "NOTENOUGH" {
+		t.Errorf("失败明细异常: %+v", failed)
+	}
+}
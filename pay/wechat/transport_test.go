@@ -0,0 +1,102 @@
+package wechat
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeTransport 按接口path返回预设的xml 记录每个接口的调用次数
+type fakeTransport struct {
+	mu     sync.Mutex
+	calls  map[string]int
+	handle func(path string, body string) string
+}
+
+func (f *fakeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(request.Body)
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]int{}
+	}
+	f.calls[request.URL.Path]++
+	f.mu.Unlock()
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(f.handle(request.URL.Path, string(body)))),
+		Request:    request,
+	}, nil
+}
+
+func (f *fakeTransport) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[path]
+}
+
+// newFakeWechatPay 构造不加载证书 使用指定transport的wechatPay
+func newFakeWechatPay(transport http.RoundTripper) *wechatPay {
+	wechat := NewWechatPay("wx123", "1900000109", "key", "", "")
+	wechat.clientOnce.Do(func() {})
+	wechat.client = &http.Client{Transport: transport}
+	return wechat
+}
+
+// serverTransport 将请求改写到本地测试服务器
+type serverTransport struct {
+	server *httptest.Server
+}
+
+func (s serverTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(s.server.URL)
+	request.URL.Scheme = target.Scheme
+	request.URL.Host = target.Host
+	return s.server.Client().Transport.RoundTrip(request)
+}
+
+// xmlFields 解析请求xml的一级字段
+func xmlFields(body string) map[string]string {
+	fields := map[string]string{}
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	name := ""
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return fields
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			name = token.Name.Local
+		case xml.CharData:
+			if name != "" && name != "xml" {
+				fields[name] += string(token)
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+}
+
+// md5Sign 按微信支付规则计算MD5签名 空值不参与签名
+func md5Sign(fields map[string]string, key string) string {
+	names := make([]string, 0, len(fields))
+	for name, value := range fields {
+		if name != "sign" && value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + fields[name]
+	}
+	return strings.ToUpper(fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(pairs, "&")+"&key="+key))))
+}